@@ -0,0 +1,291 @@
+package awshandler
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/igorrendulic/couchdb-experiment/email/mime/handler"
+)
+
+// name of the header field parsed by ParseAuthenticationResults
+const headerAuthenticationResults = "Authentication-Results"
+
+// authentication methods of the IANA Email Authentication Methods registry, other names (except x- methods)
+// are treated as properties of the preceding result (SES writes client-ip, envelope-from and helo that way)
+var authMethods = map[string]bool{
+	"auth": true, "dkim": true, "dkim-adsp": true, "dkim-atps": true, "dmarc": true, "domainkeys": true, "iprev": true,
+	"rrvs": true, "sender-id": true, "smime": true, "spf": true, "vbr": true, "arc": true, "bimi": true,
+}
+
+// AuthenticationResults is a parsed Authentication-Results header field (RFC 8601).
+// Results of Received notifications are Exchange.AuthResults (Process and middleware),
+// AuthResultsOf parses them from handler.MailReceived returned by HandleSmtp and the adapters.
+type AuthenticationResults struct {
+	AuthServID string        // e.g. amazonses.com
+	Version    string        // empty if not set (version 1)
+	Results    []*AuthResult // empty for "none"
+}
+
+// AuthResult is the result of a single authentication method, e.g. dkim=pass header.i=@gmail.com
+type AuthResult struct {
+	Method     string // lowercase, e.g. spf
+	Version    string // method version (dkim/1), empty if not set
+	Result     string // lowercase, e.g. pass
+	Reason     string
+	Properties []*AuthProperty
+}
+
+// AuthProperty is a property of a method result, e.g. header.from=gmail.com (Type header, Property from).
+// Type is empty for properties without type.
+type AuthProperty struct {
+	Type     string
+	Property string
+	Value    string
+}
+
+// Result returns the first result of method (case-insensitive), nil if there is none
+func (a *AuthenticationResults) Result(method string) *AuthResult {
+	for _, r := range a.Results {
+		if strings.EqualFold(r.Method, method) {
+			return r
+		}
+	}
+	return nil
+}
+
+// Property returns value of the first property with type and name (e.g. "header", "from"), empty if not present
+func (r *AuthResult) Property(ptype string, property string) string {
+	for _, p := range r.Properties {
+		if strings.EqualFold(p.Type, ptype) && strings.EqualFold(p.Property, property) {
+			return p.Value
+		}
+	}
+	return ""
+}
+
+// ParseAuthenticationResults parses value of Authentication-Results header field
+//
+//	amazonses.com; spf=pass (comment) smtp.mailfrom=example.com; dkim=pass header.i=@gmail.com
+func ParseAuthenticationResults(value string) (*AuthenticationResults, error) {
+	p := &authResultsParser{s: value}
+	results, err := p.parse()
+	if err != nil {
+		return nil, &ParseError{Path: headerAuthenticationResults, Offset: int64(p.pos), Err: err}
+	}
+	return results, nil
+}
+
+// AuthResultsOf parses Authentication-Results headers of MIME content of a Received notification as handled by
+// HandleSmtp, Dispatcher, HandleBatch, LambdaHandler and bulk ingestion. Nil if the mail has no MIME content
+// (other notification types or quarantined messages, see Exchange.Quarantine).
+func AuthResultsOf(mail *handler.MailReceived) []*AuthenticationResults {
+	headers := mimeHeaders(mail)
+	if headers == nil {
+		return nil
+	}
+	return parseAuthenticationResultsHeaders(headers)
+}
+
+// parsing all Authentication-Results headers, headers which don't parse are skipped
+func parseAuthenticationResultsHeaders(headers []*HeaderAttribute) []*AuthenticationResults {
+	parsed := []*AuthenticationResults{}
+	for _, h := range headers {
+		if !strings.EqualFold(h.Name, headerAuthenticationResults) {
+			continue
+		}
+		if results, err := ParseAuthenticationResults(h.Value); err == nil {
+			parsed = append(parsed, results)
+		}
+	}
+	return parsed
+}
+
+type authResultsParser struct {
+	s   string
+	pos int
+}
+
+func (p *authResultsParser) parse() (*AuthenticationResults, error) {
+	p.skipCFWS()
+	id, err := p.value()
+	if err != nil {
+		return nil, fmt.Errorf("missing authserv-id: %w", err)
+	}
+	results := &AuthenticationResults{AuthServID: id, Results: []*AuthResult{}}
+
+	p.skipCFWS()
+	if !p.eof() && p.peek() >= '0' && p.peek() <= '9' {
+		results.Version = p.token("")
+	}
+
+	for {
+		p.skipCFWS()
+		if p.eof() {
+			return results, nil
+		}
+		if !p.consume(';') {
+			return nil, fmt.Errorf("expected ; at %d", p.pos)
+		}
+		p.skipCFWS()
+		if p.eof() {
+			return results, nil // trailing semicolon
+		}
+
+		method := strings.ToLower(p.token("=/."))
+		if method == "" {
+			return nil, fmt.Errorf("expected method at %d", p.pos)
+		}
+		p.skipCFWS()
+		if method == "none" && (p.eof() || p.peek() == ';') {
+			continue
+		}
+
+		version := ""
+		if p.consume('/') {
+			p.skipCFWS()
+			version = p.token("=")
+			p.skipCFWS()
+		}
+		if !p.consume('=') {
+			return nil, fmt.Errorf("expected = after %s", method)
+		}
+		p.skipCFWS()
+
+		if !authMethods[method] && !strings.HasPrefix(method, "x-") && len(results.Results) > 0 {
+			value, err := p.value()
+			if err != nil {
+				return nil, err
+			}
+			last := results.Results[len(results.Results)-1]
+			last.Properties = append(last.Properties, &AuthProperty{Property: method, Value: value})
+			continue
+		}
+
+		result := &AuthResult{Method: method, Version: version, Result: strings.ToLower(p.token("")), Properties: []*AuthProperty{}}
+		if result.Result == "" {
+			return nil, fmt.Errorf("missing result of %s", method)
+		}
+		if err := p.properties(result); err != nil {
+			return nil, err
+		}
+		results.Results = append(results.Results, result)
+	}
+}
+
+// reason and properties of a result up to the next ;
+func (p *authResultsParser) properties(result *AuthResult) error {
+	for {
+		p.skipCFWS()
+		if p.eof() || p.peek() == ';' {
+			return nil
+		}
+		name := p.token("=.")
+		if name == "" {
+			return fmt.Errorf("expected property at %d", p.pos)
+		}
+		p.skipCFWS()
+
+		property := &AuthProperty{Property: name}
+		if p.consume('.') {
+			p.skipCFWS()
+			property.Type, property.Property = strings.ToLower(name), p.token("=")
+			p.skipCFWS()
+		}
+		if !p.consume('=') {
+			return fmt.Errorf("expected = after %s", name)
+		}
+		p.skipCFWS()
+		value, err := p.value()
+		if err != nil {
+			return err
+		}
+
+		if property.Type == "" && strings.EqualFold(name, "reason") {
+			result.Reason = value
+			continue
+		}
+		property.Value = value
+		result.Properties = append(result.Properties, property)
+	}
+}
+
+// token or quoted string
+func (p *authResultsParser) value() (string, error) {
+	if !p.eof() && p.peek() == '"' {
+		return p.quoted()
+	}
+	if v := p.token(""); v != "" {
+		return v, nil
+	}
+	return "", fmt.Errorf("expected value at %d", p.pos)
+}
+
+// characters up to whitespace, ;, (, " or any of stop
+func (p *authResultsParser) token(stop string) string {
+	start := p.pos
+	for !p.eof() {
+		c := p.peek()
+		if c == ' ' || c == '\t' || c == '\r' || c == '\n' || c == ';' || c == '(' || c == '"' || strings.IndexByte(stop, c) >= 0 {
+			break
+		}
+		p.pos++
+	}
+	return p.s[start:p.pos]
+}
+
+func (p *authResultsParser) quoted() (string, error) {
+	p.pos++ // opening quote
+	var b strings.Builder
+	for !p.eof() {
+		c := p.s[p.pos]
+		p.pos++
+		switch c {
+		case '\\':
+			if !p.eof() {
+				b.WriteByte(p.s[p.pos])
+				p.pos++
+			}
+		case '"':
+			return b.String(), nil
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return "", errors.New("unterminated quoted string")
+}
+
+// skipping whitespace and (nested) comments
+func (p *authResultsParser) skipCFWS() {
+	depth := 0
+	for !p.eof() {
+		c := p.peek()
+		switch {
+		case c == '(':
+			depth++
+		case c == ')' && depth > 0:
+			depth--
+		case c == '\\' && depth > 0:
+			p.pos++
+		case depth == 0 && c != ' ' && c != '\t' && c != '\r' && c != '\n':
+			return
+		}
+		p.pos++
+	}
+}
+
+func (p *authResultsParser) consume(c byte) bool {
+	if !p.eof() && p.peek() == c {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *authResultsParser) peek() byte {
+	return p.s[p.pos]
+}
+
+func (p *authResultsParser) eof() bool {
+	return p.pos >= len(p.s)
+}
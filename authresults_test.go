@@ -0,0 +1,100 @@
+package awshandler
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/igorrendulic/couchdb-experiment/email/mime/handler"
+)
+
+func TestParseAuthenticationResults(t *testing.T) {
+	results, err := ParseAuthenticationResults(`mx.example.com 1; spf=pass (sender (nested) comment) smtp.mailfrom=example.org;
+	 dkim/1=pass reason="good signature" header.i=@example.org header . s = "sel 1"; dmarc=FAIL header.from=example.org; x-custom=neutral`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if results.AuthServID != "mx.example.com" || results.Version != "1" || len(results.Results) != 4 {
+		t.Fatalf("unexpected results %+v", results)
+	}
+
+	spf := results.Result("SPF")
+	if spf.Result != "pass" || spf.Property("smtp", "mailfrom") != "example.org" {
+		t.Fatalf("unexpected spf %+v", spf)
+	}
+	dkim := results.Result("dkim")
+	if dkim.Version != "1" || dkim.Reason != "good signature" || dkim.Property("header", "i") != "@example.org" || dkim.Property("header", "s") != "sel 1" {
+		t.Fatalf("unexpected dkim %+v", dkim)
+	}
+	if dmarc := results.Result("dmarc"); dmarc.Result != "fail" || dmarc.Property("header", "from") != "example.org" {
+		t.Fatalf("unexpected dmarc %+v", dmarc)
+	}
+	if results.Result("x-custom") == nil || results.Result("arc") != nil {
+		t.Fatal("unexpected results")
+	}
+
+	none, err := ParseAuthenticationResults("example.com; none")
+	if err != nil || len(none.Results) != 0 {
+		t.Fatalf("unexpected none result %+v %v", none, err)
+	}
+
+	for _, invalid := range []string{"", "example.com spf=pass", "example.com; spf", "example.com; dkim=pass header.i", `example.com; dkim=pass reason="open`} {
+		var parseErr *ParseError
+		if _, err := ParseAuthenticationResults(invalid); !errors.As(err, &parseErr) {
+			t.Errorf("%q: expected parse error, got %v", invalid, err)
+		}
+	}
+}
+
+func TestReceivedAuthenticationResults(t *testing.T) {
+	received, err := LoadPayload("test_data/received.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	svc, _, _ := dlLoggingSvc([]byte("mime"))
+	ex, err := newAwsSmtpHandler(svc).Process(received)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ex.AuthResults) != 1 {
+		t.Fatalf("expected authentication results, got %d", len(ex.AuthResults))
+	}
+
+	// SES lists client-ip, envelope-from and helo as separate entries, they are kept as properties of spf
+	results := ex.AuthResults[0]
+	spf := results.Result("spf")
+	if results.AuthServID != "amazonses.com" || spf.Result != "pass" || spf.Property("", "client-ip") != "209.85.128.47" ||
+		spf.Property("", "envelope-from") != "example@example.com" || spf.Property("", "helo") != "mail-wm1-f47.google.com" {
+		t.Fatalf("unexpected spf %+v", spf)
+	}
+	if dkim := results.Result("dkim"); dkim.Property("header", "i") != "@gmail.com" {
+		t.Fatalf("unexpected dkim %+v", dkim)
+	}
+	if dmarc := results.Result("dmarc"); dmarc.Result != "pass" || dmarc.Property("header", "from") != "gmail.com" {
+		t.Fatalf("unexpected dmarc %+v", dmarc)
+	}
+}
+
+func TestAuthResultsOf(t *testing.T) {
+	received, err := LoadPayload("test_data/received.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	svc, _, _ := dlLoggingSvc([]byte("Authentication-Results: amazonses.com;\r\n spf=pass smtp.mailfrom=example.com;\r\n dkim=fail header.i=@example.com\r\nSubject: hi\r\n\r\nhowdi"))
+
+	var results []*AuthenticationResults
+	dispatcher := NewDispatcher(NewAwsSmtpHandler(svc))
+	dispatcher.OnReceived(func(mail *handler.MailReceived) error {
+		results = AuthResultsOf(mail)
+		return nil
+	})
+	if _, err := dispatcher.HandleSmtp(received); err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || results[0].Result("spf").Result != "pass" || results[0].Result("dkim").Result != "fail" {
+		t.Fatalf("unexpected results %+v", results)
+	}
+
+	if results := AuthResultsOf(&handler.MailReceived{NotificationType: "Bounce"}); results != nil {
+		t.Fatalf("expected no results without mime content, got %+v", results)
+	}
+}
@@ -0,0 +1,128 @@
+package awshandler
+
+import (
+	"mime"
+	"net/mail"
+	"net/textproto"
+	"regexp"
+	"strings"
+
+	"github.com/igorrendulic/couchdb-experiment/email/mime/handler"
+)
+
+// AutoReplyKind classifies automatically sent messages
+type AutoReplyKind string
+
+const (
+	AutoReplyVacation      AutoReplyKind = "vacation"       // out-of-office reply
+	AutoReplyResponder     AutoReplyKind = "auto-reply"     // other automatic replies (RFC 3834 auto-replied)
+	AutoReplyAutoGenerated AutoReplyKind = "auto-generated" // notifications not replying to a message
+	AutoReplyBulk          AutoReplyKind = "bulk"           // Precedence bulk, list or junk (e.g. mailing lists), not a reply but shouldn't be answered (RFC 3834 section 2)
+)
+
+// precedence of kinds when several signals are present, most specific first
+var autoReplyKinds = []AutoReplyKind{AutoReplyVacation, AutoReplyResponder, AutoReplyAutoGenerated, AutoReplyBulk}
+
+// subject prefixes of out-of-office replies (Outlook prefixes all its OOF replies with "Automatic reply:"),
+// "out of office" and "ooo" only followed by a colon or alone ("Out of office party" is sent by a person)
+var vacationSubjectPattern = regexp.MustCompile(`(?i)^\s*(automatic reply|out of (the )?office (auto[- ]?)?reply|abwesenheitsnotiz|réponse automatique|respuesta automática|risposta automatica|afwezigheidsbericht|(out of (the )?office|ooo)\s*(:|$))`)
+
+// subject prefixes of other autoresponders
+var autoReplySubjectPattern = regexp.MustCompile(`(?i)^\s*\[?(auto[- ]?(reply|response|responder|antwort)|automated (reply|response)|automatische antwort)\b`)
+
+// AutoReply is the classification of an automatically sent Received message.
+// Classification of Received notifications is Exchange.AutoReply (Process and middleware),
+// AutoReplyOf classifies handler.MailReceived returned by HandleSmtp and the adapters.
+type AutoReply struct {
+	Kind    AutoReplyKind `json:"kind"`
+	Reasons []string      `json:"reasons"` // signals the classification is based on, e.g. "Auto-Submitted: auto-replied"
+}
+
+// ClassifyAutoReply detects automatically sent messages from Auto-Submitted (RFC 3834), X-Autoreply, X-Autorespond,
+// X-Autogenerated and Precedence headers and out-of-office subjects. Returns nil for messages sent by a person.
+// Precedence bulk, list or junk is AutoReplyBulk unless there are signals of an automatic reply.
+func ClassifyAutoReply(header mail.Header) *AutoReply {
+	signals := map[AutoReplyKind][]string{}
+	signal := func(kind AutoReplyKind, name string, value string) {
+		signals[kind] = append(signals[kind], name+": "+value)
+	}
+
+	for _, value := range header[textproto.CanonicalMIMEHeaderKey("Auto-Submitted")] {
+		// auto-submitted keyword followed by optional parameters
+		keyword := strings.ToLower(strings.TrimSpace(strings.SplitN(value, ";", 2)[0]))
+		switch keyword {
+		case "", "no":
+		case "auto-replied":
+			signal(AutoReplyResponder, "Auto-Submitted", keyword)
+		default:
+			signal(AutoReplyAutoGenerated, "Auto-Submitted", keyword)
+		}
+	}
+	for _, name := range []string{"X-Autoreply", "X-Autorespond"} {
+		for _, value := range header[textproto.CanonicalMIMEHeaderKey(name)] {
+			if value = strings.TrimSpace(value); value != "" && !strings.EqualFold(value, "no") {
+				signal(AutoReplyResponder, name, value)
+			}
+		}
+	}
+	for _, value := range header[textproto.CanonicalMIMEHeaderKey("X-Autogenerated")] {
+		if value = strings.TrimSpace(value); strings.EqualFold(value, "reply") {
+			signal(AutoReplyResponder, "X-Autogenerated", value)
+		} else if value != "" {
+			signal(AutoReplyAutoGenerated, "X-Autogenerated", value)
+		}
+	}
+	for _, name := range []string{"Precedence", "X-Precedence"} {
+		for _, value := range header[textproto.CanonicalMIMEHeaderKey(name)] {
+			switch value = strings.ToLower(strings.TrimSpace(value)); value {
+			case "auto_reply":
+				signal(AutoReplyResponder, name, value)
+			case "bulk", "list", "junk":
+				signal(AutoReplyBulk, name, value)
+			}
+		}
+	}
+
+	subject := header.Get("Subject")
+	if decoded, err := new(mime.WordDecoder).DecodeHeader(subject); err == nil {
+		subject = decoded
+	}
+	if vacationSubjectPattern.MatchString(subject) {
+		signal(AutoReplyVacation, "Subject", subject)
+	} else if autoReplySubjectPattern.MatchString(subject) {
+		signal(AutoReplyResponder, "Subject", subject)
+	}
+
+	for _, kind := range autoReplyKinds {
+		if len(signals[kind]) == 0 {
+			continue
+		}
+		// an out-of-office subject refines auto-replied headers, reasons list all signals
+		reply := &AutoReply{Kind: kind}
+		for _, k := range autoReplyKinds {
+			reply.Reasons = append(reply.Reasons, signals[k]...)
+		}
+		return reply
+	}
+	return nil
+}
+
+// AutoReplyOf classifies a Received notification as handled by HandleSmtp, Dispatcher, HandleBatch, LambdaHandler
+// and bulk ingestion by headers of its MIME content. Nil for messages sent by a person and mail without MIME content.
+func AutoReplyOf(mail *handler.MailReceived) *AutoReply {
+	headers := mimeHeaders(mail)
+	if headers == nil {
+		return nil
+	}
+	return classifyAutoReply(headers)
+}
+
+// classifying Received notification by its headers
+func classifyAutoReply(headers []*HeaderAttribute) *AutoReply {
+	header := mail.Header{}
+	for _, h := range headers {
+		name := textproto.CanonicalMIMEHeaderKey(h.Name)
+		header[name] = append(header[name], h.Value)
+	}
+	return ClassifyAutoReply(header)
+}
@@ -0,0 +1,99 @@
+package awshandler
+
+import (
+	"encoding/json"
+	"net/mail"
+	"reflect"
+	"testing"
+
+	"github.com/igorrendulic/couchdb-experiment/email/mime/handler"
+)
+
+func TestClassifyAutoReply(t *testing.T) {
+	tests := []struct {
+		name    string
+		header  mail.Header
+		kind    AutoReplyKind
+		reasons []string
+	}{
+		{"person", mail.Header{"Subject": {"Re: out of office plans"}, "Auto-Submitted": {"no"}}, "", nil},
+		{"rfc 3834", mail.Header{"Auto-Submitted": {"auto-replied; owner-email=\"jane@example.com\""}}, AutoReplyResponder, []string{"Auto-Submitted: auto-replied"}},
+		{"notification", mail.Header{"Auto-Submitted": {"Auto-Generated"}}, AutoReplyAutoGenerated, []string{"Auto-Submitted: auto-generated"}},
+		{"outlook oof", mail.Header{"Subject": {"Automatic reply: Invoice 42"}, "Auto-Submitted": {"auto-replied"}, "X-Auto-Response-Suppress": {"All"}},
+			AutoReplyVacation, []string{"Subject: Automatic reply: Invoice 42", "Auto-Submitted: auto-replied"}},
+		{"encoded subject", mail.Header{"Subject": {"=?UTF-8?Q?R=C3=A9ponse_automatique_:_Facture?="}}, AutoReplyVacation, []string{"Subject: Réponse automatique : Facture"}},
+		{"x-autoreply", mail.Header{"X-Autoreply": {"yes"}}, AutoReplyResponder, []string{"X-Autoreply: yes"}},
+		{"x-autorespond", mail.Header{"X-Autorespond": {"Thanks for contacting support"}}, AutoReplyResponder, []string{"X-Autorespond: Thanks for contacting support"}},
+		{"x-autogenerated", mail.Header{"X-Autogenerated": {"Reply"}}, AutoReplyResponder, []string{"X-Autogenerated: Reply"}},
+		{"precedence auto_reply", mail.Header{"Precedence": {"auto_reply"}}, AutoReplyResponder, []string{"Precedence: auto_reply"}},
+		{"bulk", mail.Header{"Precedence": {"Bulk"}}, AutoReplyBulk, []string{"Precedence: bulk"}},
+		{"mailing list", mail.Header{"Subject": {"Out of office party on Friday"}, "Precedence": {"list"}}, AutoReplyBulk, []string{"Precedence: list"}},
+		{"out of office", mail.Header{"Subject": {"Out of Office: back on Monday"}}, AutoReplyVacation, []string{"Subject: Out of Office: back on Monday"}},
+		{"out of office reply", mail.Header{"Subject": {"Out of office AutoReply - Jane Doe"}}, AutoReplyVacation, []string{"Subject: Out of office AutoReply - Jane Doe"}},
+		{"ooo", mail.Header{"Subject": {"OOO"}}, AutoReplyVacation, []string{"Subject: OOO"}},
+		{"person ooo", mail.Header{"Subject": {"ooooh nice"}}, "", nil},
+		{"subject autoresponder", mail.Header{"Subject": {"[Auto-Reply] We received your request"}, "Precedence": {"list"}},
+			AutoReplyResponder, []string{"Subject: [Auto-Reply] We received your request", "Precedence: list"}},
+	}
+	for _, test := range tests {
+		got := ClassifyAutoReply(test.header)
+		if test.kind == "" {
+			if got != nil {
+				t.Errorf("%s: expected no classification, got %+v", test.name, got)
+			}
+			continue
+		}
+		if got == nil || got.Kind != test.kind || !reflect.DeepEqual(got.Reasons, test.reasons) {
+			t.Errorf("%s: expected %s %v, got %+v", test.name, test.kind, test.reasons, got)
+		}
+	}
+}
+
+func TestReceivedAutoReply(t *testing.T) {
+	message, err := LoadPayload("test_data/received.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	svc, _, _ := dlLoggingSvc([]byte("mime"))
+	h := newAwsSmtpHandler(svc)
+	ex, err := h.Process(message)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ex.AutoReply != nil {
+		t.Fatalf("unexpected classification %+v", ex.AutoReply)
+	}
+
+	var messageJson MessageJSON
+	if err := json.Unmarshal(message, &messageJson); err != nil {
+		t.Fatal(err)
+	}
+	messageJson.Mail.Headers = append(messageJson.Mail.Headers, &HeaderAttribute{Name: "auto-submitted", Value: "auto-replied"})
+	if message, err = json.Marshal(&messageJson); err != nil {
+		t.Fatal(err)
+	}
+	if ex, err = h.Process(message); err != nil {
+		t.Fatal(err)
+	}
+	if ex.AutoReply == nil || ex.AutoReply.Kind != AutoReplyResponder {
+		t.Fatalf("expected auto-reply, got %+v", ex.AutoReply)
+	}
+}
+
+func TestAutoReplyOf(t *testing.T) {
+	message, err := LoadPayload("test_data/received.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	svc, _, _ := dlLoggingSvc([]byte("Subject: Automatic reply: invoice\r\nAuto-Submitted: auto-replied\r\n\r\naway"))
+	received, err := NewAwsSmtpHandler(svc).HandleSmtp(message)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply := AutoReplyOf(received); reply == nil || reply.Kind != AutoReplyVacation {
+		t.Fatalf("expected vacation reply, got %+v", reply)
+	}
+	if reply := AutoReplyOf(&handler.MailReceived{NotificationType: "Bounce"}); reply != nil {
+		t.Fatalf("expected no classification without mime content, got %+v", reply)
+	}
+}
@@ -1,8 +1,12 @@
 package awshandler
 
 import (
-	"encoding/json"
+	"bytes"
+	"context"
 	"errors"
+	"io"
+	"net/http"
+	"net/mail"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
@@ -11,198 +15,434 @@ import (
 	"github.com/aws/aws-sdk-go/service/s3/s3manager"
 	"github.com/igorrendulic/couchdb-email-aws-parse/sns"
 	"github.com/igorrendulic/couchdb-experiment/email/mime/handler"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
-// errors
+// errors (see errors.go for typed errors wrapping these)
 var S3FileNotFoundError = errors.New("s3 mime content file not found")
 var SignatureInvalid = errors.New("could not verify signature")
 
 type AwsSmtpHandler struct {
-	svc s3iface.S3API
+	svc                 s3iface.S3API
+	middleware          []Middleware
+	metrics             Metrics
+	verifier            *sns.Verifier
+	tracer              trace.Tracer
+	logger              Logger
+	redaction           RedactionPolicy
+	timeouts            Timeouts
+	httpClient          *http.Client
+	topics              map[string]bool // allowed SNS topics, all if empty
+	maxMessageSize      int64
+	maxMimeSize         int64
+	verifySubscriptions bool
+	verifyNotifications bool
+	requireEnvelope     bool
+	clock               Clock
+	strictTimestamps    bool
+	quarantine          QuarantinePolicy
 }
 
+var _ handler.SmtpHandler = (*AwsSmtpHandler)(nil)
+
+// NewAwsSmtpHandler creates handler with default configuration, use NewAwsSmtpHandlerWithOptions(WithS3(svc))
+// for *AwsSmtpHandler (Process, Use, ...)
 func NewAwsSmtpHandler(svc s3iface.S3API) handler.SmtpHandler {
+	return newAwsSmtpHandler(svc)
+}
+
+func newAwsSmtpHandler(svc s3iface.S3API) *AwsSmtpHandler {
 	return &AwsSmtpHandler{
-		svc: svc,
+		svc:                 svc,
+		verifier:            &sns.Verifier{},
+		tracer:              trace.NewNoopTracerProvider().Tracer(tracerName),
+		logger:              nopLogger{},
+		redaction:           DefaultRedactionPolicy,
+		topics:              map[string]bool{},
+		verifySubscriptions: true,
+		verifyNotifications: true,
+		clock:               systemClock{},
 	}
 }
 
 // Handle SMTP SNS topic notification received by AWS SES (Simple Email Service)
 func (p *AwsSmtpHandler) HandleSmtp(message []byte) (*handler.MailReceived, error) {
+	return p.HandleSmtpContext(context.Background(), message)
+}
+
+// HandleSmtpContext is HandleSmtp with signature verification, subscription confirmation and S3 download bound to ctx
+// (e.g. context of the incoming HTTP request). Quarantined and rejected messages return QuarantineError.
+func (p *AwsSmtpHandler) HandleSmtpContext(ctx context.Context, message []byte) (*handler.MailReceived, error) {
+	ex, err := p.ProcessContext(ctx, message)
+	if err != nil {
+		return nil, err
+	}
+	return output(ex)
+}
+
+// output of handled exchange, verdict of quarantined messages is returned as error
+func output(ex *Exchange) (*handler.MailReceived, error) {
+	if ex.Quarantine != nil {
+		return nil, &QuarantineError{Action: ex.Quarantine.Action, Reasons: ex.Quarantine.Reasons}
+	}
+	return ex.Output, nil
+}
+
+// Process handles message the same way as HandleSmtp and returns all intermediate results (see Exchange).
+// Exchange is returned also on error with whatever was parsed before the failure.
+func (p *AwsSmtpHandler) Process(message []byte) (*Exchange, error) {
+	return p.ProcessContext(context.Background(), message)
+}
+
+// ProcessContext is Process bound to ctx (see HandleSmtpContext)
+func (p *AwsSmtpHandler) ProcessContext(ctx context.Context, message []byte) (*Exchange, error) {
+	ctx, span := p.tracer.Start(ctx, "ses.HandleSmtp")
+	ex := newExchange(ctx, message)
+	return ex, p.finish(span, ex, p.process(ctx, ex))
+}
+
+// finishing handling of a message
+func (p *AwsSmtpHandler) finish(span trace.Span, ex *Exchange, err error) error {
+	err = skipped(err)
+	p.recordMetrics(ex, err)
+	p.logResult(ex, err)
+	span.SetAttributes(exchangeAttributes(ex)...)
+	endSpan(span, err)
+	return err
+}
+
+func (p *AwsSmtpHandler) process(ctx context.Context, ex *Exchange) error {
+	if err := p.checkMessageSize(ex); err != nil {
+		return err
+	}
+	if err := p.intercept(StageRaw, ex); err != nil {
+		return err
+	}
+
+	message := ex.Raw
+	path := ""
 
 	var commonMessage map[string]interface{}
-	unmErr := json.Unmarshal(message, &commonMessage)
+	unmErr := unmarshalJSON(message, &commonMessage, path)
 	if unmErr != nil {
-		return nil, unmErr
+		return unmErr
 	}
 
-	// subscription confirmation handling (confirming by visiting SubscribeURL in the confirmation message)
-	if commonMessage["Type"] == "SubscriptionConfirmation" {
-		var notificationPayload sns.Payload
-		err := json.Unmarshal(message, &notificationPayload)
+	// raw message delivery and EventBridge events carry no signature
+	if _, ok := commonMessage["Type"].(string); !ok && p.requireEnvelope {
+		return &SignatureError{Err: errors.New("message isn't an sns envelope")}
+	}
+
+	// SES events published to Amazon EventBridge
+	if _, ok := commonMessage["detail-type"]; ok {
+		messageJson, err := decodeEventBridge(message)
 		if err != nil {
-			return nil, err
+			return err
 		}
+		ex.Message = messageJson
+		return p.processMessage(ctx, ex)
+	}
 
-		verifyErr := notificationPayload.VerifyPayload()
-		if verifyErr != nil {
-			return nil, SignatureInvalid
+	// SNS envelope (subscription confirmation or notification without raw message delivery enabled)
+	if envelopeType, ok := commonMessage["Type"].(string); ok {
+		var notificationPayload sns.Payload
+		err := unmarshalJSON(message, &notificationPayload, path)
+		if err != nil {
+			return err
 		}
-
-		ts, tsErr := time.Parse(time.RFC3339, notificationPayload.Timestamp)
-		if tsErr != nil {
-			return nil, tsErr
+		ex.Envelope = &notificationPayload
+		// middleware and unwrapping only ever see envelopes of allowed topics with verified signature
+		if err := p.checkEnvelope(ctx, envelopeType, ex.Envelope); err != nil {
+			return err
+		}
+		if err := p.intercept(StageEnvelope, ex); err != nil {
+			return err
 		}
 
-		if notificationPayload.Type == "SubscriptionConfirmation" {
-			_, err := notificationPayload.Subscribe()
-			if err != nil {
-				return nil, err
-			}
-			return &handler.MailReceived{
-				NotificationType: notificationPayload.Type,
-				Timestamp:        ts.UnixMilli(),
-			}, nil
-		} else {
-			return nil, errors.New("unexpected notification type")
+		if envelopeType == string(NotificationTypeSubscriptionConfirmation) {
+			// confirming by visiting SubscribeURL in the confirmation message
+			return p.confirmSubscription(ctx, ex)
 		}
+		message = []byte(notificationPayload.Message)
+		path = "Message"
 	}
 
 	// handling all other SES message types
 	var messageJson MessageJSON
-	errMj := json.Unmarshal(message, &messageJson)
+	errMj := unmarshalJSON(message, &messageJson, path)
 	if errMj != nil {
-		return nil, errMj
+		return errMj
+	}
+	if messageJson.NotificationType == "" {
+		// SES event publishing (e.g. Kinesis Firehose archives)
+		messageJson.NotificationType = messageJson.EventType
+	}
+	ex.Message = &messageJson
+
+	return p.processMessage(ctx, ex)
+}
+
+// mapping parsed SES notification to output
+func (p *AwsSmtpHandler) processMessage(ctx context.Context, ex *Exchange) error {
+	if err := p.intercept(StageMessage, ex); err != nil {
+		return err
+	}
+
+	ex.Quarantine = p.quarantine.evaluate(ex.Message)
+	output, err := p.handleMessage(ctx, ex)
+	if err != nil {
+		return err
+	}
+	ex.Output = output
+
+	return p.intercept(StageOutput, ex)
+}
+
+// checking topic allow-list, type and signature of SNS envelope
+func (p *AwsSmtpHandler) checkEnvelope(ctx context.Context, envelopeType string, payload *sns.Payload) error {
+	if len(p.topics) > 0 && !p.topics[payload.TopicArn] {
+		return &TopicNotAllowedError{TopicArn: payload.TopicArn}
+	}
+
+	verify := false
+	switch envelopeType {
+	case string(NotificationTypeSubscriptionConfirmation):
+		verify = p.verifySubscriptions
+	case "Notification":
+		verify = p.verifyNotifications
+	default:
+		return &UnsupportedTypeError{Type: envelopeType}
+	}
+	if verify {
+		return p.verifyEnvelope(ctx, payload)
+	}
+	return nil
+}
+
+func (p *AwsSmtpHandler) confirmSubscription(ctx context.Context, ex *Exchange) error {
+	notificationPayload := ex.Envelope
+
+	ts, tsErr := time.Parse(time.RFC3339, notificationPayload.Timestamp)
+	if tsErr != nil {
+		return &ParseError{Path: "Timestamp", Err: tsErr}
+	}
+
+	subscribeCtx, span := p.tracer.Start(ctx, "sns.Subscribe", trace.WithAttributes(
+		attribute.String("sns.topic_arn", notificationPayload.TopicArn),
+	))
+	subscribeCtx, cancel := withTimeout(subscribeCtx, p.timeouts.Subscribe)
+	_, err := notificationPayload.SubscribeWithClient(subscribeCtx, p.httpClient)
+	cancel()
+	endSpan(span, err)
+	if err != nil {
+		return &SubscriptionError{Err: err}
+	}
+	ex.Output = &handler.MailReceived{
+		NotificationType: notificationPayload.Type,
+		Timestamp:        ts.UnixMilli(),
+	}
+
+	return p.intercept(StageOutput, ex)
+}
+
+// verifying signature of SNS envelope
+func (p *AwsSmtpHandler) verifyEnvelope(ctx context.Context, payload *sns.Payload) error {
+	ctx, cancel := withTimeout(ctx, p.timeouts.Verify)
+	defer cancel()
+	if err := p.verifier.VerifyContext(ctx, payload); err != nil {
+		return &SignatureError{Err: err}
+	}
+	return nil
+}
+
+func (p *AwsSmtpHandler) checkMessageSize(ex *Exchange) error {
+	if p.maxMessageSize > 0 && int64(len(ex.Raw)) > p.maxMessageSize {
+		return &SizeLimitError{What: "message", Limit: p.maxMessageSize}
+	}
+	return nil
+}
+
+// maps SES notification of a single type to MailReceived
+type notificationMapper func(p *AwsSmtpHandler, ctx context.Context, output *handler.MailReceived, ex *Exchange) (*handler.MailReceived, error)
+
+// supported SES notification types, new types only need a mapper registered here
+var notificationMappers = map[NotificationType]notificationMapper{
+	NotificationTypeReceived:  (*AwsSmtpHandler).mapReceived,
+	NotificationTypeBounce:    (*AwsSmtpHandler).mapBounce,
+	NotificationTypeComplaint: (*AwsSmtpHandler).mapComplaint,
+	NotificationTypeDelivery:  (*AwsSmtpHandler).mapDelivery,
+}
+
+// mapping SES notification to MailReceived
+func (p *AwsSmtpHandler) handleMessage(ctx context.Context, ex *Exchange) (output *handler.MailReceived, err error) {
+	messageJson := ex.Message
+	ctx, span := p.tracer.Start(ctx, "ses.Map", trace.WithAttributes(
+		attribute.String("ses.notification_type", messageJson.NotificationType),
+	))
+	defer func() { endSpan(span, err) }()
+
+	mapper, ok := notificationMappers[NotificationType(messageJson.NotificationType)]
+	if !ok {
+		return nil, &UnsupportedTypeError{Type: messageJson.NotificationType}
 	}
 
-	output := &handler.MailReceived{}
+	ts, err := p.notificationTimestamp(messageJson)
+	if err != nil {
+		return nil, err
+	}
+
+	output = &handler.MailReceived{}
 	output.NotificationType = messageJson.NotificationType
-	output.Timestamp = time.Now().UnixMilli()
+	output.Timestamp = ts.UnixMilli()
 
-	if messageJson.NotificationType == "Received" {
-		mail := messageJson.Mail
-		receipt := messageJson.Receipt
+	return mapper(p, ctx, output, ex)
+}
 
-		bucket, key, bkErr := p.extractS3PathToContent(receipt)
-		if bkErr != nil {
-			return nil, bkErr
-		}
-		mimeBytes, mErr := p.downloadS3File(p.svc, bucket, key)
+func (p *AwsSmtpHandler) mapReceived(ctx context.Context, output *handler.MailReceived, ex *Exchange) (*handler.MailReceived, error) {
+	messageJson := ex.Message
+	mail := messageJson.Mail
+	receipt := messageJson.Receipt
+
+	bucket, key, bkErr := p.extractS3PathToContent(receipt)
+	if bkErr != nil {
+		return nil, bkErr
+	}
+	var mimeBytes []byte
+	// rejected messages aren't downloaded, quarantined are isolated from the output
+	if ex.Quarantine == nil || ex.Quarantine.Action != VerdictReject {
+		downloaded, mErr := p.downloadS3File(ctx, p.svc, bucket, key)
 		if mErr != nil {
 			return nil, mErr
 		}
+		if ex.Quarantine != nil {
+			ex.Quarantine.RawMime = downloaded
+		} else {
+			mimeBytes = downloaded
+		}
+	}
 
-		output = p.augmentWithMail(output, mail, mimeBytes)
+	output = p.augmentWithMail(output, mail, mimeBytes)
+	if mail != nil {
+		ex.AuthResults = parseAuthenticationResultsHeaders(mail.Headers)
+		ex.Trace = parseReceivedHeaders(mail.Headers)
+		ex.AutoReply = classifyAutoReply(mail.Headers)
+	}
 
-		s3Url := "s3://" + receipt.Action.BucketName
-		if receipt.Action.ObjectKeyPrefix != "" {
-			s3Url += "/" + receipt.Action.ObjectKeyPrefix
-		}
-		s3Url += "/" + receipt.Action.ObjectKey
-
-		output.Receipt = &handler.Receipt{
-			Action: &handler.Action{
-				Type:      receipt.Action.Type,
-				Topic:     receipt.Action.TopicArn,
-				ObjectURL: s3Url,
-			},
-			Recipients:           receipt.Recipients,
-			ProcessingTimeMillis: receipt.ProcessingTimeMillis,
-			SpamVerdict: &handler.VerdictStatus{
-				Status: receipt.SpamVerdict.Status,
-			},
-			VirusVerdict: &handler.VerdictStatus{
-				Status: receipt.VirusVerdict.Status,
-			},
-			SpfVerdict: &handler.VerdictStatus{
-				Status: receipt.SpfVerdict.Status,
-			},
-			DkimVerdict: &handler.VerdictStatus{
-				Status: receipt.DkimVerdict.Status,
-			},
-		}
+	s3Url := "s3://" + receipt.Action.BucketName
+	if receipt.Action.ObjectKeyPrefix != "" {
+		s3Url += "/" + receipt.Action.ObjectKeyPrefix
+	}
+	s3Url += "/" + receipt.Action.ObjectKey
+
+	output.Receipt = &handler.Receipt{
+		Action: &handler.Action{
+			Type:      receipt.Action.Type,
+			Topic:     receipt.Action.TopicArn,
+			ObjectURL: s3Url,
+		},
+		Recipients:           receipt.Recipients,
+		ProcessingTimeMillis: receipt.ProcessingTimeMillis,
+		SpamVerdict: &handler.VerdictStatus{
+			Status: receipt.SpamVerdict.Status,
+		},
+		VirusVerdict: &handler.VerdictStatus{
+			Status: receipt.VirusVerdict.Status,
+		},
+		SpfVerdict: &handler.VerdictStatus{
+			Status: receipt.SpfVerdict.Status,
+		},
+		DkimVerdict: &handler.VerdictStatus{
+			Status: receipt.DkimVerdict.Status,
+		},
+	}
 
-		return output, nil
-	} else if messageJson.NotificationType == "Bounce" {
-		bounce := messageJson.Bounce
-		mail := messageJson.Mail
-
-		output = p.augmentWithMail(output, mail, nil)
-
-		if bounce != nil {
-			recipients := make([]*handler.BouncedRecipient, len(bounce.BouncedRecipients))
-			for i, r := range bounce.BouncedRecipients {
-				recipients[i] = &handler.BouncedRecipient{
-					EmailAddress:   r.EmailAddress,
-					Action:         r.Action,
-					Status:         r.Status,
-					DiagnosticCode: r.DiagnosticCode,
-				}
-			}
-			output.Bounce = &handler.Bounce{
-				BounceType:        bounce.BounceType,
-				BounceSubType:     bounce.BounceSubType,
-				BouncedRecipients: recipients,
-				ReportingMTA:      bounce.ReportingMTA,
+	return output, nil
+}
+
+func (p *AwsSmtpHandler) mapBounce(ctx context.Context, output *handler.MailReceived, ex *Exchange) (*handler.MailReceived, error) {
+	messageJson := ex.Message
+	bounce := messageJson.Bounce
+	mail := messageJson.Mail
+
+	output = p.augmentWithMail(output, mail, nil)
+
+	if bounce != nil {
+		recipients := make([]*handler.BouncedRecipient, len(bounce.BouncedRecipients))
+		for i, r := range bounce.BouncedRecipients {
+			recipients[i] = &handler.BouncedRecipient{
+				EmailAddress:   r.EmailAddress,
+				Action:         r.Action,
+				Status:         r.Status,
+				DiagnosticCode: r.DiagnosticCode,
 			}
 		}
-		return output, nil
-
-	} else if messageJson.NotificationType == "Complaint" {
+		output.Bounce = &handler.Bounce{
+			BounceType:        bounce.BounceType,
+			BounceSubType:     bounce.BounceSubType,
+			BouncedRecipients: recipients,
+			ReportingMTA:      bounce.ReportingMTA,
+		}
+	}
+	return output, nil
+}
 
-		complaint := messageJson.Complaint
-		mail := messageJson.Mail
+func (p *AwsSmtpHandler) mapComplaint(ctx context.Context, output *handler.MailReceived, ex *Exchange) (*handler.MailReceived, error) {
+	messageJson := ex.Message
+	complaint := messageJson.Complaint
+	mail := messageJson.Mail
 
-		output = p.augmentWithMail(output, mail, nil)
+	output = p.augmentWithMail(output, mail, nil)
 
-		if complaint != nil {
-			recipients := make([]*handler.ComplainedRecipient, len(complaint.ComplainedRecipients))
-			for i, r := range complaint.ComplainedRecipients {
-				recipients[i] = &handler.ComplainedRecipient{
-					EmailAddress: r.EmailAddress,
-				}
-			}
-			output.Complaint = &handler.Complaint{
-				UserAgent:             complaint.UserAgent,
-				ComplainedRecipients:  recipients,
-				ComplaintFeedbackType: complaint.ComplaintFeedbackType,
+	if complaint != nil {
+		recipients := make([]*handler.ComplainedRecipient, len(complaint.ComplainedRecipients))
+		for i, r := range complaint.ComplainedRecipients {
+			recipients[i] = &handler.ComplainedRecipient{
+				EmailAddress: r.EmailAddress,
 			}
 		}
+		output.Complaint = &handler.Complaint{
+			UserAgent:             complaint.UserAgent,
+			ComplainedRecipients:  recipients,
+			ComplaintFeedbackType: complaint.ComplaintFeedbackType,
+		}
+	}
 
-		return output, nil
-
-	} else if messageJson.NotificationType == "Delivery" {
-
-		delivery := messageJson.Delivery
-		mail := messageJson.Mail
+	return output, nil
+}
 
-		output = p.augmentWithMail(output, mail, nil)
+func (p *AwsSmtpHandler) mapDelivery(ctx context.Context, output *handler.MailReceived, ex *Exchange) (*handler.MailReceived, error) {
+	messageJson := ex.Message
+	delivery := messageJson.Delivery
+	mail := messageJson.Mail
 
-		if delivery != nil {
-			ts := time.Now().UnixMilli()
-			if delivery.Timestamp != "" {
-				// silent fail on parsing timestamp
-				t, err := time.Parse(time.RFC3339, delivery.Timestamp)
-				if err == nil {
-					ts = t.UnixMilli()
+	output = p.augmentWithMail(output, mail, nil)
 
-				}
-			}
-			output.Delivery = &handler.Delivery{
-				Timestamp:            ts,
-				ProcessingTimeMillis: delivery.ProcessingTimeMillis,
-				SmtpResponse:         delivery.SmtpResponse,
-			}
+	if delivery != nil {
+		sources := []timestampSource{{"delivery.timestamp", delivery.Timestamp}}
+		if mail != nil {
+			sources = append(sources, timestampSource{"mail.timestamp", mail.Timestamp})
+		}
+		ts, err := p.parseTimestamp(sources...)
+		if err != nil {
+			return nil, err
+		}
+		output.Delivery = &handler.Delivery{
+			Timestamp:            ts.UnixMilli(),
+			ProcessingTimeMillis: delivery.ProcessingTimeMillis,
+			SmtpResponse:         delivery.SmtpResponse,
 		}
-
-		return output, nil
 	}
-	return nil, errors.New("unknown notification type")
 
+	return output, nil
 }
 
 // augmenting MailReceived with Mail portion of the AWS SNS response
 func (p *AwsSmtpHandler) augmentWithMail(output *handler.MailReceived, mail *Mail, mimeBytes []byte) *handler.MailReceived {
+	if mail == nil {
+		return output
+	}
+
 	output.Mail = &handler.Mail{
 		RawMime:     mimeBytes,
 		Source:      mail.Source,
@@ -221,6 +461,24 @@ func (p *AwsSmtpHandler) augmentWithMail(output *handler.MailReceived, mail *Mai
 	return output
 }
 
+// header fields of MIME content of the mail, nil if it has none (not a Received notification or quarantined)
+func mimeHeaders(m *handler.MailReceived) []*HeaderAttribute {
+	if m == nil || m.Mail == nil || len(m.Mail.RawMime) == 0 {
+		return nil
+	}
+	msg, err := mail.ReadMessage(bytes.NewReader(m.Mail.RawMime))
+	if err != nil {
+		return nil
+	}
+	headers := []*HeaderAttribute{}
+	for name, values := range msg.Header {
+		for _, value := range values {
+			headers = append(headers, &HeaderAttribute{Name: name, Value: value})
+		}
+	}
+	return headers
+}
+
 func (p *AwsSmtpHandler) extractS3PathToContent(receipt *Receipt) (string, string, error) {
 	bucket := ""
 	key := ""
@@ -235,22 +493,58 @@ func (p *AwsSmtpHandler) extractS3PathToContent(receipt *Receipt) (string, strin
 		}
 	}
 	if bucket == "" || key == "" {
-		return "", "", S3FileNotFoundError
+		return "", "", &S3Error{Kind: S3ErrorNotFound, Bucket: bucket, Key: key, Err: errors.New("receipt action is missing s3 location")}
 	}
 	return bucket, key, nil
 }
 
-func (p *AwsSmtpHandler) downloadS3File(svc s3iface.S3API, bucket string, key string) ([]byte, error) {
+func (p *AwsSmtpHandler) downloadS3File(ctx context.Context, svc s3iface.S3API, bucket string, key string) ([]byte, error) {
+	ctx, span := p.tracer.Start(ctx, "s3.Download", trace.WithAttributes(
+		attribute.String("s3.bucket", bucket),
+		attribute.String("s3.key", key),
+	))
+	ctx, cancel := withTimeout(ctx, p.timeouts.Download)
+	defer cancel()
 	downloader := s3manager.NewDownloaderWithClient(svc)
 
 	buf := aws.NewWriteAtBuffer([]byte{})
-	_, err := downloader.Download(buf,
+	var w io.WriterAt = buf
+	if p.maxMimeSize > 0 {
+		w = &limitedWriterAt{w: buf, limit: p.maxMimeSize}
+	}
+	start := time.Now()
+	n, err := downloader.DownloadWithContext(ctx, w,
 		&s3.GetObjectInput{
 			Bucket: aws.String(bucket),
 			Key:    aws.String(key),
 		})
+	var sizeErr *SizeLimitError
+	if errors.As(err, &sizeErr) {
+		err = sizeErr
+	} else if err != nil {
+		err = newS3Error(bucket, key, err)
+	}
+	if p.metrics != nil {
+		p.metrics.S3Downloaded(n, time.Since(start), err)
+	}
+	span.SetAttributes(attribute.Int64("s3.object_size", n))
+	endSpan(span, err)
 	if err != nil {
 		return nil, err
 	}
+	p.logger.Debug("s3 mime content downloaded", "bucket", bucket, "key", key, "bytes", n)
 	return buf.Bytes(), nil
 }
+
+// limitedWriterAt fails writes beyond limit which aborts the download
+type limitedWriterAt struct {
+	w     io.WriterAt
+	limit int64
+}
+
+func (l *limitedWriterAt) WriteAt(b []byte, off int64) (int, error) {
+	if off+int64(len(b)) > l.limit {
+		return 0, &SizeLimitError{What: "mime", Limit: l.limit}
+	}
+	return l.w.WriteAt(b, off)
+}
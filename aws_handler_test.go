@@ -86,7 +86,7 @@ func TestAwsHandlerReceivedMsgHandle(t *testing.T) {
 	}
 
 	svc, _, _ := dlLoggingSvc([]byte{})
-	smtpHandler := NewAwsSmtpHandler(svc)
+	smtpHandler := newAwsSmtpHandler(svc)
 
 	mailReceived, err := smtpHandler.HandleSmtp(payload)
 	if err != nil {
@@ -104,7 +104,7 @@ func TestAwsHandlerBounce(t *testing.T) {
 	}
 
 	svc, _, _ := dlLoggingSvc([]byte{})
-	smtpHandler := NewAwsSmtpHandler(svc)
+	smtpHandler := newAwsSmtpHandler(svc)
 
 	mailReceived, err := smtpHandler.HandleSmtp(payload)
 	if err != nil {
@@ -122,7 +122,7 @@ func TestAwsHandlerDelivery(t *testing.T) {
 	}
 
 	svc, _, _ := dlLoggingSvc([]byte{})
-	smtpHandler := NewAwsSmtpHandler(svc)
+	smtpHandler := newAwsSmtpHandler(svc)
 
 	mailReceived, err := smtpHandler.HandleSmtp(payload)
 	if err != nil {
@@ -140,7 +140,7 @@ func TestAwsHandlerComplaint(t *testing.T) {
 	}
 
 	svc, _, _ := dlLoggingSvc([]byte{})
-	smtpHandler := NewAwsSmtpHandler(svc)
+	smtpHandler := newAwsSmtpHandler(svc)
 
 	mailReceived, err := smtpHandler.HandleSmtp(payload)
 	if err != nil {
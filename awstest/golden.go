@@ -0,0 +1,46 @@
+package awstest
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var updateGolden = flag.Bool("update-golden", false, "rewrite golden files with actual output")
+
+// Golden compares got with the content of golden file at path. Run tests with -update-golden to (re)write golden files.
+func Golden(t testing.TB, path string, got []byte) {
+	t.Helper()
+
+	if *updateGolden {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, got, 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("%v (run with -update-golden to create it)", err)
+	}
+	if !bytes.Equal(bytes.TrimSpace(want), bytes.TrimSpace(got)) {
+		t.Fatalf("output doesn't match golden file %s\n--- want\n%s\n--- got\n%s", path, want, got)
+	}
+}
+
+// GoldenJSON is Golden for v marshalled as indented JSON
+func GoldenJSON(t testing.TB, path string, v interface{}) {
+	t.Helper()
+
+	got, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	Golden(t, path, append(got, '\n'))
+}
@@ -0,0 +1,205 @@
+package awstest
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+)
+
+var rangePattern = regexp.MustCompile(`^bytes=(\d+)-(\d*)$`)
+
+// S3 is an in-memory S3 implementing operations used for SES notification handling
+// (GetObject, PutObject, ListObjectsV2 and their WithContext and Pages variants).
+// Other operations of s3iface.S3API panic.
+type S3 struct {
+	s3iface.S3API
+
+	mu      sync.Mutex
+	objects map[string]map[string][]byte // bucket -> key -> content
+	gets    []string
+}
+
+var _ s3iface.S3API = (*S3)(nil)
+
+func NewS3() *S3 {
+	return &S3{objects: map[string]map[string][]byte{}}
+}
+
+// Put stores object content, creating the bucket if needed
+func (s *S3) Put(bucket string, key string, content []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.objects[bucket] == nil {
+		s.objects[bucket] = map[string][]byte{}
+	}
+	s.objects[bucket][key] = append([]byte{}, content...)
+}
+
+// Delete removes object
+func (s *S3) Delete(bucket string, key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.objects[bucket], key)
+}
+
+// Gets returns bucket/key of all GetObject requests (including ranged requests of each part) in order
+func (s *S3) Gets() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string{}, s.gets...)
+}
+
+func (s *S3) GetObject(input *s3.GetObjectInput) (*s3.GetObjectOutput, error) {
+	return s.GetObjectWithContext(aws.BackgroundContext(), input)
+}
+
+func (s *S3) GetObjectWithContext(ctx aws.Context, input *s3.GetObjectInput, opts ...request.Option) (*s3.GetObjectOutput, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, awserr.New(request.CanceledErrorCode, "request context canceled", err)
+	}
+
+	bucket, key := aws.StringValue(input.Bucket), aws.StringValue(input.Key)
+	s.mu.Lock()
+	s.gets = append(s.gets, bucket+"/"+key)
+	content, ok := s.objects[bucket][key]
+	s.mu.Unlock()
+	if !ok {
+		return nil, awserr.NewRequestFailure(awserr.New(s3.ErrCodeNoSuchKey, "The specified key does not exist.", nil), http.StatusNotFound, "awstest")
+	}
+
+	size := int64(len(content))
+	start, end := int64(0), size-1
+	output := &s3.GetObjectOutput{}
+	if r := aws.StringValue(input.Range); r != "" {
+		m := rangePattern.FindStringSubmatch(r)
+		if m == nil {
+			return nil, awserr.NewRequestFailure(awserr.New("InvalidRange", "The requested range is not satisfiable", nil), http.StatusRequestedRangeNotSatisfiable, "awstest")
+		}
+		start, _ = strconv.ParseInt(m[1], 10, 64)
+		if m[2] != "" {
+			end, _ = strconv.ParseInt(m[2], 10, 64)
+		}
+		if end >= size {
+			end = size - 1
+		}
+		if start > end && size > 0 {
+			return nil, awserr.NewRequestFailure(awserr.New("InvalidRange", "The requested range is not satisfiable", nil), http.StatusRequestedRangeNotSatisfiable, "awstest")
+		}
+		output.ContentRange = aws.String(fmt.Sprintf("bytes %d-%d/%d", start, end, size))
+	}
+
+	body := content[start : end+1]
+	output.Body = ioutil.NopCloser(bytes.NewReader(body))
+	output.ContentLength = aws.Int64(int64(len(body)))
+	return output, nil
+}
+
+func (s *S3) PutObject(input *s3.PutObjectInput) (*s3.PutObjectOutput, error) {
+	return s.PutObjectWithContext(aws.BackgroundContext(), input)
+}
+
+func (s *S3) PutObjectWithContext(ctx aws.Context, input *s3.PutObjectInput, opts ...request.Option) (*s3.PutObjectOutput, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, awserr.New(request.CanceledErrorCode, "request context canceled", err)
+	}
+	content := []byte{}
+	if input.Body != nil {
+		var err error
+		if content, err = io.ReadAll(input.Body); err != nil {
+			return nil, err
+		}
+	}
+	s.Put(aws.StringValue(input.Bucket), aws.StringValue(input.Key), content)
+	return &s3.PutObjectOutput{}, nil
+}
+
+func (s *S3) ListObjectsV2(input *s3.ListObjectsV2Input) (*s3.ListObjectsV2Output, error) {
+	return s.ListObjectsV2WithContext(aws.BackgroundContext(), input)
+}
+
+func (s *S3) ListObjectsV2WithContext(ctx aws.Context, input *s3.ListObjectsV2Input, opts ...request.Option) (*s3.ListObjectsV2Output, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, awserr.New(request.CanceledErrorCode, "request context canceled", err)
+	}
+
+	bucket := aws.StringValue(input.Bucket)
+	prefix := aws.StringValue(input.Prefix)
+	maxKeys := int(aws.Int64Value(input.MaxKeys))
+	if maxKeys <= 0 {
+		maxKeys = 1000
+	}
+	after := aws.StringValue(input.ContinuationToken)
+	if after == "" {
+		after = aws.StringValue(input.StartAfter)
+	}
+
+	s.mu.Lock()
+	objects, ok := s.objects[bucket]
+	keys := []string{}
+	for key := range objects {
+		if strings.HasPrefix(key, prefix) && key > after {
+			keys = append(keys, key)
+		}
+	}
+	sizes := map[string]int64{}
+	for _, key := range keys {
+		sizes[key] = int64(len(objects[key]))
+	}
+	s.mu.Unlock()
+	if !ok {
+		return nil, awserr.NewRequestFailure(awserr.New(s3.ErrCodeNoSuchBucket, "The specified bucket does not exist", nil), http.StatusNotFound, "awstest")
+	}
+	sort.Strings(keys)
+
+	output := &s3.ListObjectsV2Output{
+		Name:        input.Bucket,
+		Prefix:      input.Prefix,
+		IsTruncated: aws.Bool(len(keys) > maxKeys),
+	}
+	if len(keys) > maxKeys {
+		keys = keys[:maxKeys]
+		output.NextContinuationToken = aws.String(keys[len(keys)-1])
+	}
+	for _, key := range keys {
+		output.Contents = append(output.Contents, &s3.Object{
+			Key:          aws.String(key),
+			Size:         aws.Int64(sizes[key]),
+			LastModified: aws.Time(time.Time{}),
+		})
+	}
+	output.KeyCount = aws.Int64(int64(len(output.Contents)))
+	return output, nil
+}
+
+func (s *S3) ListObjectsV2Pages(input *s3.ListObjectsV2Input, fn func(*s3.ListObjectsV2Output, bool) bool) error {
+	return s.ListObjectsV2PagesWithContext(aws.BackgroundContext(), input, fn)
+}
+
+func (s *S3) ListObjectsV2PagesWithContext(ctx aws.Context, input *s3.ListObjectsV2Input, fn func(*s3.ListObjectsV2Output, bool) bool, opts ...request.Option) error {
+	page := *input
+	for {
+		output, err := s.ListObjectsV2WithContext(ctx, &page, opts...)
+		if err != nil {
+			return err
+		}
+		lastPage := !aws.BoolValue(output.IsTruncated)
+		if !fn(output, lastPage) || lastPage {
+			return nil
+		}
+		page.ContinuationToken = output.NextContinuationToken
+	}
+}
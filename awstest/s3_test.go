@@ -0,0 +1,66 @@
+package awstest
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+)
+
+func TestS3Download(t *testing.T) {
+	svc := NewS3()
+	content := bytes.Repeat([]byte("0123456789"), 1024*1024) // two download parts
+	svc.Put("bucket", "mail/1", content)
+
+	downloader := s3manager.NewDownloaderWithClient(svc)
+	buf := aws.NewWriteAtBuffer([]byte{})
+	n, err := downloader.Download(buf, &s3.GetObjectInput{Bucket: aws.String("bucket"), Key: aws.String("mail/1")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != int64(len(content)) || !bytes.Equal(buf.Bytes(), content) {
+		t.Fatalf("unexpected content of %d bytes", n)
+	}
+	if gets := svc.Gets(); len(gets) != 2 {
+		t.Fatalf("expected ranged requests, got %v", gets)
+	}
+
+	_, err = downloader.Download(aws.NewWriteAtBuffer([]byte{}), &s3.GetObjectInput{Bucket: aws.String("bucket"), Key: aws.String("mail/2")})
+	var reqErr awserr.RequestFailure
+	if !errors.As(err, &reqErr) || reqErr.StatusCode() != 404 || reqErr.Code() != s3.ErrCodeNoSuchKey {
+		t.Fatalf("expected not found error, got %v", err)
+	}
+}
+
+func TestS3ListPages(t *testing.T) {
+	svc := NewS3()
+	for i := 0; i < 5; i++ {
+		svc.Put("bucket", fmt.Sprintf("firehose/%d", i), []byte("{}"))
+	}
+	svc.Put("bucket", "other/0", []byte("{}"))
+
+	keys := []string{}
+	pages := 0
+	err := svc.ListObjectsV2Pages(&s3.ListObjectsV2Input{
+		Bucket:  aws.String("bucket"),
+		Prefix:  aws.String("firehose/"),
+		MaxKeys: aws.Int64(2),
+	}, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+		pages++
+		for _, obj := range page.Contents {
+			keys = append(keys, *obj.Key)
+		}
+		return true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pages != 3 || len(keys) != 5 || keys[0] != "firehose/0" || keys[4] != "firehose/4" {
+		t.Fatalf("unexpected listing: %d pages, %v", pages, keys)
+	}
+}
@@ -0,0 +1,211 @@
+// Package awstest provides offline stand-ins for SNS and S3 for end-to-end tests of SES notification handling
+package awstest
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"encoding/xml"
+	"fmt"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/igorrendulic/couchdb-email-aws-parse/sns"
+)
+
+// SNS timestamp format
+const timestampFormat = "2006-01-02T15:04:05.000Z"
+
+// name of the signing certificate, served at the root of SNS host
+const certPath = "/SimpleNotificationService-awstest.pem"
+
+// SNS is an httptest server standing in for SNS: it serves the signing certificate and SubscribeURL/UnsubscribeURL endpoints.
+// Use Client (e.g. with awshandler.WithHTTPClient) to reach it, the client routes all sns.<region>.amazonaws.com requests to the server
+// so payloads pass the SNS host check of signature verification.
+type SNS struct {
+	Region string // region of sns host in URLs, us-east-1 by default
+
+	server  *httptest.Server
+	key     *rsa.PrivateKey
+	certPEM []byte
+
+	mu           sync.Mutex
+	confirmed    []string
+	unsubscribed []string
+	sequence     int
+}
+
+// NewSNS starts SNS stand-in with a fresh self-signed signing certificate, Close it when done
+func NewSNS() (*SNS, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "sns.amazonaws.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &SNS{
+		Region:  "us-east-1",
+		key:     key,
+		certPEM: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+	}
+	s.server = httptest.NewTLSServer(http.HandlerFunc(s.serveHTTP))
+	return s, nil
+}
+
+// Close shuts down the server
+func (s *SNS) Close() {
+	s.server.Close()
+}
+
+// URL of the SNS host, e.g. https://sns.us-east-1.amazonaws.com
+func (s *SNS) URL() string {
+	return "https://sns." + s.Region + ".amazonaws.com"
+}
+
+// Client returns http client routing all requests to the server
+func (s *SNS) Client() *http.Client {
+	transport := s.server.Client().Transport.(*http.Transport).Clone()
+	// httptest certificate is issued for example.com
+	transport.TLSClientConfig.ServerName = "example.com"
+	addr := s.server.Listener.Addr().String()
+	transport.DialContext = func(ctx context.Context, network, _ string) (net.Conn, error) {
+		var d net.Dialer
+		return d.DialContext(ctx, network, addr)
+	}
+	return &http.Client{Transport: transport}
+}
+
+// Sign sets signing certificate URL and signature (version 2 unless SignatureVersion is already set to 1)
+func (s *SNS) Sign(payload *sns.Payload) error {
+	payload.SigningCertURL = s.URL() + certPath
+
+	var hash crypto.Hash
+	var digest []byte
+	if payload.SignatureVersion == "1" {
+		sum := sha1.Sum(payload.BuildSignature())
+		hash, digest = crypto.SHA1, sum[:]
+	} else {
+		payload.SignatureVersion = "2"
+		sum := sha256.Sum256(payload.BuildSignature())
+		hash, digest = crypto.SHA256, sum[:]
+	}
+
+	signature, err := rsa.SignPKCS1v15(rand.Reader, s.key, hash, digest)
+	if err != nil {
+		return err
+	}
+	payload.Signature = base64.StdEncoding.EncodeToString(signature)
+	return nil
+}
+
+// Notification returns signed SNS Notification envelope of message published to topic
+func (s *SNS) Notification(topicArn string, message string) (*sns.Payload, error) {
+	payload := &sns.Payload{
+		Type:           "Notification",
+		MessageId:      s.nextID(),
+		TopicArn:       topicArn,
+		Message:        message,
+		Timestamp:      time.Now().UTC().Format(timestampFormat),
+		UnsubscribeURL: s.URL() + "/?Action=Unsubscribe&SubscriptionArn=" + url.QueryEscape(topicArn+":subscription"),
+	}
+	return payload, s.Sign(payload)
+}
+
+// SubscriptionConfirmation returns signed SNS SubscriptionConfirmation of topic, SubscribeURL points to the server
+func (s *SNS) SubscriptionConfirmation(topicArn string) (*sns.Payload, error) {
+	token := s.nextID()
+	payload := &sns.Payload{
+		Type:         "SubscriptionConfirmation",
+		MessageId:    s.nextID(),
+		TopicArn:     topicArn,
+		Token:        token,
+		Message:      "You have chosen to subscribe to the topic " + topicArn + ".\nTo confirm the subscription, visit the SubscribeURL included in this message.",
+		Timestamp:    time.Now().UTC().Format(timestampFormat),
+		SubscribeURL: s.URL() + "/?Action=ConfirmSubscription&TopicArn=" + url.QueryEscape(topicArn) + "&Token=" + token,
+	}
+	return payload, s.Sign(payload)
+}
+
+// Confirmed returns topics of confirmed subscriptions in order of confirmation
+func (s *SNS) Confirmed() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string{}, s.confirmed...)
+}
+
+// Unsubscribed returns subscription ARNs which visited UnsubscribeURL
+func (s *SNS) Unsubscribed() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string{}, s.unsubscribed...)
+}
+
+func (s *SNS) nextID() string {
+	s.mu.Lock()
+	s.sequence++
+	n := s.sequence
+	s.mu.Unlock()
+
+	b := make([]byte, 8)
+	rand.Read(b)
+	return fmt.Sprintf("%08d-%s", n, hex.EncodeToString(b))
+}
+
+func (s *SNS) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == certPath {
+		w.Header().Set("Content-Type", "application/x-pem-file")
+		w.Write(s.certPEM)
+		return
+	}
+
+	query := r.URL.Query()
+	switch query.Get("Action") {
+	case "ConfirmSubscription":
+		topicArn := query.Get("TopicArn")
+		if topicArn == "" || query.Get("Token") == "" {
+			http.Error(w, "missing TopicArn or Token", http.StatusBadRequest)
+			return
+		}
+		s.mu.Lock()
+		s.confirmed = append(s.confirmed, topicArn)
+		s.mu.Unlock()
+		writeXML(w, &sns.ConfirmSubscriptionResponse{SubscriptionArn: topicArn + ":subscription", RequestId: s.nextID()})
+	case "Unsubscribe":
+		s.mu.Lock()
+		s.unsubscribed = append(s.unsubscribed, query.Get("SubscriptionArn"))
+		s.mu.Unlock()
+		writeXML(w, &sns.UnsubscribeResponse{RequestId: s.nextID()})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func writeXML(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "text/xml")
+	if err := xml.NewEncoder(w).Encode(v); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,81 @@
+package awstest
+
+import (
+	"context"
+	"testing"
+
+	"github.com/igorrendulic/couchdb-email-aws-parse/sns"
+)
+
+func TestSignedPayloadVerifies(t *testing.T) {
+	server, err := NewSNS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	verifier := &sns.Verifier{Client: server.Client()}
+
+	payload, err := server.Notification("arn:aws:sns:us-east-1:123456789012:ses", `{"notificationType":"Bounce"}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := verifier.Verify(payload); err != nil {
+		t.Fatalf("expected signature to verify: %v", err)
+	}
+
+	payload.Message = `{"notificationType":"Delivery"}`
+	if err := verifier.Verify(payload); err == nil {
+		t.Fatal("expected tampered payload to fail verification")
+	}
+
+	payload.SignatureVersion = "1"
+	if err := server.Sign(payload); err != nil {
+		t.Fatal(err)
+	}
+	if err := verifier.Verify(payload); err != nil {
+		t.Fatalf("expected version 1 signature to verify: %v", err)
+	}
+}
+
+func TestSubscribeAndUnsubscribe(t *testing.T) {
+	server, err := NewSNS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	server.Region = "eu-west-1"
+
+	topicArn := "arn:aws:sns:eu-west-1:123456789012:ses"
+	confirmation, err := server.SubscriptionConfirmation(topicArn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := (&sns.Verifier{Client: server.Client()}).Verify(confirmation); err != nil {
+		t.Fatal(err)
+	}
+
+	response, err := confirmation.SubscribeWithClient(context.Background(), server.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.SubscriptionArn != topicArn+":subscription" {
+		t.Fatalf("unexpected subscription arn: %s", response.SubscriptionArn)
+	}
+	if confirmed := server.Confirmed(); len(confirmed) != 1 || confirmed[0] != topicArn {
+		t.Fatalf("unexpected confirmed subscriptions: %v", confirmed)
+	}
+
+	notification, err := server.Notification(topicArn, "{}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := server.Client().Get(notification.UnsubscribeURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if unsubscribed := server.Unsubscribed(); len(unsubscribed) != 1 || unsubscribed[0] != topicArn+":subscription" {
+		t.Fatalf("unexpected unsubscriptions: %v", unsubscribed)
+	}
+}
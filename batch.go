@@ -0,0 +1,70 @@
+package awshandler
+
+import (
+	"context"
+	"sync"
+
+	"github.com/igorrendulic/couchdb-experiment/email/mime/handler"
+)
+
+// default number of messages handled in parallel by HandleBatch
+const DefaultBatchConcurrency = 10
+
+// BatchMessage is a single SQS message body (raw SES notification or SNS envelope)
+type BatchMessage struct {
+	ID   string // SQS message id, reported back in batch item failures
+	Body []byte
+}
+
+// BatchResult is the outcome of handling a single BatchMessage
+type BatchResult struct {
+	ID   string
+	Mail *handler.MailReceived
+	Err  error
+}
+
+// HandleBatch handles SQS delivered SES notifications with at most concurrency messages in flight.
+// SNS envelopes are passed to h as they are, AwsSmtpHandler checks their topic and signature before unwrapping them.
+// Results are returned in the same order as messages.
+func HandleBatch(h handler.SmtpHandler, messages []*BatchMessage, concurrency int) []*BatchResult {
+	return HandleBatchContext(context.Background(), h, messages, concurrency)
+}
+
+// HandleBatchContext is HandleBatch with messages handled by ContextSmtpHandler bound to ctx
+func HandleBatchContext(ctx context.Context, h handler.SmtpHandler, messages []*BatchMessage, concurrency int) []*BatchResult {
+	if concurrency <= 0 {
+		concurrency = DefaultBatchConcurrency
+	}
+
+	results := make([]*BatchResult, len(messages))
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+
+	for i, msg := range messages {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, msg *BatchMessage) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			result := &BatchResult{ID: msg.ID}
+			result.Mail, result.Err = handleSmtp(ctx, h, msg.Body)
+			results[i] = result
+		}(i, msg)
+	}
+	wg.Wait()
+
+	return results
+}
+
+// BatchItemFailures returns ids of failed messages (SQS partial batch failure reporting).
+// Quarantined messages (QuarantineError) were handled and aren't failures.
+func BatchItemFailures(results []*BatchResult) []string {
+	failures := []string{}
+	for _, r := range results {
+		if r.Err != nil && !quarantined(r.Err) {
+			failures = append(failures, r.ID)
+		}
+	}
+	return failures
+}
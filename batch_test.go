@@ -0,0 +1,80 @@
+package awshandler
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/igorrendulic/couchdb-email-aws-parse/sns"
+)
+
+func wrapInSnsEnvelope(t *testing.T, message []byte) []byte {
+	envelope, err := json.Marshal(&sns.Payload{
+		Type:      "Notification",
+		MessageId: "22b80b92-fdea-4c2c-8f9d-bdfb0c7bf324",
+		TopicArn:  "arn:aws:sns:us-west-2:123456:bounce",
+		Message:   string(message),
+		Timestamp: "2016-01-27T14:59:38.237Z",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return envelope
+}
+
+func TestHandleBatch(t *testing.T) {
+	bounce, err := LoadPayload("test_data/bounce.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	delivery, err := LoadPayload("test_data/delivery.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	svc, _, _ := dlLoggingSvc([]byte{})
+	smtpHandler, err := NewAwsSmtpHandlerWithOptions(WithS3(svc), WithTopics("arn:aws:sns:us-west-2:123456:bounce"), WithSignatureVerification(true, false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherTopic := &sns.Payload{}
+	if err := json.Unmarshal(wrapInSnsEnvelope(t, bounce), otherTopic); err != nil {
+		t.Fatal(err)
+	}
+	otherTopic.TopicArn = "arn:aws:sns:us-west-2:123456:other"
+	otherTopicBody, err := json.Marshal(otherTopic)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	messages := []*BatchMessage{
+		{ID: "1", Body: wrapInSnsEnvelope(t, bounce)},
+		{ID: "2", Body: delivery},
+		{ID: "3", Body: []byte("not json")},
+		{ID: "4", Body: wrapInSnsEnvelope(t, []byte(`{"notificationType":"Unknown"}`))},
+		{ID: "5", Body: otherTopicBody},
+	}
+
+	results := HandleBatch(smtpHandler, messages, 2)
+	if len(results) != len(messages) {
+		t.Fatalf("expected %d results, got %d", len(messages), len(results))
+	}
+	if results[0].Err != nil || results[0].Mail.NotificationType != "Bounce" {
+		t.Fatalf("expected SNS wrapped bounce to be handled: %v", results[0].Err)
+	}
+	if results[1].Err != nil || results[1].Mail.NotificationType != "Delivery" {
+		t.Fatalf("expected raw delivery to be handled: %v", results[1].Err)
+	}
+
+	// envelope is passed to the handler which checks the topic
+	var topicErr *TopicNotAllowedError
+	if !errors.As(results[4].Err, &topicErr) {
+		t.Fatalf("expected topic not allowed error, got %v", results[4].Err)
+	}
+
+	failures := BatchItemFailures(results)
+	if !reflect.DeepEqual(failures, []string{"3", "4", "5"}) {
+		t.Fatalf("expected messages 3, 4 and 5 to fail, got %v", failures)
+	}
+}
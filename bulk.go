@@ -0,0 +1,177 @@
+package awshandler
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io"
+	"sync/atomic"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/igorrendulic/couchdb-experiment/email/mime/handler"
+)
+
+// BulkRecord is a single record decoded by bulk ingestion
+type BulkRecord struct {
+	Source string // S3 object key (empty when ingesting from io.Reader)
+	Index  int    // index of the record within the source
+	Mail   *handler.MailReceived
+	Err    error
+}
+
+// BulkProgress is a snapshot of bulk ingestion progress
+type BulkProgress struct {
+	Objects int64 // sources (S3 objects) fully read
+	Records int64 // records handled
+	Failed  int64 // records that failed to decode or map
+	Bytes   int64 // bytes read (compressed size for gzip'd sources)
+}
+
+// BulkIngestion streams records of a running bulk ingestion. Records channel is closed when ingestion is finished.
+type BulkIngestion struct {
+	Records <-chan *BulkRecord
+
+	objects int64
+	records int64
+	failed  int64
+	bytes   int64
+	err     error
+}
+
+// Progress returns current progress, safe to call while ingestion is running
+func (b *BulkIngestion) Progress() BulkProgress {
+	return BulkProgress{
+		Objects: atomic.LoadInt64(&b.objects),
+		Records: atomic.LoadInt64(&b.records),
+		Failed:  atomic.LoadInt64(&b.failed),
+		Bytes:   atomic.LoadInt64(&b.bytes),
+	}
+}
+
+// Err returns error which stopped the ingestion (e.g. listing S3 prefix failed). Valid after Records channel is closed.
+func (b *BulkIngestion) Err() error {
+	return b.err
+}
+
+// IngestReader decodes SES events (newline delimited or concatenated JSON, optionally gzip'd) from r
+// and maps them the same way as HandleSmtp
+func (p *AwsSmtpHandler) IngestReader(ctx context.Context, r io.Reader) *BulkIngestion {
+	records := make(chan *BulkRecord)
+	ingestion := &BulkIngestion{Records: records}
+
+	go func() {
+		defer close(records)
+		if err := p.ingestSource(ctx, ingestion, records, "", r); err != nil {
+			ingestion.err = err
+		}
+	}()
+
+	return ingestion
+}
+
+// IngestS3Prefix decodes SES events from all objects under prefix (e.g. Kinesis Firehose S3 destination)
+// and maps them the same way as HandleSmtp
+func (p *AwsSmtpHandler) IngestS3Prefix(ctx context.Context, bucket string, prefix string) *BulkIngestion {
+	records := make(chan *BulkRecord)
+	ingestion := &BulkIngestion{Records: records}
+
+	go func() {
+		defer close(records)
+
+		var sourceErr error
+		err := p.svc.ListObjectsV2PagesWithContext(ctx, &s3.ListObjectsV2Input{
+			Bucket: aws.String(bucket),
+			Prefix: aws.String(prefix),
+		}, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+			for _, obj := range page.Contents {
+				key := aws.StringValue(obj.Key)
+				out, err := p.svc.GetObjectWithContext(ctx, &s3.GetObjectInput{
+					Bucket: aws.String(bucket),
+					Key:    aws.String(key),
+				})
+				if err != nil {
+					sourceErr = newS3Error(bucket, key, err)
+					return false
+				}
+				err = p.ingestSource(ctx, ingestion, records, key, out.Body)
+				out.Body.Close()
+				if err != nil {
+					sourceErr = err
+					return false
+				}
+			}
+			return true
+		})
+		if err == nil {
+			err = sourceErr
+		}
+		ingestion.err = err
+	}()
+
+	return ingestion
+}
+
+// decoding a single source, returns error only if ingestion should stop (context cancelled or source unreadable)
+func (p *AwsSmtpHandler) ingestSource(ctx context.Context, ingestion *BulkIngestion, records chan<- *BulkRecord, source string, r io.Reader) error {
+	br := bufio.NewReader(&countingReader{r: r, n: &ingestion.bytes})
+
+	var reader io.Reader = br
+	magic, _ := br.Peek(2)
+	if len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		reader = gz
+	}
+
+	decoder := json.NewDecoder(reader)
+	for i := 0; ; i++ {
+		var raw json.RawMessage
+		err := decoder.Decode(&raw)
+		if err == io.EOF {
+			break
+		}
+
+		record := &BulkRecord{Source: source, Index: i}
+		if err != nil {
+			// can't recover position in the stream after malformed JSON, skipping rest of the source
+			record.Err = &ParseError{Err: err}
+		} else {
+			// archived records are SNS notifications (raw or SNS envelope), SES event publishing or EventBridge events
+			record.Mail, record.Err = p.HandleSmtpContext(ctx, raw)
+		}
+
+		atomic.AddInt64(&ingestion.records, 1)
+		if record.Err != nil && !quarantined(record.Err) {
+			atomic.AddInt64(&ingestion.failed, 1)
+		}
+
+		select {
+		case records <- record:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+
+		if err != nil {
+			break
+		}
+	}
+
+	atomic.AddInt64(&ingestion.objects, 1)
+	return nil
+}
+
+type countingReader struct {
+	r io.Reader
+	n *int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	atomic.AddInt64(c.n, int64(n))
+	return n, err
+}
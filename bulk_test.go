@@ -0,0 +1,127 @@
+package awshandler
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/awstesting/unit"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// mocking aws s3 client listing and serving objects from memory
+func listingSvc(objects map[string][]byte) *s3.S3 {
+	svc := s3.New(unit.Session)
+	svc.Handlers.Send.Clear()
+	svc.Handlers.Unmarshal.Clear()
+	svc.Handlers.UnmarshalMeta.Clear()
+	svc.Handlers.ValidateResponse.Clear()
+	svc.Handlers.Send.PushBack(func(r *request.Request) {
+		switch out := r.Data.(type) {
+		case *s3.ListObjectsV2Output:
+			prefix := aws.StringValue(r.Params.(*s3.ListObjectsV2Input).Prefix)
+			for key := range objects {
+				if strings.HasPrefix(key, prefix) {
+					out.Contents = append(out.Contents, &s3.Object{Key: aws.String(key)})
+				}
+			}
+		case *s3.GetObjectOutput:
+			key := aws.StringValue(r.Params.(*s3.GetObjectInput).Key)
+			out.Body = ioutil.NopCloser(bytes.NewReader(objects[key]))
+		}
+		r.HTTPResponse = &http.Response{
+			StatusCode: 200,
+			Body:       ioutil.NopCloser(bytes.NewReader([]byte{})),
+			Header:     http.Header{},
+		}
+	})
+	return svc
+}
+
+func compactFixture(t *testing.T, filename string) []byte {
+	payload, err := LoadPayload(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := json.Compact(&buf, payload); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func gzipped(t *testing.T, data []byte) []byte {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	if _, err := gz.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestIngestReader(t *testing.T) {
+	ndjson := bytes.Join([][]byte{
+		compactFixture(t, "test_data/bounce.json"),
+		compactFixture(t, "test_data/delivery.json"),
+		[]byte(`{"notificationType":"Unknown"}`),
+		compactFixture(t, "test_data/eventbridge-complaint.json"),
+	}, []byte("\n"))
+
+	svc, _, _ := dlLoggingSvc([]byte{})
+	ingestion := newAwsSmtpHandler(svc).IngestReader(context.Background(), bytes.NewReader(gzipped(t, ndjson)))
+
+	types := []string{}
+	for record := range ingestion.Records {
+		if record.Err == nil {
+			types = append(types, record.Mail.NotificationType)
+		}
+	}
+	if ingestion.Err() != nil {
+		t.Fatal(ingestion.Err())
+	}
+	if strings.Join(types, ",") != "Bounce,Delivery,Complaint" {
+		t.Fatalf("unexpected handled records: %v", types)
+	}
+
+	progress := ingestion.Progress()
+	if progress.Records != 4 || progress.Failed != 1 || progress.Objects != 1 || progress.Bytes == 0 {
+		t.Fatalf("unexpected progress: %+v", progress)
+	}
+}
+
+func TestIngestS3Prefix(t *testing.T) {
+	// event publishing records use eventType instead of notificationType and aren't newline delimited by Firehose
+	bounce := bytes.Replace(compactFixture(t, "test_data/bounce.json"), []byte(`"notificationType"`), []byte(`"eventType"`), 1)
+	delivery := compactFixture(t, "test_data/delivery.json")
+
+	svc := listingSvc(map[string][]byte{
+		"ses/2023/03/13/events-1.gz": gzipped(t, append(bounce, delivery...)),
+		"ses/2023/03/13/events-2":    delivery,
+		"other/events-3":             delivery,
+	})
+	ingestion := newAwsSmtpHandler(svc).IngestS3Prefix(context.Background(), "archive", "ses/")
+
+	handled := 0
+	for record := range ingestion.Records {
+		if record.Err != nil {
+			t.Fatalf("%s record %d failed: %v", record.Source, record.Index, record.Err)
+		}
+		handled++
+	}
+	if ingestion.Err() != nil {
+		t.Fatal(ingestion.Err())
+	}
+	if handled != 3 || ingestion.Progress().Objects != 2 {
+		t.Fatalf("expected 3 records from 2 objects, got %d records, progress %+v", handled, ingestion.Progress())
+	}
+}
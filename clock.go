@@ -0,0 +1,95 @@
+package awshandler
+
+import (
+	"errors"
+	"time"
+)
+
+// Clock provides current time for notifications without a usable timestamp
+type Clock interface {
+	Now() time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+// WithClock replaces the system clock (e.g. fixed time for golden-file tests)
+func WithClock(clock Clock) Option {
+	return func(p *AwsSmtpHandler) error {
+		if clock == nil {
+			return errors.New("clock is nil")
+		}
+		p.clock = clock
+		return nil
+	}
+}
+
+// WithStrictTimestamps returns ParseError for missing or malformed notification timestamps
+// instead of falling back to the next timestamp of the notification or the current time
+func WithStrictTimestamps(strict bool) Option {
+	return func(p *AwsSmtpHandler) error {
+		p.strictTimestamps = strict
+		return nil
+	}
+}
+
+// timestamp candidate of a notification, path is the location within the notification
+type timestampSource struct {
+	path  string
+	value string
+}
+
+// output timestamp of a notification, the time SES generated the event (mail timestamp as fallback)
+func (p *AwsSmtpHandler) notificationTimestamp(messageJson *MessageJSON) (time.Time, error) {
+	sources := []timestampSource{}
+	switch NotificationType(messageJson.NotificationType) {
+	case NotificationTypeReceived:
+		if messageJson.Receipt != nil {
+			sources = append(sources, timestampSource{"receipt.timestamp", messageJson.Receipt.Timestamp})
+		}
+	case NotificationTypeBounce:
+		if messageJson.Bounce != nil {
+			sources = append(sources, timestampSource{"bounce.timestamp", messageJson.Bounce.Timestamp})
+		}
+	case NotificationTypeComplaint:
+		if messageJson.Complaint != nil {
+			sources = append(sources, timestampSource{"complaint.timestamp", messageJson.Complaint.Timestamp})
+		}
+	case NotificationTypeDelivery:
+		if messageJson.Delivery != nil {
+			sources = append(sources, timestampSource{"delivery.timestamp", messageJson.Delivery.Timestamp})
+		}
+	}
+	if messageJson.Mail != nil {
+		sources = append(sources, timestampSource{"mail.timestamp", messageJson.Mail.Timestamp})
+	}
+	return p.parseTimestamp(sources...)
+}
+
+// first valid timestamp of sources, current time if there is none (ParseError in strict mode)
+func (p *AwsSmtpHandler) parseTimestamp(sources ...timestampSource) (time.Time, error) {
+	for _, source := range sources {
+		if source.value == "" {
+			continue
+		}
+		ts, err := time.Parse(time.RFC3339, source.value)
+		if err == nil {
+			return ts, nil
+		}
+		if p.strictTimestamps {
+			return time.Time{}, &ParseError{Path: source.path, Err: err}
+		}
+	}
+
+	if p.strictTimestamps {
+		path := ""
+		if len(sources) > 0 {
+			path = sources[0].path
+		}
+		return time.Time{}, &ParseError{Path: path, Err: errors.New("missing timestamp")}
+	}
+	return p.clock.Now(), nil
+}
@@ -0,0 +1,94 @@
+package awshandler
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/igorrendulic/couchdb-experiment/email/mime/handler"
+)
+
+type fixedClock time.Time
+
+func (c fixedClock) Now() time.Time {
+	return time.Time(c)
+}
+
+func TestTimestampsFromNotification(t *testing.T) {
+	fixed := fixedClock(time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC))
+	notificationTime := time.Date(2016, 1, 27, 14, 59, 38, 237000000, time.UTC).UnixMilli()
+
+	tests := []struct {
+		file      string
+		timestamp int64
+	}{
+		{"test_data/bounce.json", notificationTime},
+		{"test_data/complaint.json", notificationTime},
+		{"test_data/delivery.json", notificationTime},
+		{"test_data/received.json", time.Date(2023, 3, 13, 20, 8, 18, 906000000, time.UTC).UnixMilli()},
+	}
+
+	for _, test := range tests {
+		payload, err := LoadPayload(test.file)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		outputs := []*handler.MailReceived{}
+		for i := 0; i < 2; i++ {
+			svc, _, _ := dlLoggingSvc([]byte("mime content"))
+			h, err := NewAwsSmtpHandlerWithOptions(WithS3(svc), WithClock(fixed))
+			if err != nil {
+				t.Fatal(err)
+			}
+			mail, err := h.HandleSmtp(payload)
+			if err != nil {
+				t.Fatal(err)
+			}
+			outputs = append(outputs, mail)
+		}
+
+		if outputs[0].Timestamp != test.timestamp {
+			t.Fatalf("%s: expected timestamp %d, got %d", test.file, test.timestamp, outputs[0].Timestamp)
+		}
+		if !reflect.DeepEqual(outputs[0], outputs[1]) {
+			t.Fatalf("%s: output isn't deterministic", test.file)
+		}
+	}
+}
+
+func TestTimestampFallback(t *testing.T) {
+	delivery, err := LoadPayload("test_data/delivery.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	malformed := []byte(strings.ReplaceAll(string(delivery), "2016-01-27T14:59:38.237Z", "yesterday"))
+	fixed := fixedClock(time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC))
+
+	svc, _, _ := dlLoggingSvc([]byte{})
+	h, err := NewAwsSmtpHandlerWithOptions(WithS3(svc), WithClock(fixed))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mail, err := h.HandleSmtp(malformed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mail.Timestamp != time.Time(fixed).UnixMilli() || mail.Delivery.Timestamp != time.Time(fixed).UnixMilli() {
+		t.Fatalf("expected clock time for malformed timestamps, got %d and %d", mail.Timestamp, mail.Delivery.Timestamp)
+	}
+
+	strict, err := NewAwsSmtpHandlerWithOptions(WithS3(svc), WithClock(fixed), WithStrictTimestamps(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = strict.HandleSmtp(malformed)
+	if parseErr, ok := err.(*ParseError); !ok || parseErr.Path != "delivery.timestamp" {
+		t.Fatalf("expected parse error of delivery timestamp, got %v", err)
+	}
+
+	if _, err := strict.HandleSmtp([]byte(`{"notificationType":"Bounce","bounce":{"bounceType":"Permanent"}}`)); ErrorKind(err) != "parse" {
+		t.Fatalf("expected parse error for missing timestamp, got %v", err)
+	}
+}
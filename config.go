@@ -0,0 +1,207 @@
+package awshandler
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// default prefix of environment variables read by LoadConfigFromEnv
+const DefaultEnvPrefix = "SES_HANDLER_"
+
+// Duration is time.Duration parsed from strings such as "5s" in configuration files
+type Duration time.Duration
+
+func (d *Duration) UnmarshalText(text []byte) error {
+	parsed, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+// Config is serializable handler configuration, see Options for how it's applied
+//
+//	topics:
+//	  - arn:aws:sns:us-west-2:123456789012:ses-received
+//	maxMimeSize: 10485760
+//	downloadTimeout: 30s
+type Config struct {
+	Topics                       []string         `json:"topics,omitempty" yaml:"topics,omitempty"`
+	MaxMessageSize               int64            `json:"maxMessageSize,omitempty" yaml:"maxMessageSize,omitempty"`
+	MaxMimeSize                  int64            `json:"maxMimeSize,omitempty" yaml:"maxMimeSize,omitempty"`
+	SkipSubscriptionVerification bool             `json:"skipSubscriptionVerification,omitempty" yaml:"skipSubscriptionVerification,omitempty"`
+	SkipNotificationVerification bool             `json:"skipNotificationVerification,omitempty" yaml:"skipNotificationVerification,omitempty"`
+	RequireEnvelope              bool             `json:"requireEnvelope,omitempty" yaml:"requireEnvelope,omitempty"` // reject unsigned messages without SNS envelope (raw delivery, EventBridge)
+	VerifyTimeout                Duration         `json:"verifyTimeout,omitempty" yaml:"verifyTimeout,omitempty"`
+	SubscribeTimeout             Duration         `json:"subscribeTimeout,omitempty" yaml:"subscribeTimeout,omitempty"`
+	DownloadTimeout              Duration         `json:"downloadTimeout,omitempty" yaml:"downloadTimeout,omitempty"`
+	HTTPTimeout                  Duration         `json:"httpTimeout,omitempty" yaml:"httpTimeout,omitempty"` // timeout of http client fetching certificates and confirming subscriptions
+	StrictTimestamps             bool             `json:"strictTimestamps,omitempty" yaml:"strictTimestamps,omitempty"`
+	Quarantine                   QuarantinePolicy `json:"quarantine,omitempty" yaml:"quarantine,omitempty"`
+}
+
+// Options converts configuration to options for NewAwsSmtpHandlerWithOptions (S3 client and hooks aren't part of it)
+func (c *Config) Options() []Option {
+	opts := []Option{
+		WithTopics(c.Topics...),
+		WithMaxMessageSize(c.MaxMessageSize),
+		WithMaxMimeSize(c.MaxMimeSize),
+		WithSignatureVerification(!c.SkipSubscriptionVerification, !c.SkipNotificationVerification),
+		WithRequireEnvelope(c.RequireEnvelope),
+		WithStrictTimestamps(c.StrictTimestamps),
+		WithQuarantinePolicy(c.Quarantine),
+		WithTimeouts(Timeouts{
+			Verify:    time.Duration(c.VerifyTimeout),
+			Subscribe: time.Duration(c.SubscribeTimeout),
+			Download:  time.Duration(c.DownloadTimeout),
+		}),
+	}
+	if c.HTTPTimeout != 0 {
+		opts = append(opts, WithHTTPClient(&http.Client{Timeout: time.Duration(c.HTTPTimeout)}))
+	}
+	return opts
+}
+
+// WithConfig applies all options of the configuration
+func WithConfig(c *Config) Option {
+	return func(p *AwsSmtpHandler) error {
+		for _, opt := range c.Options() {
+			if err := opt(p); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// LoadConfigFile reads configuration from YAML (.yaml, .yml) or JSON file
+func LoadConfigFile(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	config := &Config{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, config)
+	default:
+		err = unmarshalJSON(data, config, "")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load config %s: %w", path, err)
+	}
+	return config, nil
+}
+
+// LoadConfigFromEnv reads configuration from environment variables with prefix (DefaultEnvPrefix if empty):
+// TOPICS (comma separated), MAX_MESSAGE_SIZE, MAX_MIME_SIZE, SKIP_SUBSCRIPTION_VERIFICATION, SKIP_NOTIFICATION_VERIFICATION,
+// REQUIRE_ENVELOPE, VERIFY_TIMEOUT, SUBSCRIBE_TIMEOUT, DOWNLOAD_TIMEOUT, HTTP_TIMEOUT, STRICT_TIMESTAMPS, QUARANTINE_SPAM, QUARANTINE_VIRUS,
+// QUARANTINE_SPF, QUARANTINE_DKIM, QUARANTINE_DMARC (accept, quarantine or reject) and QUARANTINE_DMARC_POLICIES (comma separated)
+func LoadConfigFromEnv(prefix string) (*Config, error) {
+	if prefix == "" {
+		prefix = DefaultEnvPrefix
+	}
+	env := &envReader{prefix: prefix}
+
+	config := &Config{}
+	config.Topics = env.list("TOPICS")
+	config.MaxMessageSize = env.int("MAX_MESSAGE_SIZE")
+	config.MaxMimeSize = env.int("MAX_MIME_SIZE")
+	config.SkipSubscriptionVerification = env.bool("SKIP_SUBSCRIPTION_VERIFICATION")
+	config.SkipNotificationVerification = env.bool("SKIP_NOTIFICATION_VERIFICATION")
+	config.RequireEnvelope = env.bool("REQUIRE_ENVELOPE")
+	config.VerifyTimeout = env.duration("VERIFY_TIMEOUT")
+	config.SubscribeTimeout = env.duration("SUBSCRIBE_TIMEOUT")
+	config.DownloadTimeout = env.duration("DOWNLOAD_TIMEOUT")
+	config.HTTPTimeout = env.duration("HTTP_TIMEOUT")
+	config.StrictTimestamps = env.bool("STRICT_TIMESTAMPS")
+	config.Quarantine = QuarantinePolicy{
+		Spam:          env.verdictAction("QUARANTINE_SPAM"),
+		Virus:         env.verdictAction("QUARANTINE_VIRUS"),
+		Spf:           env.verdictAction("QUARANTINE_SPF"),
+		Dkim:          env.verdictAction("QUARANTINE_DKIM"),
+		Dmarc:         env.verdictAction("QUARANTINE_DMARC"),
+		DmarcPolicies: env.list("QUARANTINE_DMARC_POLICIES"),
+	}
+
+	if env.err != nil {
+		return nil, env.err
+	}
+	return config, nil
+}
+
+// reading environment variables, keeping the first error
+type envReader struct {
+	prefix string
+	err    error
+}
+
+func (e *envReader) string(name string) string {
+	return strings.TrimSpace(os.Getenv(e.prefix + name))
+}
+
+// comma separated values
+func (e *envReader) list(name string) []string {
+	var values []string
+	for _, v := range strings.Split(e.string(name), ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
+func (e *envReader) verdictAction(name string) VerdictAction {
+	var action VerdictAction
+	e.fail(name, action.UnmarshalText([]byte(e.string(name))))
+	return action
+}
+
+func (e *envReader) int(name string) int64 {
+	v := e.string(name)
+	if v == "" {
+		return 0
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	e.fail(name, err)
+	return n
+}
+
+func (e *envReader) bool(name string) bool {
+	v := e.string(name)
+	if v == "" {
+		return false
+	}
+	b, err := strconv.ParseBool(v)
+	e.fail(name, err)
+	return b
+}
+
+func (e *envReader) duration(name string) Duration {
+	v := e.string(name)
+	if v == "" {
+		return 0
+	}
+	d, err := time.ParseDuration(v)
+	e.fail(name, err)
+	return Duration(d)
+}
+
+func (e *envReader) fail(name string, err error) {
+	if err != nil && e.err == nil {
+		e.err = fmt.Errorf("invalid %s%s: %w", e.prefix, name, err)
+	}
+}
@@ -0,0 +1,41 @@
+package awshandler
+
+import (
+	"context"
+	"time"
+
+	"github.com/igorrendulic/couchdb-experiment/email/mime/handler"
+)
+
+// ContextSmtpHandler is implemented by handlers supporting cancellation (e.g. AwsSmtpHandler and Dispatcher).
+// Batch, SQS and Lambda adapters use it when the wrapped handler implements it.
+type ContextSmtpHandler interface {
+	HandleSmtpContext(ctx context.Context, message []byte) (*handler.MailReceived, error)
+}
+
+// Timeouts limit duration of single stages of handling a message, zero means no limit apart from the context deadline
+type Timeouts struct {
+	Verify    time.Duration // SNS signature verification including signing certificate download
+	Subscribe time.Duration // visiting SubscribeURL of subscription confirmation
+	Download  time.Duration // S3 download of MIME content of Received notification
+}
+
+// SetTimeouts sets per-stage timeouts
+func (p *AwsSmtpHandler) SetTimeouts(timeouts Timeouts) {
+	p.timeouts = timeouts
+}
+
+func withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// handling message bound to ctx if the handler supports it
+func handleSmtp(ctx context.Context, h handler.SmtpHandler, message []byte) (*handler.MailReceived, error) {
+	if ch, ok := h.(ContextSmtpHandler); ok {
+		return ch.HandleSmtpContext(ctx, message)
+	}
+	return h.HandleSmtp(message)
+}
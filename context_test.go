@@ -0,0 +1,67 @@
+package awshandler
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/awstesting/unit"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// S3 client blocking until request context is done
+func blockingSvc() *s3.S3 {
+	svc := s3.New(unit.Session)
+	svc.Handlers.Send.Clear()
+	svc.Handlers.Send.PushBack(func(r *request.Request) {
+		<-r.Context().Done()
+		r.Error = awserr.New(request.CanceledErrorCode, "request context canceled", r.Context().Err())
+	})
+	return svc
+}
+
+func TestHandleSmtpContextCancel(t *testing.T) {
+	received, err := LoadPayload("test_data/received.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	smtpHandler := newAwsSmtpHandler(blockingSvc())
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(10*time.Millisecond, cancel)
+
+	done := make(chan error)
+	go func() {
+		_, err := smtpHandler.HandleSmtpContext(ctx, received)
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		if ErrorKind(err) != "s3_other" || !IsRetryable(err) {
+			t.Fatalf("expected retryable S3 error, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("download wasn't cancelled")
+	}
+}
+
+func TestDownloadTimeout(t *testing.T) {
+	received, err := LoadPayload("test_data/received.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	smtpHandler := newAwsSmtpHandler(blockingSvc())
+	smtpHandler.SetTimeouts(Timeouts{Download: 10 * time.Millisecond})
+
+	start := time.Now()
+	if _, err := smtpHandler.HandleSmtp(received); err == nil {
+		t.Fatal("expected timeout error")
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Fatalf("download timeout wasn't applied")
+	}
+}
@@ -0,0 +1,295 @@
+// Package couchdbtest provides an in-memory stand-in for CouchDB HTTP API to test code using package couchdb
+package couchdbtest
+
+import (
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Server is an in-memory CouchDB implementing database creation, document GET/PUT, attachments, _bulk_docs,
+// Mango index creation and views (see SetView).
+// Documents are stored as decoded JSON, inline attachments are replaced by stubs as CouchDB does.
+type Server struct {
+	server *httptest.Server
+
+	mu        sync.Mutex
+	databases map[string]map[string]map[string]interface{} // database -> id -> document
+	indexes   map[string]map[string]bool                   // database -> Mango index names
+	views     map[viewKey]MapFunc
+	requests  []string
+}
+
+func NewServer() *Server {
+	s := &Server{databases: map[string]map[string]map[string]interface{}{}}
+	s.server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	return s
+}
+
+func (s *Server) Close() {
+	s.server.Close()
+}
+
+// URL of the server
+func (s *Server) URL() string {
+	return s.server.URL
+}
+
+// Requests returns method and path of all requests in order (e.g. "PUT /mail/received:abc")
+func (s *Server) Requests() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string{}, s.requests...)
+}
+
+// Document returns stored document, nil if it doesn't exist
+func (s *Server) Document(database string, id string) map[string]interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.databases[database][id]
+}
+
+// Documents returns number of documents in the database
+func (s *Server) Documents(database string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.databases[database])
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests = append(s.requests, r.Method+" "+r.URL.EscapedPath())
+
+	parts := strings.SplitN(strings.TrimPrefix(r.URL.EscapedPath(), "/"), "/", 2)
+	database, err := url.PathUnescape(parts[0])
+	if err != nil || database == "" {
+		writeError(w, http.StatusBadRequest, "bad_request", "invalid database name")
+		return
+	}
+
+	if len(parts) == 1 {
+		s.serveDatabase(w, r, database)
+		return
+	}
+	docs, ok := s.databases[database]
+	if !ok {
+		writeError(w, http.StatusNotFound, "not_found", "Database does not exist.")
+		return
+	}
+
+	path := parts[1]
+	if path == "_bulk_docs" && r.Method == http.MethodPost {
+		s.bulkDocs(w, r, docs)
+		return
+	}
+	if path == "_index" && r.Method == http.MethodPost {
+		s.createIndex(w, r, database)
+		return
+	}
+
+	id, attachment, err := splitDocPath(path)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "bad_request", err.Error())
+		return
+	}
+	if ddoc, view, ok := splitViewPath(id, attachment); ok && r.Method == http.MethodGet {
+		s.queryView(w, r, docs, ddoc, view)
+		return
+	}
+	switch {
+	case r.Method == http.MethodGet && attachment != "":
+		s.getAttachment(w, docs, id, attachment)
+	case r.Method == http.MethodGet:
+		doc, ok := docs[id]
+		if !ok {
+			writeError(w, http.StatusNotFound, "not_found", "missing")
+			return
+		}
+		writeJSON(w, http.StatusOK, withStubs(doc))
+	case r.Method == http.MethodPut && attachment == "":
+		var doc map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&doc); err != nil {
+			writeError(w, http.StatusBadRequest, "bad_request", err.Error())
+			return
+		}
+		doc["_id"] = id
+		rev, err := store(docs, doc)
+		if err != nil {
+			writeError(w, http.StatusConflict, "conflict", err.Error())
+			return
+		}
+		writeJSON(w, http.StatusCreated, map[string]interface{}{"ok": true, "id": id, "rev": rev})
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "method_not_allowed", "method not supported by couchdbtest")
+	}
+}
+
+func (s *Server) serveDatabase(w http.ResponseWriter, r *http.Request, database string) {
+	switch r.Method {
+	case http.MethodPut:
+		if _, ok := s.databases[database]; ok {
+			writeError(w, http.StatusPreconditionFailed, "file_exists", "The database could not be created, the file already exists.")
+			return
+		}
+		s.databases[database] = map[string]map[string]interface{}{}
+		writeJSON(w, http.StatusCreated, map[string]interface{}{"ok": true})
+	case http.MethodGet:
+		docs, ok := s.databases[database]
+		if !ok {
+			writeError(w, http.StatusNotFound, "not_found", "Database does not exist.")
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"db_name": database, "doc_count": len(docs)})
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "method_not_allowed", "method not supported by couchdbtest")
+	}
+}
+
+func (s *Server) bulkDocs(w http.ResponseWriter, r *http.Request, docs map[string]map[string]interface{}) {
+	var request struct {
+		Docs []map[string]interface{} `json:"docs"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		writeError(w, http.StatusBadRequest, "bad_request", err.Error())
+		return
+	}
+
+	results := []map[string]interface{}{}
+	for _, doc := range request.Docs {
+		id, _ := doc["_id"].(string)
+		if id == "" {
+			results = append(results, map[string]interface{}{"error": "bad_request", "reason": "couchdbtest requires _id"})
+			continue
+		}
+		rev, err := store(docs, doc)
+		if err != nil {
+			results = append(results, map[string]interface{}{"id": id, "error": "conflict", "reason": err.Error()})
+			continue
+		}
+		results = append(results, map[string]interface{}{"ok": true, "id": id, "rev": rev})
+	}
+	writeJSON(w, http.StatusCreated, results)
+}
+
+func (s *Server) getAttachment(w http.ResponseWriter, docs map[string]map[string]interface{}, id string, name string) {
+	data, contentType, ok := attachmentData(docs[id], name)
+	if !ok {
+		writeError(w, http.StatusNotFound, "not_found", "Document is missing attachment")
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+}
+
+// storing document if its _rev matches the current revision, inline attachments are kept as data with stub flag
+func store(docs map[string]map[string]interface{}, doc map[string]interface{}) (string, error) {
+	id := doc["_id"].(string)
+	rev, _ := doc["_rev"].(string)
+
+	generation := 0
+	if existing, ok := docs[id]; ok {
+		if existing["_rev"] != rev {
+			return "", fmt.Errorf("Document update conflict.")
+		}
+		generation, _ = strconv.Atoi(strings.SplitN(rev, "-", 2)[0])
+	} else if rev != "" {
+		return "", fmt.Errorf("Document update conflict.")
+	}
+
+	body, _ := json.Marshal(doc)
+	newRev := fmt.Sprintf("%d-%x", generation+1, md5.Sum(body))
+	doc["_rev"] = newRev
+
+	if attachments, ok := doc["_attachments"].(map[string]interface{}); ok {
+		for _, a := range attachments {
+			attachment, ok := a.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			if data, ok := attachment["data"].(string); ok {
+				decoded, _ := base64.StdEncoding.DecodeString(data)
+				attachment["length"] = len(decoded)
+				attachment["stub"] = true
+			}
+		}
+	}
+	docs[id] = doc
+	return newRev, nil
+}
+
+// copy of the document with attachment data left out as in CouchDB responses
+func withStubs(doc map[string]interface{}) map[string]interface{} {
+	attachments, ok := doc["_attachments"].(map[string]interface{})
+	if !ok {
+		return doc
+	}
+	stubs := map[string]interface{}{}
+	for name, a := range attachments {
+		stub := map[string]interface{}{}
+		for k, v := range a.(map[string]interface{}) {
+			if k != "data" {
+				stub[k] = v
+			}
+		}
+		stubs[name] = stub
+	}
+	out := map[string]interface{}{}
+	for k, v := range doc {
+		out[k] = v
+	}
+	out["_attachments"] = stubs
+	return out
+}
+
+func attachmentData(doc map[string]interface{}, name string) ([]byte, string, bool) {
+	attachments, _ := doc["_attachments"].(map[string]interface{})
+	attachment, ok := attachments[name].(map[string]interface{})
+	if !ok {
+		return nil, "", false
+	}
+	encoded, _ := attachment["data"].(string)
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, "", false
+	}
+	contentType, _ := attachment["content_type"].(string)
+	return data, contentType, true
+}
+
+// splitting escaped path into document id and attachment name (_design/{name} ids keep their slash)
+func splitDocPath(path string) (string, string, error) {
+	segments := strings.Split(path, "/")
+	if segments[0] == "_design" && len(segments) > 1 {
+		segments = append([]string{"_design/" + segments[1]}, segments[2:]...)
+	}
+	id, err := url.PathUnescape(segments[0])
+	if err != nil {
+		return "", "", err
+	}
+	attachment := ""
+	if len(segments) > 1 {
+		if attachment, err = url.PathUnescape(strings.Join(segments[1:], "/")); err != nil {
+			return "", "", err
+		}
+	}
+	return id, attachment, nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, code string, reason string) {
+	writeJSON(w, status, map[string]string{"error": code, "reason": reason})
+}
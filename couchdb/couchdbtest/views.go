@@ -0,0 +1,307 @@
+package couchdbtest
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// MapFunc is Go equivalent of a view map function, documents are decoded JSON
+type MapFunc func(doc map[string]interface{}, emit func(key interface{}, value interface{}))
+
+type viewKey struct {
+	ddoc string
+	view string
+}
+
+type row struct {
+	ID    string      `json:"id,omitempty"`
+	Key   interface{} `json:"key"`
+	Value interface{} `json:"value"`
+}
+
+// SetView registers map function of view of design document ddoc (e.g. "_design/ses"), JavaScript of installed design
+// documents isn't executed. Reduce function is read from the installed design document, only built-in _count, _sum and
+// _stats are supported.
+func (s *Server) SetView(ddoc string, view string, mapFn MapFunc) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.views == nil {
+		s.views = map[viewKey]MapFunc{}
+	}
+	s.views[viewKey{ddoc, view}] = mapFn
+}
+
+// Indexes returns names of Mango indexes created in the database
+func (s *Server) Indexes(database string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	names := []string{}
+	for name := range s.indexes[database] {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (s *Server) createIndex(w http.ResponseWriter, r *http.Request, database string) {
+	var index struct {
+		DesignDoc string `json:"ddoc"`
+		Name      string `json:"name"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&index); err != nil || index.Name == "" {
+		writeError(w, http.StatusBadRequest, "bad_request", "invalid index")
+		return
+	}
+	if s.indexes == nil {
+		s.indexes = map[string]map[string]bool{}
+	}
+	if s.indexes[database] == nil {
+		s.indexes[database] = map[string]bool{}
+	}
+	result := "created"
+	if s.indexes[database][index.Name] {
+		result = "exists"
+	}
+	s.indexes[database][index.Name] = true
+	writeJSON(w, http.StatusOK, map[string]string{"result": result, "id": "_design/" + index.DesignDoc, "name": index.Name})
+}
+
+func (s *Server) queryView(w http.ResponseWriter, r *http.Request, docs map[string]map[string]interface{}, ddoc string, view string) {
+	mapFn, ok := s.views[viewKey{ddoc, view}]
+	design, installed := docs[ddoc]
+	if !ok || !installed {
+		writeError(w, http.StatusNotFound, "not_found", "missing_named_view")
+		return
+	}
+	reduceFn := ""
+	if views, ok := design["views"].(map[string]interface{}); ok {
+		if v, ok := views[view].(map[string]interface{}); ok {
+			reduceFn, _ = v["reduce"].(string)
+		}
+	}
+
+	query := r.URL.Query()
+	keys := map[string]interface{}{}
+	for _, name := range []string{"key", "start_key", "end_key"} {
+		if v := query.Get(name); v != "" {
+			var key interface{}
+			if err := json.Unmarshal([]byte(v), &key); err != nil {
+				writeError(w, http.StatusBadRequest, "bad_request", "invalid "+name)
+				return
+			}
+			keys[name] = key
+		}
+	}
+	descending := query.Get("descending") == "true"
+
+	ids := make([]string, 0, len(docs))
+	for id := range docs {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	rows := []*row{}
+	for _, id := range ids {
+		mapFn(normalize(docs[id]), func(key interface{}, value interface{}) {
+			rows = append(rows, &row{ID: id, Key: normalizeValue(key), Value: normalizeValue(value)})
+		})
+	}
+	sort.SliceStable(rows, func(i, j int) bool { return collate(rows[i].Key, rows[j].Key) < 0 })
+
+	start, hasStart := keys["start_key"]
+	end, hasEnd := keys["end_key"]
+	if descending {
+		start, end = end, start
+		hasStart, hasEnd = hasEnd, hasStart
+	}
+	filtered := []*row{}
+	for _, rw := range rows {
+		if key, ok := keys["key"]; ok && collate(rw.Key, key) != 0 {
+			continue
+		}
+		if hasStart && collate(rw.Key, start) < 0 || hasEnd && collate(rw.Key, end) > 0 {
+			continue
+		}
+		filtered = append(filtered, rw)
+	}
+	rows = filtered
+
+	if reduceFn != "" && query.Get("reduce") != "false" {
+		groupLevel := 0
+		if query.Get("group") == "true" {
+			groupLevel = math.MaxInt32
+		} else if level := query.Get("group_level"); level != "" {
+			groupLevel, _ = strconv.Atoi(level)
+		}
+		var err error
+		if rows, err = reduce(rows, reduceFn, groupLevel); err != nil {
+			writeError(w, http.StatusBadRequest, "query_parse_error", err.Error())
+			return
+		}
+	}
+
+	if descending {
+		for i, j := 0, len(rows)-1; i < j; i, j = i+1, j-1 {
+			rows[i], rows[j] = rows[j], rows[i]
+		}
+	}
+	if limit, err := strconv.Atoi(query.Get("limit")); err == nil && limit < len(rows) {
+		rows = rows[:limit]
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"rows": rows})
+}
+
+// reducing sorted rows with built-in reduce function, groupLevel 0 reduces all rows to one
+func reduce(rows []*row, reduceFn string, groupLevel int) ([]*row, error) {
+	groups := [][]*row{}
+	groupKeys := []interface{}{}
+	for _, rw := range rows {
+		key := groupKey(rw.Key, groupLevel)
+		if n := len(groups); n > 0 && collate(groupKeys[n-1], key) == 0 {
+			groups[n-1] = append(groups[n-1], rw)
+			continue
+		}
+		groups = append(groups, []*row{rw})
+		groupKeys = append(groupKeys, key)
+	}
+
+	reduced := []*row{}
+	for i, group := range groups {
+		var value interface{}
+		switch reduceFn {
+		case "_count":
+			value = float64(len(group))
+		case "_sum":
+			sum := 0.0
+			for _, rw := range group {
+				n, _ := rw.Value.(float64)
+				sum += n
+			}
+			value = sum
+		case "_stats":
+			stats := map[string]float64{"sum": 0, "count": 0, "min": math.Inf(1), "max": math.Inf(-1), "sumsqr": 0}
+			for _, rw := range group {
+				n, _ := rw.Value.(float64)
+				stats["sum"] += n
+				stats["count"]++
+				stats["min"] = math.Min(stats["min"], n)
+				stats["max"] = math.Max(stats["max"], n)
+				stats["sumsqr"] += n * n
+			}
+			value = stats
+		default:
+			return nil, &unsupportedReduceError{reduceFn}
+		}
+		reduced = append(reduced, &row{Key: groupKeys[i], Value: value})
+	}
+	if groupLevel == 0 && len(reduced) == 0 {
+		return []*row{}, nil
+	}
+	return reduced, nil
+}
+
+type unsupportedReduceError struct {
+	reduceFn string
+}
+
+func (e *unsupportedReduceError) Error() string {
+	return "couchdbtest only supports built-in reduce functions, got " + e.reduceFn
+}
+
+func groupKey(key interface{}, groupLevel int) interface{} {
+	if groupLevel == 0 {
+		return nil
+	}
+	if array, ok := key.([]interface{}); ok && groupLevel < len(array) {
+		return array[:groupLevel]
+	}
+	return key
+}
+
+// CouchDB collation: null < false < true < numbers < strings < arrays < objects (strings compared by code point)
+func collate(a interface{}, b interface{}) int {
+	ra, rb := collationRank(a), collationRank(b)
+	if ra != rb {
+		return ra - rb
+	}
+	switch va := a.(type) {
+	case bool:
+		return collationRank(va) - collationRank(b.(bool))
+	case float64:
+		vb := b.(float64)
+		if va < vb {
+			return -1
+		} else if va > vb {
+			return 1
+		}
+	case string:
+		vb := b.(string)
+		if va < vb {
+			return -1
+		} else if va > vb {
+			return 1
+		}
+	case []interface{}:
+		vb := b.([]interface{})
+		for i := 0; i < len(va) && i < len(vb); i++ {
+			if c := collate(va[i], vb[i]); c != 0 {
+				return c
+			}
+		}
+		return len(va) - len(vb)
+	case map[string]interface{}:
+		if reflect.DeepEqual(a, b) {
+			return 0
+		}
+		return len(va) - len(b.(map[string]interface{}))
+	}
+	return 0
+}
+
+func collationRank(v interface{}) int {
+	switch v := v.(type) {
+	case nil:
+		return 0
+	case bool:
+		if v {
+			return 2
+		}
+		return 1
+	case float64:
+		return 3
+	case string:
+		return 4
+	case []interface{}:
+		return 5
+	}
+	return 6
+}
+
+// documents and emitted values as they'd be decoded from JSON (float64 numbers, []interface{} arrays)
+func normalize(doc map[string]interface{}) map[string]interface{} {
+	out, _ := normalizeValue(doc).(map[string]interface{})
+	return out
+}
+
+func normalizeValue(v interface{}) interface{} {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil
+	}
+	var out interface{}
+	json.Unmarshal(data, &out)
+	return out
+}
+
+// design document id and view name of path _design/{ddoc}/_view/{view}
+func splitViewPath(id string, attachment string) (string, string, bool) {
+	if !strings.HasPrefix(id, "_design/") || !strings.HasPrefix(attachment, "_view/") {
+		return "", "", false
+	}
+	return id, strings.TrimPrefix(attachment, "_view/"), true
+}
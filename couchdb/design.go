@@ -0,0 +1,127 @@
+package couchdb
+
+import (
+	"context"
+	"embed"
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+//go:embed design/*.json
+var designFiles embed.FS
+
+// DesignDocumentID is id of the design document with analytics views (design/ses.json)
+const DesignDocumentID = "_design/ses"
+
+// views of DesignDocumentID
+const (
+	ViewBouncesByRecipient         = "bounces_by_recipient"          // [recipient, bounceType] -> count
+	ViewBouncesByDomain            = "bounces_by_domain"             // [domain, bounceType] -> count
+	ViewBouncesByDay               = "bounces_by_day"                // [YYYY-MM-DD, bounceType] -> bounced recipients
+	ViewComplaintsByFeedbackType   = "complaints_by_feedback_type"   // feedbackType -> complained recipients
+	ViewDeliveriesByProcessingTime = "deliveries_by_processing_time" // YYYY-MM-DD -> stats of processingTimeMillis
+)
+
+// DesignDocument is a CouchDB design document with map/reduce views.
+// Version is increased whenever the views change, InstallDesign only replaces older versions.
+type DesignDocument struct {
+	ID       string           `json:"_id"`
+	Rev      string           `json:"_rev,omitempty"`
+	Language string           `json:"language"`
+	Version  int              `json:"version"`
+	Views    map[string]*View `json:"views"`
+}
+
+type View struct {
+	Map    string `json:"map"`
+	Reduce string `json:"reduce,omitempty"`
+}
+
+// Index is a Mango index definition (POST /{db}/_index)
+type Index struct {
+	DesignDoc string          `json:"ddoc"`
+	Name      string          `json:"name"`
+	Type      string          `json:"type"`
+	Index     json.RawMessage `json:"index"`
+}
+
+// Design returns design document shipped with the package
+func Design() (*DesignDocument, error) {
+	doc := &DesignDocument{}
+	if err := readDesignFile("design/ses.json", doc); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+// Indexes returns Mango indexes shipped with the package (for _find queries over stored notifications)
+func Indexes() ([]*Index, error) {
+	indexes := []*Index{}
+	if err := readDesignFile("design/indexes.json", &indexes); err != nil {
+		return nil, err
+	}
+	return indexes, nil
+}
+
+// InstallResult reports what InstallDesign changed
+type InstallResult struct {
+	DesignVersion  int  // version of the design document in the database after install
+	DesignUpdated  bool // design document was created or migrated from an older version
+	IndexesCreated int  // number of Mango indexes which didn't exist before
+}
+
+// InstallDesign creates or migrates the design document and creates Mango indexes.
+// Design document of the same or newer version (e.g. installed by a newer release) is left untouched.
+func (c *Client) InstallDesign(ctx context.Context) (*InstallResult, error) {
+	design, err := Design()
+	if err != nil {
+		return nil, err
+	}
+
+	result := &InstallResult{}
+	existing := &DesignDocument{}
+	err = c.Get(ctx, design.ID, existing)
+	switch {
+	case IsNotFound(err):
+	case err != nil:
+		return nil, err
+	case existing.Version >= design.Version:
+		result.DesignVersion = existing.Version
+	default:
+		design.Rev = existing.Rev
+	}
+
+	if result.DesignVersion == 0 {
+		if _, err := c.Put(ctx, design.ID, design); err != nil {
+			return nil, fmt.Errorf("failed to install %s: %w", design.ID, err)
+		}
+		result.DesignVersion = design.Version
+		result.DesignUpdated = true
+	}
+
+	indexes, err := Indexes()
+	if err != nil {
+		return nil, err
+	}
+	for _, index := range indexes {
+		var response struct {
+			Result string `json:"result"` // created or exists
+		}
+		if err := c.do(ctx, http.MethodPost, "/_index", nil, index, &response); err != nil {
+			return nil, fmt.Errorf("failed to create index %s: %w", index.Name, err)
+		}
+		if response.Result == "created" {
+			result.IndexesCreated++
+		}
+	}
+	return result, nil
+}
+
+func readDesignFile(name string, v interface{}) error {
+	data, err := designFiles.ReadFile(name)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
@@ -0,0 +1,208 @@
+package couchdb
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/igorrendulic/couchdb-email-aws-parse/couchdb/couchdbtest"
+	"github.com/igorrendulic/couchdb-experiment/email/mime/handler"
+)
+
+// Go equivalents of map functions in design/ses.json
+func setAnalyticsViews(server *couchdbtest.Server) {
+	day := func(doc map[string]interface{}) string {
+		return time.UnixMilli(int64(doc["timestamp"].(float64))).UTC().Format("2006-01-02")
+	}
+	bounceRecipients := func(doc map[string]interface{}, emit func(address string, bounceType string)) {
+		bounce, ok := doc["bounce"].(map[string]interface{})
+		if doc["type"] != DocumentType || !ok {
+			return
+		}
+		for _, r := range bounce["recipients"].([]interface{}) {
+			emit(strings.ToLower(r.(map[string]interface{})["emailAddress"].(string)), bounce["bounceType"].(string))
+		}
+	}
+
+	server.SetView(DesignDocumentID, ViewBouncesByRecipient, func(doc map[string]interface{}, emit func(interface{}, interface{})) {
+		bounceRecipients(doc, func(address string, bounceType string) {
+			emit([]interface{}{address, bounceType}, 1)
+		})
+	})
+	server.SetView(DesignDocumentID, ViewBouncesByDomain, func(doc map[string]interface{}, emit func(interface{}, interface{})) {
+		bounceRecipients(doc, func(address string, bounceType string) {
+			emit([]interface{}{address[strings.LastIndex(address, "@")+1:], bounceType}, 1)
+		})
+	})
+	server.SetView(DesignDocumentID, ViewBouncesByDay, func(doc map[string]interface{}, emit func(interface{}, interface{})) {
+		if bounce, ok := doc["bounce"].(map[string]interface{}); ok {
+			emit([]interface{}{day(doc), bounce["bounceType"]}, len(bounce["recipients"].([]interface{})))
+		}
+	})
+	server.SetView(DesignDocumentID, ViewComplaintsByFeedbackType, func(doc map[string]interface{}, emit func(interface{}, interface{})) {
+		if complaint, ok := doc["complaint"].(map[string]interface{}); ok {
+			feedbackType, _ := complaint["feedbackType"].(string)
+			if feedbackType == "" {
+				feedbackType = "unknown"
+			}
+			emit(feedbackType, len(complaint["recipients"].([]interface{})))
+		}
+	})
+	server.SetView(DesignDocumentID, ViewDeliveriesByProcessingTime, func(doc map[string]interface{}, emit func(interface{}, interface{})) {
+		if delivery, ok := doc["delivery"].(map[string]interface{}); ok {
+			emit(day(doc), delivery["processingTimeMillis"])
+		}
+	})
+}
+
+func TestDesignDocument(t *testing.T) {
+	design, err := Design()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if design.ID != DesignDocumentID || design.Version < 1 {
+		t.Fatalf("unexpected design document %+v", design)
+	}
+	for _, view := range []string{ViewBouncesByRecipient, ViewBouncesByDomain, ViewBouncesByDay, ViewComplaintsByFeedbackType, ViewDeliveriesByProcessingTime} {
+		if v := design.Views[view]; v == nil || v.Map == "" || v.Reduce == "" {
+			t.Fatalf("missing view %s", view)
+		}
+	}
+	indexes, err := Indexes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(indexes) == 0 {
+		t.Fatal("expected mango indexes")
+	}
+}
+
+func TestInstallDesign(t *testing.T) {
+	sink, server := newTestSink(t)
+	ctx := context.Background()
+
+	result, err := sink.client.InstallDesign(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	indexes, _ := Indexes()
+	if !result.DesignUpdated || result.IndexesCreated != len(indexes) || len(server.Indexes("mail")) != len(indexes) {
+		t.Fatalf("unexpected install result %+v", result)
+	}
+
+	result, err = sink.client.InstallDesign(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.DesignUpdated || result.IndexesCreated != 0 {
+		t.Fatalf("second install shouldn't change anything: %+v", result)
+	}
+
+	// older version is migrated
+	installed := &DesignDocument{}
+	if err := sink.client.Get(ctx, DesignDocumentID, installed); err != nil {
+		t.Fatal(err)
+	}
+	installed.Version = 0
+	if _, err := sink.client.Put(ctx, DesignDocumentID, installed); err != nil {
+		t.Fatal(err)
+	}
+	result, err = sink.client.InstallDesign(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	design, _ := Design()
+	if !result.DesignUpdated || result.DesignVersion != design.Version {
+		t.Fatalf("expected migration, got %+v", result)
+	}
+
+	// newer version is kept
+	if err := sink.client.Get(ctx, DesignDocumentID, installed); err != nil {
+		t.Fatal(err)
+	}
+	installed.Version = design.Version + 1
+	if _, err := sink.client.Put(ctx, DesignDocumentID, installed); err != nil {
+		t.Fatal(err)
+	}
+	result, err = sink.client.InstallDesign(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.DesignUpdated || result.DesignVersion != design.Version+1 {
+		t.Fatalf("newer design shouldn't be replaced: %+v", result)
+	}
+}
+
+func TestAnalyticsQueries(t *testing.T) {
+	sink, server := newTestSink(t)
+	setAnalyticsViews(server)
+	ctx := context.Background()
+	if _, err := sink.client.InstallDesign(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	day1 := time.Date(2023, 2, 21, 10, 0, 0, 0, time.UTC)
+	day2 := day1.Add(24 * time.Hour)
+	bounce := func(id string, at time.Time, bounceType string, recipients ...string) *handler.MailReceived {
+		b := &handler.Bounce{BounceType: bounceType}
+		for _, r := range recipients {
+			b.BouncedRecipients = append(b.BouncedRecipients, &handler.BouncedRecipient{EmailAddress: r})
+		}
+		return &handler.MailReceived{NotificationType: "Bounce", Timestamp: at.UnixMilli(), Mail: &handler.Mail{MessageID: id}, Bounce: b}
+	}
+	mails := []*handler.MailReceived{
+		bounce("b1", day1, "Permanent", "a@example.com", "b@example.org"),
+		bounce("b2", day1, "Transient", "A@example.com"),
+		bounce("b3", day2, "Permanent", "a@example.com"),
+		{NotificationType: "Complaint", Timestamp: day1.UnixMilli(), Mail: &handler.Mail{MessageID: "c1"},
+			Complaint: &handler.Complaint{ComplaintFeedbackType: "abuse", ComplainedRecipients: []*handler.ComplainedRecipient{{EmailAddress: "a@example.com"}}}},
+		{NotificationType: "Complaint", Timestamp: day2.UnixMilli(), Mail: &handler.Mail{MessageID: "c2"},
+			Complaint: &handler.Complaint{ComplainedRecipients: []*handler.ComplainedRecipient{{EmailAddress: "b@example.com"}}}},
+		{NotificationType: "Delivery", Timestamp: day1.UnixMilli(), Mail: &handler.Mail{MessageID: "d1"}, Delivery: &handler.Delivery{ProcessingTimeMillis: 100}},
+		{NotificationType: "Delivery", Timestamp: day1.UnixMilli() + 1, Mail: &handler.Mail{MessageID: "d2"}, Delivery: &handler.Delivery{ProcessingTimeMillis: 300}},
+	}
+	if _, err := sink.StoreBulk(ctx, mails); err != nil {
+		t.Fatal(err)
+	}
+
+	byRecipient, err := sink.client.BouncesByRecipient(ctx, "A@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(byRecipient) != 2 || byRecipient[0].BounceType != "Permanent" || byRecipient[0].Count != 2 || byRecipient[1].Count != 1 {
+		t.Fatalf("unexpected bounces by recipient %+v %+v", byRecipient[0], byRecipient[1:])
+	}
+
+	byDomain, err := sink.client.BouncesByDomain(ctx, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(byDomain) != 3 || byDomain[0].Key != "example.com" || byDomain[2].Key != "example.org" {
+		t.Fatalf("unexpected bounces by domain %d", len(byDomain))
+	}
+
+	byDay, err := sink.client.BouncesByDay(ctx, day2, day2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(byDay) != 1 || byDay[0].Key != "2023-02-22" || byDay[0].Count != 1 {
+		t.Fatalf("unexpected bounces by day %d", len(byDay))
+	}
+
+	complaints, err := sink.client.ComplaintsByFeedbackType(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(complaints) != 2 || complaints[0].FeedbackType != "abuse" || complaints[1].FeedbackType != "unknown" {
+		t.Fatalf("unexpected complaints %d", len(complaints))
+	}
+
+	stats, err := sink.client.DeliveriesByProcessingTime(ctx, day1, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stats) != 1 || stats[0].Count != 2 || stats[0].Mean() != 200 || stats[0].Min != 100 || stats[0].Max != 300 {
+		t.Fatalf("unexpected delivery stats %+v", stats)
+	}
+}
@@ -0,0 +1,231 @@
+package couchdb
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"strings"
+
+	"github.com/igorrendulic/couchdb-experiment/email/mime/handler"
+)
+
+// DocumentType is the value of type field of all documents written by Sink
+const DocumentType = "ses_notification"
+
+// name and content type of RawMime attachment
+const (
+	RawMimeAttachment  = "raw.eml"
+	RawMimeContentType = "message/rfc822"
+)
+
+// Document is CouchDB representation of handler.MailReceived
+type Document struct {
+	ID               string                 `json:"_id"`
+	Rev              string                 `json:"_rev,omitempty"`
+	Type             string                 `json:"type"`
+	NotificationType string                 `json:"notificationType"`
+	Timestamp        int64                  `json:"timestamp"`
+	Mail             *MailDocument          `json:"mail,omitempty"`
+	Receipt          *ReceiptDocument       `json:"receipt,omitempty"`
+	Bounce           *BounceDocument        `json:"bounce,omitempty"`
+	Complaint        *ComplaintDocument     `json:"complaint,omitempty"`
+	Delivery         *DeliveryDocument      `json:"delivery,omitempty"`
+	Quarantine       *QuarantineDocument    `json:"quarantine,omitempty"` // set by Sink.Middleware for quarantined messages
+	Attachments      map[string]*Attachment `json:"_attachments,omitempty"`
+}
+
+type MailDocument struct {
+	MessageID   string   `json:"messageId"`
+	Source      string   `json:"source,omitempty"`
+	Destination []string `json:"destination,omitempty"`
+	From        []string `json:"from,omitempty"`
+	To          []string `json:"to,omitempty"`
+	Subject     string   `json:"subject,omitempty"`
+	HeaderID    string   `json:"headerMessageId,omitempty"` // Message-ID header (differs from SES messageId)
+}
+
+type ReceiptDocument struct {
+	ActionType           string   `json:"actionType,omitempty"`
+	Topic                string   `json:"topic,omitempty"`
+	ObjectURL            string   `json:"objectUrl,omitempty"`
+	Recipients           []string `json:"recipients,omitempty"`
+	ProcessingTimeMillis int      `json:"processingTimeMillis,omitempty"`
+	SpamVerdict          string   `json:"spamVerdict,omitempty"`
+	VirusVerdict         string   `json:"virusVerdict,omitempty"`
+	SpfVerdict           string   `json:"spfVerdict,omitempty"`
+	DkimVerdict          string   `json:"dkimVerdict,omitempty"`
+}
+
+type BounceDocument struct {
+	BounceType    string                      `json:"bounceType"`
+	BounceSubType string                      `json:"bounceSubType,omitempty"`
+	ReportingMTA  string                      `json:"reportingMTA,omitempty"`
+	Recipients    []*BouncedRecipientDocument `json:"recipients"`
+}
+
+type BouncedRecipientDocument struct {
+	EmailAddress   string `json:"emailAddress"`
+	Action         string `json:"action,omitempty"`
+	Status         string `json:"status,omitempty"`
+	DiagnosticCode string `json:"diagnosticCode,omitempty"`
+}
+
+type ComplaintDocument struct {
+	FeedbackType string   `json:"feedbackType,omitempty"`
+	UserAgent    string   `json:"userAgent,omitempty"`
+	Recipients   []string `json:"recipients"`
+}
+
+type DeliveryDocument struct {
+	Timestamp            int64  `json:"timestamp"`
+	ProcessingTimeMillis int    `json:"processingTimeMillis,omitempty"`
+	SmtpResponse         string `json:"smtpResponse,omitempty"`
+}
+
+// QuarantineDocument is the verdict of a quarantined or rejected message (awshandler.Quarantine without MIME content)
+type QuarantineDocument struct {
+	Action  string   `json:"action"`
+	Reasons []string `json:"reasons,omitempty"`
+}
+
+// Attachment is an inline (Data) or stored (Stub) CouchDB attachment
+type Attachment struct {
+	ContentType string `json:"content_type"`
+	Data        []byte `json:"data,omitempty"` // base64 encoded in JSON as expected by CouchDB
+	Stub        bool   `json:"stub,omitempty"`
+	Length      int64  `json:"length,omitempty"`
+	Digest      string `json:"digest,omitempty"`
+}
+
+// DocumentID is deterministic id of the notification document: notification type and SES messageId.
+// SES can publish several bounce, complaint and delivery events for one message so these also include a hash of
+// the event content (not of timestamps which aren't stable when missing in the notification).
+// Notifications without messageId are rejected, they can't be stored idempotently.
+func DocumentID(mail *handler.MailReceived) (string, error) {
+	if mail.Mail == nil || mail.Mail.MessageID == "" {
+		return "", &Error{Code: "missing_message_id", Reason: "notification without mail messageId can't be stored"}
+	}
+	notificationType := strings.ToLower(mail.NotificationType)
+	id := notificationType + ":" + mail.Mail.MessageID
+	switch notificationType {
+	case "bounce", "complaint", "delivery":
+		return id + ":" + eventHash(mail), nil
+	}
+	return id, nil
+}
+
+// hash of bounce, complaint and delivery content of the notification
+func eventHash(mail *handler.MailReceived) string {
+	fields := []string{mail.NotificationType}
+	if b := mail.Bounce; b != nil {
+		fields = append(fields, b.BounceType, b.BounceSubType, b.ReportingMTA)
+		for _, r := range b.BouncedRecipients {
+			fields = append(fields, r.EmailAddress, r.Action, r.Status, r.DiagnosticCode)
+		}
+	}
+	if c := mail.Complaint; c != nil {
+		fields = append(fields, c.ComplaintFeedbackType, c.UserAgent)
+		for _, r := range c.ComplainedRecipients {
+			fields = append(fields, r.EmailAddress)
+		}
+	}
+	if d := mail.Delivery; d != nil {
+		fields = append(fields, strconv.Itoa(d.ProcessingTimeMillis), d.SmtpResponse)
+	}
+	sum := sha256.Sum256([]byte(strings.Join(fields, "\x00")))
+	return hex.EncodeToString(sum[:8])
+}
+
+// NewDocument maps MailReceived to Document, RawMime is inlined as attachment
+func NewDocument(mail *handler.MailReceived) (*Document, error) {
+	id, err := DocumentID(mail)
+	if err != nil {
+		return nil, err
+	}
+	doc := &Document{
+		ID:               id,
+		Type:             DocumentType,
+		NotificationType: mail.NotificationType,
+		Timestamp:        mail.Timestamp,
+	}
+
+	if m := mail.Mail; m != nil {
+		doc.Mail = &MailDocument{
+			MessageID:   m.MessageID,
+			Source:      m.Source,
+			Destination: m.Destination,
+		}
+		if h := m.CommonHeaders; h != nil {
+			doc.Mail.From = h.From
+			doc.Mail.To = h.To
+			doc.Mail.Subject = h.Subject
+			doc.Mail.HeaderID = h.MessageID
+		}
+		if len(m.RawMime) > 0 {
+			doc.Attachments = map[string]*Attachment{
+				RawMimeAttachment: {ContentType: RawMimeContentType, Data: m.RawMime},
+			}
+		}
+	}
+
+	if r := mail.Receipt; r != nil {
+		doc.Receipt = &ReceiptDocument{
+			Recipients:           r.Recipients,
+			ProcessingTimeMillis: r.ProcessingTimeMillis,
+			SpamVerdict:          verdict(r.SpamVerdict),
+			VirusVerdict:         verdict(r.VirusVerdict),
+			SpfVerdict:           verdict(r.SpfVerdict),
+			DkimVerdict:          verdict(r.DkimVerdict),
+		}
+		if a := r.Action; a != nil {
+			doc.Receipt.ActionType = a.Type
+			doc.Receipt.Topic = a.Topic
+			doc.Receipt.ObjectURL = a.ObjectURL
+		}
+	}
+
+	if b := mail.Bounce; b != nil {
+		doc.Bounce = &BounceDocument{
+			BounceType:    b.BounceType,
+			BounceSubType: b.BounceSubType,
+			ReportingMTA:  b.ReportingMTA,
+			Recipients:    []*BouncedRecipientDocument{},
+		}
+		for _, r := range b.BouncedRecipients {
+			doc.Bounce.Recipients = append(doc.Bounce.Recipients, &BouncedRecipientDocument{
+				EmailAddress:   r.EmailAddress,
+				Action:         r.Action,
+				Status:         r.Status,
+				DiagnosticCode: r.DiagnosticCode,
+			})
+		}
+	}
+
+	if c := mail.Complaint; c != nil {
+		doc.Complaint = &ComplaintDocument{
+			FeedbackType: c.ComplaintFeedbackType,
+			UserAgent:    c.UserAgent,
+			Recipients:   []string{},
+		}
+		for _, r := range c.ComplainedRecipients {
+			doc.Complaint.Recipients = append(doc.Complaint.Recipients, r.EmailAddress)
+		}
+	}
+
+	if d := mail.Delivery; d != nil {
+		doc.Delivery = &DeliveryDocument{
+			Timestamp:            d.Timestamp,
+			ProcessingTimeMillis: d.ProcessingTimeMillis,
+			SmtpResponse:         d.SmtpResponse,
+		}
+	}
+
+	return doc, nil
+}
+
+func verdict(v *handler.VerdictStatus) string {
+	if v == nil {
+		return ""
+	}
+	return v.Status
+}
@@ -0,0 +1,148 @@
+package couchdb
+
+import (
+	"context"
+
+	awshandler "github.com/igorrendulic/couchdb-email-aws-parse"
+	"github.com/igorrendulic/couchdb-experiment/email/mime/handler"
+)
+
+// annotation key of stored document id set by Sink.Middleware
+const AnnotationDocumentID = "couchdb.id"
+
+// default number of documents per _bulk_docs request
+const DefaultBatchSize = 100
+
+// StoreResult is the outcome of storing a single notification
+type StoreResult struct {
+	ID      string
+	Rev     string // empty if the document already existed
+	Existed bool   // document with the same id was stored before (e.g. redelivered notification)
+	Err     error
+}
+
+// Sink stores handled SES notifications as documents (see NewDocument).
+// Storing is idempotent, notifications delivered again map to the same document id and conflicts are reported as Existed.
+type Sink struct {
+	client    *Client
+	batchSize int
+}
+
+func NewSink(client *Client) *Sink {
+	return &Sink{client: client, batchSize: DefaultBatchSize}
+}
+
+// SetBatchSize sets number of documents per _bulk_docs request of StoreBulk
+func (s *Sink) SetBatchSize(size int) {
+	if size <= 0 {
+		size = DefaultBatchSize
+	}
+	s.batchSize = size
+}
+
+// Store writes a single notification
+func (s *Sink) Store(ctx context.Context, mail *handler.MailReceived) (*StoreResult, error) {
+	doc, err := NewDocument(mail)
+	if err != nil {
+		return nil, err
+	}
+	return s.put(ctx, doc)
+}
+
+func (s *Sink) put(ctx context.Context, doc *Document) (*StoreResult, error) {
+	rev, err := s.client.Put(ctx, doc.ID, doc)
+	if IsConflict(err) {
+		return &StoreResult{ID: doc.ID, Existed: true}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &StoreResult{ID: doc.ID, Rev: rev}, nil
+}
+
+// StoreBulk writes notifications with _bulk_docs in batches. Results are in order of mails,
+// the returned error is only set if a whole batch failed (per document errors are in StoreResult.Err).
+func (s *Sink) StoreBulk(ctx context.Context, mails []*handler.MailReceived) ([]*StoreResult, error) {
+	results := make([]*StoreResult, 0, len(mails))
+
+	for start := 0; start < len(mails); start += s.batchSize {
+		end := start + s.batchSize
+		if end > len(mails) {
+			end = len(mails)
+		}
+
+		batch := make([]*StoreResult, 0, end-start)
+		docs := make([]interface{}, 0, end-start)
+		stored := make([]*StoreResult, 0, end-start)
+		for _, mail := range mails[start:end] {
+			doc, err := NewDocument(mail)
+			if err != nil {
+				batch = append(batch, &StoreResult{Err: err})
+				continue
+			}
+			result := &StoreResult{ID: doc.ID}
+			batch = append(batch, result)
+			docs = append(docs, doc)
+			stored = append(stored, result)
+		}
+
+		if len(docs) > 0 {
+			bulkResults, err := s.client.BulkDocs(ctx, docs)
+			if err != nil {
+				return results, err
+			}
+			for i, result := range stored {
+				if i >= len(bulkResults) {
+					result.Err = &Error{Code: "missing_result", Reason: "no result for document in _bulk_docs response"}
+				} else if r := bulkResults[i]; r.Error == "conflict" {
+					result.Existed = true
+				} else if r.Error != "" {
+					result.Err = &Error{StatusCode: bulkErrorStatus(r.Error), Code: r.Error, Reason: r.Reason}
+				} else {
+					result.Rev = r.Rev
+				}
+			}
+		}
+		results = append(results, batch...)
+	}
+
+	return results, nil
+}
+
+// Middleware stores every handled notification at output stage, handling fails if the notification couldn't be stored
+// (subscription confirmations aren't stored). Quarantined messages are stored with their verdict (Document.Quarantine),
+// the middleware has to be registered after middleware quarantining messages (e.g. rules.Engine).
+func (s *Sink) Middleware() awshandler.Middleware {
+	return func(stage awshandler.Stage, ex *awshandler.Exchange) error {
+		if stage != awshandler.StageOutput || ex.Output == nil {
+			return nil
+		}
+		if ex.Output.NotificationType == string(awshandler.NotificationTypeSubscriptionConfirmation) {
+			return nil
+		}
+		doc, err := NewDocument(ex.Output)
+		if err != nil {
+			return err
+		}
+		if q := ex.Quarantine; q != nil {
+			doc.Quarantine = &QuarantineDocument{Action: q.Action.String(), Reasons: q.Reasons}
+		}
+		result, err := s.put(ex.Context(), doc)
+		if err != nil {
+			return err
+		}
+		ex.Annotate(AnnotationDocumentID, result.ID)
+		return nil
+	}
+}
+
+// status code of per document _bulk_docs errors
+func bulkErrorStatus(code string) int {
+	switch code {
+	case "forbidden":
+		return 403
+	case "unauthorized":
+		return 401
+	}
+	return 500
+}
@@ -0,0 +1,223 @@
+package couchdb
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	awshandler "github.com/igorrendulic/couchdb-email-aws-parse"
+	"github.com/igorrendulic/couchdb-email-aws-parse/couchdb/couchdbtest"
+	"github.com/igorrendulic/couchdb-experiment/email/mime/handler"
+)
+
+func newTestSink(t *testing.T) (*Sink, *couchdbtest.Server) {
+	server := couchdbtest.NewServer()
+	t.Cleanup(server.Close)
+
+	client, err := NewClient(server.URL(), "mail", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.CreateDatabase(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.CreateDatabase(context.Background()); err != nil {
+		t.Fatalf("existing database shouldn't fail: %v", err)
+	}
+	return NewSink(client), server
+}
+
+func receivedMail(messageID string) *handler.MailReceived {
+	return &handler.MailReceived{
+		NotificationType: "Received",
+		Timestamp:        1677000000000,
+		Mail: &handler.Mail{
+			MessageID:     messageID,
+			Source:        "sender@example.com",
+			Destination:   []string{"inbox@example.com"},
+			RawMime:       []byte("Subject: hello\r\n\r\nbody"),
+			CommonHeaders: &handler.CommonHeaders{Subject: "hello", From: []string{"sender@example.com"}},
+		},
+		Receipt: &handler.Receipt{
+			Action:      &handler.Action{Type: "S3"},
+			SpamVerdict: &handler.VerdictStatus{Status: "PASS"},
+		},
+	}
+}
+
+func TestDocumentID(t *testing.T) {
+	if id, err := DocumentID(receivedMail("abc")); err != nil || id != "received:abc" {
+		t.Fatalf("unexpected id %s: %v", id, err)
+	}
+
+	bounce := func(timestamp int64, recipient string) *handler.MailReceived {
+		return &handler.MailReceived{NotificationType: "Bounce", Timestamp: timestamp, Mail: &handler.Mail{MessageID: "abc"},
+			Bounce: &handler.Bounce{BounceType: "Permanent", BouncedRecipients: []*handler.BouncedRecipient{{EmailAddress: recipient}}}}
+	}
+	id1, err := DocumentID(bounce(42, "a@example.com"))
+	if err != nil || !strings.HasPrefix(id1, "bounce:abc:") {
+		t.Fatalf("unexpected id %s: %v", id1, err)
+	}
+	// redelivery with a different (e.g. synthesized) timestamp maps to the same document
+	if id, _ := DocumentID(bounce(43, "a@example.com")); id != id1 {
+		t.Fatalf("expected id independent of timestamp, got %s and %s", id1, id)
+	}
+	if id, _ := DocumentID(bounce(42, "b@example.com")); id == id1 {
+		t.Fatalf("expected different id for different bounce event")
+	}
+
+	for _, mail := range []*handler.MailReceived{
+		{NotificationType: "Received"},
+		{NotificationType: "Bounce", Timestamp: 42, Mail: &handler.Mail{}},
+	} {
+		if _, err := DocumentID(mail); err == nil {
+			t.Fatalf("expected error for notification without messageId")
+		}
+		if _, err := NewDocument(mail); err == nil {
+			t.Fatalf("expected error for notification without messageId")
+		}
+	}
+}
+
+func TestSinkStore(t *testing.T) {
+	sink, server := newTestSink(t)
+	ctx := context.Background()
+
+	result, err := sink.Store(ctx, receivedMail("abc"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.ID != "received:abc" || result.Rev == "" || result.Existed {
+		t.Fatalf("unexpected result %+v", result)
+	}
+
+	// redelivered notification
+	result, err = sink.Store(ctx, receivedMail("abc"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Existed || result.Rev != "" {
+		t.Fatalf("expected existing document, got %+v", result)
+	}
+
+	var doc Document
+	if err := sink.client.Get(ctx, "received:abc", &doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc.Type != DocumentType || doc.Mail.Subject != "hello" || doc.Receipt.SpamVerdict != "PASS" {
+		t.Fatalf("unexpected document %+v", doc)
+	}
+	attachment := doc.Attachments[RawMimeAttachment]
+	if attachment == nil || !attachment.Stub || attachment.ContentType != RawMimeContentType {
+		t.Fatalf("unexpected attachment %+v", attachment)
+	}
+
+	resp, err := http.Get(server.URL() + "/mail/received:abc/" + RawMimeAttachment)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	raw, _ := io.ReadAll(resp.Body)
+	if string(raw) != "Subject: hello\r\n\r\nbody" {
+		t.Fatalf("unexpected attachment content %q", raw)
+	}
+
+	if err := sink.client.Get(ctx, "received:missing", &doc); !IsNotFound(err) {
+		t.Fatalf("expected not found, got %v", err)
+	}
+}
+
+func TestSinkStoreBulk(t *testing.T) {
+	sink, server := newTestSink(t)
+	sink.SetBatchSize(2)
+	ctx := context.Background()
+
+	if _, err := sink.Store(ctx, receivedMail("m1")); err != nil {
+		t.Fatal(err)
+	}
+
+	mails := []*handler.MailReceived{}
+	for i := 0; i < 5; i++ {
+		mails = append(mails, receivedMail(fmt.Sprintf("m%d", i)))
+	}
+	results, err := sink.StoreBulk(ctx, append(mails, receivedMail("")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 6 {
+		t.Fatalf("expected 6 results, got %d", len(results))
+	}
+	if r := results[5]; r.Err == nil || r.ID != "" {
+		t.Fatalf("expected error for notification without messageId, got %+v", r)
+	}
+	for i, r := range results[:5] {
+		if id, _ := DocumentID(mails[i]); r.ID != id || r.Err != nil {
+			t.Fatalf("unexpected result %d: %+v", i, r)
+		}
+		if r.Existed != (i == 1) {
+			t.Fatalf("only m1 should exist, got %d: %+v", i, r)
+		}
+	}
+	if n := server.Documents("mail"); n != 5 {
+		t.Fatalf("expected 5 documents, got %d", n)
+	}
+
+	bulkRequests := 0
+	for _, r := range server.Requests() {
+		if r == "POST /mail/_bulk_docs" {
+			bulkRequests++
+		}
+	}
+	if bulkRequests != 3 {
+		t.Fatalf("expected 3 batches, got %d", bulkRequests)
+	}
+}
+
+func TestErrorRetryable(t *testing.T) {
+	client, err := NewClient("http://localhost:1", "missing", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewClient("ftp://localhost", "mail", nil); err == nil {
+		t.Fatal("expected invalid scheme error")
+	}
+	if (&Error{StatusCode: 409}).Retryable() || !(&Error{StatusCode: 503}).Retryable() {
+		t.Fatal("unexpected retryable classification")
+	}
+	if client.Database() != "missing" {
+		t.Fatalf("unexpected database %s", client.Database())
+	}
+}
+
+func TestSinkMiddleware(t *testing.T) {
+	sink, _ := newTestSink(t)
+	middleware := sink.Middleware()
+
+	clean := &awshandler.Exchange{Output: receivedMail("clean"), Annotations: map[string]interface{}{}}
+	quarantined := &awshandler.Exchange{Output: receivedMail("quarantined"), Annotations: map[string]interface{}{}}
+	quarantined.Output.Mail.RawMime = nil
+	quarantined.Quarantine = &awshandler.Quarantine{Action: awshandler.VerdictQuarantine, Reasons: []string{"dmarc FAIL"}}
+	for _, ex := range []*awshandler.Exchange{clean, quarantined} {
+		if err := middleware(awshandler.StageOutput, ex); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var doc Document
+	if err := sink.client.Get(context.Background(), "received:clean", &doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc.Quarantine != nil || doc.Attachments[RawMimeAttachment] == nil {
+		t.Fatalf("unexpected clean document %+v", doc)
+	}
+	doc = Document{}
+	if err := sink.client.Get(context.Background(), quarantined.Annotations[AnnotationDocumentID].(string), &doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc.Quarantine == nil || doc.Quarantine.Action != "quarantine" || doc.Quarantine.Reasons[0] != "dmarc FAIL" || doc.Attachments != nil {
+		t.Fatalf("unexpected quarantined document %+v", doc)
+	}
+}
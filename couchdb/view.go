@@ -0,0 +1,219 @@
+package couchdb
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ViewOptions are query parameters of a view request, keys are JSON encoded
+type ViewOptions struct {
+	Key        interface{}
+	StartKey   interface{}
+	EndKey     interface{}
+	Reduce     *bool // reduce if the view has a reduce function, unless false
+	Group      bool  // group by the full key
+	GroupLevel int   // group by first GroupLevel elements of array keys
+	Descending bool
+	Limit      int
+}
+
+func (o *ViewOptions) query() (url.Values, error) {
+	query := url.Values{}
+	if o == nil {
+		return query, nil
+	}
+	for name, key := range map[string]interface{}{"key": o.Key, "start_key": o.StartKey, "end_key": o.EndKey} {
+		if key == nil {
+			continue
+		}
+		data, err := json.Marshal(key)
+		if err != nil {
+			return nil, err
+		}
+		query.Set(name, string(data))
+	}
+	if o.Reduce != nil {
+		query.Set("reduce", strconv.FormatBool(*o.Reduce))
+	}
+	if o.Group {
+		query.Set("group", "true")
+	}
+	if o.GroupLevel > 0 {
+		query.Set("group_level", strconv.Itoa(o.GroupLevel))
+	}
+	if o.Descending {
+		query.Set("descending", "true")
+	}
+	if o.Limit > 0 {
+		query.Set("limit", strconv.Itoa(o.Limit))
+	}
+	return query, nil
+}
+
+// ViewRow is a single row of view result, Key and Value are kept raw for the caller to decode
+type ViewRow struct {
+	ID    string          `json:"id,omitempty"` // empty for reduced rows
+	Key   json.RawMessage `json:"key"`
+	Value json.RawMessage `json:"value"`
+}
+
+// View queries view of design document ddoc (with or without _design/ prefix)
+func (c *Client) View(ctx context.Context, ddoc string, view string, opts *ViewOptions) ([]*ViewRow, error) {
+	query, err := opts.query()
+	if err != nil {
+		return nil, err
+	}
+	var response struct {
+		Rows []*ViewRow `json:"rows"`
+	}
+	path := docPath("_design/"+strings.TrimPrefix(ddoc, "_design/")) + "/_view/" + url.PathEscape(view)
+	if err := c.do(ctx, http.MethodGet, path, query, nil, &response); err != nil {
+		return nil, err
+	}
+	return response.Rows, nil
+}
+
+// BounceCount is number of bounces of a recipient, domain or day by bounce type
+type BounceCount struct {
+	Key        string // recipient, domain or day (YYYY-MM-DD) depending on the query
+	BounceType string
+	Count      int64
+}
+
+// ComplaintCount is number of complained recipients of a feedback type
+type ComplaintCount struct {
+	FeedbackType string
+	Count        int64
+}
+
+// ProcessingTimeStats are delivery processing time statistics of a day in milliseconds
+type ProcessingTimeStats struct {
+	Day   string
+	Count int64
+	Sum   float64
+	Min   float64
+	Max   float64
+}
+
+// Mean processing time in milliseconds
+func (s *ProcessingTimeStats) Mean() float64 {
+	if s.Count == 0 {
+		return 0
+	}
+	return s.Sum / float64(s.Count)
+}
+
+// BouncesByRecipient counts bounces per recipient and bounce type, recipient "" for all recipients
+func (c *Client) BouncesByRecipient(ctx context.Context, recipient string) ([]*BounceCount, error) {
+	return c.bounceCounts(ctx, ViewBouncesByRecipient, strings.ToLower(recipient), "")
+}
+
+// BouncesByDomain counts bounces per recipient domain and bounce type, domain "" for all domains
+func (c *Client) BouncesByDomain(ctx context.Context, domain string) ([]*BounceCount, error) {
+	return c.bounceCounts(ctx, ViewBouncesByDomain, strings.ToLower(domain), "")
+}
+
+// BouncesByDay counts bounced recipients per day (UTC) and bounce type between from and to inclusive
+func (c *Client) BouncesByDay(ctx context.Context, from time.Time, to time.Time) ([]*BounceCount, error) {
+	return c.bounceCounts(ctx, ViewBouncesByDay, day(from), day(to))
+}
+
+// ComplaintsByFeedbackType counts complained recipients per feedback type ("unknown" if not reported)
+func (c *Client) ComplaintsByFeedbackType(ctx context.Context) ([]*ComplaintCount, error) {
+	rows, err := c.View(ctx, DesignDocumentID, ViewComplaintsByFeedbackType, &ViewOptions{Group: true})
+	if err != nil {
+		return nil, err
+	}
+	counts := make([]*ComplaintCount, 0, len(rows))
+	for _, row := range rows {
+		count := &ComplaintCount{}
+		if err := decodeRow(row, &count.FeedbackType, &count.Count); err != nil {
+			return nil, err
+		}
+		counts = append(counts, count)
+	}
+	return counts, nil
+}
+
+// DeliveriesByProcessingTime returns delivery processing time statistics per day (UTC) between from and to inclusive
+func (c *Client) DeliveriesByProcessingTime(ctx context.Context, from time.Time, to time.Time) ([]*ProcessingTimeStats, error) {
+	opts := &ViewOptions{Group: true}
+	if from := day(from); from != "" {
+		opts.StartKey = from
+	}
+	if to := day(to); to != "" {
+		opts.EndKey = to
+	}
+	rows, err := c.View(ctx, DesignDocumentID, ViewDeliveriesByProcessingTime, opts)
+	if err != nil {
+		return nil, err
+	}
+	stats := make([]*ProcessingTimeStats, 0, len(rows))
+	for _, row := range rows {
+		var value struct {
+			Sum   float64 `json:"sum"`
+			Count int64   `json:"count"`
+			Min   float64 `json:"min"`
+			Max   float64 `json:"max"`
+		}
+		s := &ProcessingTimeStats{}
+		if err := decodeRow(row, &s.Day, &value); err != nil {
+			return nil, err
+		}
+		s.Count, s.Sum, s.Min, s.Max = value.Count, value.Sum, value.Min, value.Max
+		stats = append(stats, s)
+	}
+	return stats, nil
+}
+
+// querying view with [key, bounceType] keys grouped by full key, from and to limit the first key element ("" for no limit)
+func (c *Client) bounceCounts(ctx context.Context, view string, from string, to string) ([]*BounceCount, error) {
+	opts := &ViewOptions{Group: true}
+	if from != "" {
+		opts.StartKey = []interface{}{from}
+		if to == "" {
+			to = from
+		}
+	}
+	if to != "" {
+		opts.EndKey = []interface{}{to, map[string]interface{}{}} // objects collate after strings
+	}
+
+	rows, err := c.View(ctx, DesignDocumentID, view, opts)
+	if err != nil {
+		return nil, err
+	}
+	counts := make([]*BounceCount, 0, len(rows))
+	for _, row := range rows {
+		var key []string
+		count := &BounceCount{}
+		if err := decodeRow(row, &key, &count.Count); err != nil {
+			return nil, err
+		}
+		if len(key) != 2 {
+			return nil, &Error{StatusCode: http.StatusInternalServerError, Code: "invalid_row", Reason: "unexpected key " + string(row.Key)}
+		}
+		count.Key, count.BounceType = key[0], key[1]
+		counts = append(counts, count)
+	}
+	return counts, nil
+}
+
+func decodeRow(row *ViewRow, key interface{}, value interface{}) error {
+	if err := json.Unmarshal(row.Key, key); err != nil {
+		return err
+	}
+	return json.Unmarshal(row.Value, value)
+}
+
+func day(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format("2006-01-02")
+}
@@ -0,0 +1,86 @@
+package awshandler
+
+import (
+	"context"
+
+	"github.com/igorrendulic/couchdb-experiment/email/mime/handler"
+)
+
+// NotificationHandler handles a single mapped SES notification
+type NotificationHandler func(mail *handler.MailReceived) error
+
+// Dispatcher handles SES notifications with wrapped handler.SmtpHandler and routes results to handlers registered per notification type.
+// Notification types without a registered handler are handled and ignored.
+//
+//	d := awshandler.NewDispatcher(awshandler.NewAwsSmtpHandler(svc))
+//	d.OnBounce(func(mail *handler.MailReceived) error { ... })
+//	_, err := d.HandleSmtp(body)
+type Dispatcher struct {
+	handler  handler.SmtpHandler
+	handlers map[NotificationType]NotificationHandler
+}
+
+var _ handler.SmtpHandler = (*Dispatcher)(nil)
+
+func NewDispatcher(h handler.SmtpHandler) *Dispatcher {
+	return &Dispatcher{
+		handler:  h,
+		handlers: map[NotificationType]NotificationHandler{},
+	}
+}
+
+// On registers handler for notification type, replacing previously registered one
+func (d *Dispatcher) On(notificationType NotificationType, fn NotificationHandler) {
+	d.handlers[notificationType] = fn
+}
+
+func (d *Dispatcher) OnReceived(fn NotificationHandler) {
+	d.On(NotificationTypeReceived, fn)
+}
+
+func (d *Dispatcher) OnBounce(fn NotificationHandler) {
+	d.On(NotificationTypeBounce, fn)
+}
+
+func (d *Dispatcher) OnComplaint(fn NotificationHandler) {
+	d.On(NotificationTypeComplaint, fn)
+}
+
+func (d *Dispatcher) OnDelivery(fn NotificationHandler) {
+	d.On(NotificationTypeDelivery, fn)
+}
+
+// OnSubscription is called after SNS subscription has been confirmed
+func (d *Dispatcher) OnSubscription(fn NotificationHandler) {
+	d.On(NotificationTypeSubscriptionConfirmation, fn)
+}
+
+// HandleSmtp handles message with the wrapped handler and dispatches the result
+func (d *Dispatcher) HandleSmtp(message []byte) (*handler.MailReceived, error) {
+	return d.HandleSmtpContext(context.Background(), message)
+}
+
+// HandleSmtpContext is HandleSmtp with the wrapped handler bound to ctx (if it implements ContextSmtpHandler)
+func (d *Dispatcher) HandleSmtpContext(ctx context.Context, message []byte) (*handler.MailReceived, error) {
+	mail, err := handleSmtp(ctx, d.handler, message)
+	if err != nil {
+		return nil, err
+	}
+	if err := d.Dispatch(mail); err != nil {
+		return nil, err
+	}
+	return mail, nil
+}
+
+// Dispatch calls handler registered for notification type of already handled mail (nil mail is ignored)
+func (d *Dispatcher) Dispatch(mail *handler.MailReceived) error {
+	if mail == nil {
+		// skipped by middleware
+		return nil
+	}
+	fn, ok := d.handlers[NotificationType(mail.NotificationType)]
+	if !ok {
+		return nil
+	}
+	return fn(mail)
+}
@@ -0,0 +1,51 @@
+package awshandler
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/igorrendulic/couchdb-experiment/email/mime/handler"
+)
+
+func TestDispatcher(t *testing.T) {
+	bounce, err := LoadPayload("test_data/bounce.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	delivery, err := LoadPayload("test_data/delivery.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	svc, _, _ := dlLoggingSvc([]byte{})
+	dispatcher := NewDispatcher(NewAwsSmtpHandler(svc))
+
+	bounced := []string{}
+	dispatcher.OnBounce(func(mail *handler.MailReceived) error {
+		for _, r := range mail.Bounce.BouncedRecipients {
+			bounced = append(bounced, r.EmailAddress)
+		}
+		return nil
+	})
+	complaintErr := errors.New("complaint handler error")
+	dispatcher.OnComplaint(func(mail *handler.MailReceived) error {
+		return complaintErr
+	})
+
+	if _, err := dispatcher.HandleSmtp(bounce); err != nil {
+		t.Fatal(err)
+	}
+	if len(bounced) != 1 || bounced[0] != "jane@example.com" {
+		t.Fatalf("expected bounce handler to be called, got %v", bounced)
+	}
+
+	// no handler registered for delivery
+	if _, err := dispatcher.HandleSmtp(delivery); err != nil {
+		t.Fatal(err)
+	}
+
+	err = dispatcher.Dispatch(&handler.MailReceived{NotificationType: string(NotificationTypeComplaint)})
+	if !errors.Is(err, complaintErr) {
+		t.Fatalf("expected complaint handler error, got %v", err)
+	}
+}
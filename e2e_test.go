@@ -0,0 +1,194 @@
+package awshandler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/igorrendulic/couchdb-email-aws-parse/awstest"
+	"github.com/igorrendulic/couchdb-experiment/email/mime/handler"
+)
+
+// stable projection of MailReceived for golden files
+type goldenOutput struct {
+	NotificationType string   `json:"notificationType"`
+	Timestamp        int64    `json:"timestamp"`
+	MessageID        string   `json:"messageId,omitempty"`
+	Source           string   `json:"source,omitempty"`
+	Destination      []string `json:"destination,omitempty"`
+	Subject          string   `json:"subject,omitempty"`
+	RawMime          string   `json:"rawMime,omitempty"`
+	ObjectURL        string   `json:"objectUrl,omitempty"`
+	Recipients       []string `json:"recipients,omitempty"`
+	BounceType       string   `json:"bounceType,omitempty"`
+	FeedbackType     string   `json:"feedbackType,omitempty"`
+	SmtpResponse     string   `json:"smtpResponse,omitempty"`
+}
+
+func newGoldenOutput(mail *handler.MailReceived) *goldenOutput {
+	out := &goldenOutput{NotificationType: mail.NotificationType, Timestamp: mail.Timestamp}
+	if mail.Mail != nil {
+		out.MessageID = mail.Mail.MessageID
+		out.Source = mail.Mail.Source
+		out.Destination = mail.Mail.Destination
+		out.RawMime = string(mail.Mail.RawMime)
+		if mail.Mail.CommonHeaders != nil {
+			out.Subject = mail.Mail.CommonHeaders.Subject
+		}
+	}
+	if mail.Receipt != nil {
+		out.ObjectURL = mail.Receipt.Action.ObjectURL
+		out.Recipients = mail.Receipt.Recipients
+	}
+	if mail.Bounce != nil {
+		out.BounceType = mail.Bounce.BounceType
+		for _, r := range mail.Bounce.BouncedRecipients {
+			out.Recipients = append(out.Recipients, r.EmailAddress)
+		}
+	}
+	if mail.Complaint != nil {
+		out.FeedbackType = mail.Complaint.ComplaintFeedbackType
+		for _, r := range mail.Complaint.ComplainedRecipients {
+			out.Recipients = append(out.Recipients, r.EmailAddress)
+		}
+	}
+	if mail.Delivery != nil {
+		out.SmtpResponse = mail.Delivery.SmtpResponse
+	}
+	return out
+}
+
+func newEndToEndHandler(t *testing.T) (*AwsSmtpHandler, *awstest.SNS, *awstest.S3) {
+	snsServer, err := awstest.NewSNS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(snsServer.Close)
+
+	s3Server := awstest.NewS3()
+	s3Server.Put("dev-mailiomailplainreceived", "4lemd3cvrcl5fefchgm609pk8iihuj0hhca8fe81", []byte("From: example@example.com\r\nSubject: howdi\r\n\r\nhello\r\n"))
+
+	h, err := NewAwsSmtpHandlerWithOptions(
+		WithS3(s3Server),
+		WithHTTPClient(snsServer.Client()),
+		WithSignatureVerification(true, true),
+		WithClock(fixedClock(time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC))),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return h, snsServer, s3Server
+}
+
+func TestEndToEndGolden(t *testing.T) {
+	h, snsServer, _ := newEndToEndHandler(t)
+	metrics := NewPrometheusMetrics("ses")
+	h.SetMetrics(metrics)
+
+	for _, name := range []string{"received", "bounce", "complaint", "delivery"} {
+		message, err := LoadPayload("test_data/" + name + ".json")
+		if err != nil {
+			t.Fatal(err)
+		}
+		notification, err := snsServer.Notification("arn:aws:sns:us-east-1:123456789012:ses", string(message))
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := json.Marshal(notification)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		mail, err := h.HandleSmtp(body)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		awstest.GoldenJSON(t, "test_data/golden/"+name+".json", newGoldenOutput(mail))
+	}
+
+	// signing certificate is fetched once and cached
+	rec := httptest.NewRecorder()
+	metrics.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if line := "ses_certificate_fetch_duration_seconds_count 1\n"; !strings.Contains(rec.Body.String(), line) {
+		t.Fatalf("expected %q in metrics:\n%s", line, rec.Body.String())
+	}
+}
+
+func TestEndToEndSubscription(t *testing.T) {
+	h, snsServer, _ := newEndToEndHandler(t)
+
+	confirmation, err := snsServer.SubscriptionConfirmation("arn:aws:sns:us-east-1:123456789012:ses")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := json.Marshal(confirmation)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mail, err := h.HandleSmtp(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mail.NotificationType != string(NotificationTypeSubscriptionConfirmation) {
+		t.Fatalf("unexpected notification type: %s", mail.NotificationType)
+	}
+	if confirmed := snsServer.Confirmed(); len(confirmed) != 1 {
+		t.Fatalf("expected subscription to be confirmed, got %v", confirmed)
+	}
+}
+
+func TestEndToEndTamperedNotification(t *testing.T) {
+	h, snsServer, s3Server := newEndToEndHandler(t)
+
+	received, err := LoadPayload("test_data/received.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	notification, err := snsServer.Notification("arn:aws:sns:us-east-1:123456789012:ses", string(received))
+	if err != nil {
+		t.Fatal(err)
+	}
+	notification.TopicArn = "arn:aws:sns:us-east-1:123456789012:other"
+	body, err := json.Marshal(notification)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := h.HandleSmtp(body); !errors.Is(err, SignatureInvalid) {
+		t.Fatalf("expected signature error, got %v", err)
+	}
+	if gets := s3Server.Gets(); len(gets) != 0 {
+		t.Fatalf("expected no S3 download for unverified notification, got %v", gets)
+	}
+}
+
+func TestEndToEndForgedNotification(t *testing.T) {
+	_, _, s3Server := newEndToEndHandler(t)
+	h := newAwsSmtpHandler(s3Server)
+	stages := []Stage{}
+	h.Use(func(stage Stage, ex *Exchange) error {
+		stages = append(stages, stage)
+		return nil
+	})
+
+	received, err := LoadPayload("test_data/received.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// envelope without signature is never unwrapped nor seen by envelope middleware
+	if _, err := h.HandleSmtp(wrapInSnsEnvelope(t, received)); !errors.Is(err, SignatureInvalid) {
+		t.Fatalf("expected signature error, got %v", err)
+	}
+	if len(stages) != 1 || stages[0] != StageRaw || len(s3Server.Gets()) != 0 {
+		t.Fatalf("forged envelope was handled: stages %v, downloads %v", stages, s3Server.Gets())
+	}
+
+	var unsupported *UnsupportedTypeError
+	if _, err := h.HandleSmtp([]byte(`{"Type":"UnsubscribeConfirmation","TopicArn":"arn:aws:sns:us-west-2:123456:bounce"}`)); !errors.As(err, &unsupported) {
+		t.Fatalf("expected unsupported type error, got %v", err)
+	}
+}
@@ -0,0 +1,325 @@
+package awshandler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// Retryable is implemented by errors which may not reoccur if the same notification is delivered again.
+// Webhooks can use it to decide whether to respond with an error status so SNS redelivers the notification.
+type Retryable interface {
+	Retryable() bool
+}
+
+// IsRetryable reports whether any error in err's chain is retryable
+func IsRetryable(err error) bool {
+	var r Retryable
+	if errors.As(err, &r) {
+		return r.Retryable()
+	}
+	return false
+}
+
+// ErrorKind returns short stable name of the error class (e.g. for metric labels or logging)
+func ErrorKind(err error) string {
+	var parseErr *ParseError
+	var signatureErr *SignatureError
+	var s3Err *S3Error
+	var typeErr *UnsupportedTypeError
+	var subscriptionErr *SubscriptionError
+	var topicErr *TopicNotAllowedError
+	var sizeErr *SizeLimitError
+	var poisonErr *PoisonMessageError
+	var quarantineErr *QuarantineError
+
+	switch {
+	case err == nil:
+		return ""
+	case errors.As(err, &parseErr):
+		return "parse"
+	case errors.As(err, &signatureErr):
+		return "signature"
+	case errors.As(err, &s3Err):
+		return "s3_" + strings.ReplaceAll(s3Err.Kind.String(), " ", "_")
+	case errors.As(err, &typeErr):
+		return "unsupported_type"
+	case errors.As(err, &subscriptionErr):
+		return "subscription"
+	case errors.As(err, &topicErr):
+		return "topic_not_allowed"
+	case errors.As(err, &sizeErr):
+		return "size_limit"
+	case errors.As(err, &poisonErr):
+		return "poison_message"
+	case errors.As(err, &quarantineErr):
+		return quarantineErr.Action.String()
+	}
+	return "other"
+}
+
+// ParseError is returned when a notification (or part of it) isn't valid JSON or doesn't match the expected structure
+type ParseError struct {
+	Path   string // JSON path of the offending value (e.g. detail.mail.destination), empty if unknown
+	Offset int64  // byte offset of the syntax error within the parsed document
+	Err    error
+}
+
+func (e *ParseError) Error() string {
+	if e.Path != "" {
+		return fmt.Sprintf("failed to parse %s: %v", e.Path, e.Err)
+	}
+	return fmt.Sprintf("failed to parse notification: %v", e.Err)
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+func (e *ParseError) Retryable() bool {
+	return false
+}
+
+// SignatureError is returned when SNS message signature couldn't be verified
+type SignatureError struct {
+	Err error
+}
+
+func (e *SignatureError) Error() string {
+	return fmt.Sprintf("%v: %v", SignatureInvalid, e.Err)
+}
+
+func (e *SignatureError) Unwrap() error {
+	return e.Err
+}
+
+// Is makes errors.Is(err, SignatureInvalid) true for all signature errors
+func (e *SignatureError) Is(target error) bool {
+	return target == SignatureInvalid
+}
+
+// Retryable only if signing certificate couldn't be fetched due to network error
+func (e *SignatureError) Retryable() bool {
+	var netErr net.Error
+	return errors.As(e.Err, &netErr)
+}
+
+// S3ErrorKind classifies S3 errors while downloading MIME content
+type S3ErrorKind int
+
+const (
+	S3ErrorOther S3ErrorKind = iota
+	S3ErrorNotFound
+	S3ErrorAccessDenied
+	S3ErrorThrottled
+)
+
+func (k S3ErrorKind) String() string {
+	switch k {
+	case S3ErrorNotFound:
+		return "not found"
+	case S3ErrorAccessDenied:
+		return "access denied"
+	case S3ErrorThrottled:
+		return "throttled"
+	}
+	return "other"
+}
+
+// S3Error is returned when MIME content of Received notification couldn't be downloaded from S3
+type S3Error struct {
+	Kind   S3ErrorKind
+	Bucket string
+	Key    string
+	Err    error
+}
+
+func (e *S3Error) Error() string {
+	return fmt.Sprintf("s3 download of s3://%s/%s failed (%s): %v", e.Bucket, e.Key, e.Kind, e.Err)
+}
+
+func (e *S3Error) Unwrap() error {
+	return e.Err
+}
+
+// Is makes errors.Is(err, S3FileNotFoundError) true for not found errors
+func (e *S3Error) Is(target error) bool {
+	return target == S3FileNotFoundError && e.Kind == S3ErrorNotFound
+}
+
+// Retryable if S3 throttled the request or failed for unknown reason.
+// SES stores the object before publishing notification so missing object or access won't resolve by itself.
+func (e *S3Error) Retryable() bool {
+	return e.Kind == S3ErrorThrottled || e.Kind == S3ErrorOther
+}
+
+// UnsupportedTypeError is returned for notification or event types that can't be mapped to MailReceived
+type UnsupportedTypeError struct {
+	Type string
+}
+
+func (e *UnsupportedTypeError) Error() string {
+	return fmt.Sprintf("unsupported notification type: %q", e.Type)
+}
+
+func (e *UnsupportedTypeError) Retryable() bool {
+	return false
+}
+
+// SubscriptionError is returned when SNS subscription couldn't be confirmed by visiting SubscribeURL
+type SubscriptionError struct {
+	Err error
+}
+
+func (e *SubscriptionError) Error() string {
+	return fmt.Sprintf("sns subscription confirmation failed: %v", e.Err)
+}
+
+func (e *SubscriptionError) Unwrap() error {
+	return e.Err
+}
+
+func (e *SubscriptionError) Retryable() bool {
+	return true
+}
+
+// TopicNotAllowedError is returned for SNS envelopes from topics not in the allow-list (see WithTopics)
+type TopicNotAllowedError struct {
+	TopicArn string
+}
+
+func (e *TopicNotAllowedError) Error() string {
+	return fmt.Sprintf("sns topic not allowed: %q", e.TopicArn)
+}
+
+func (e *TopicNotAllowedError) Retryable() bool {
+	return false
+}
+
+// SizeLimitError is returned when a message or its MIME content exceeds configured limit (see WithMaxMessageSize and WithMaxMimeSize)
+type SizeLimitError struct {
+	What  string // "message" or "mime"
+	Limit int64
+}
+
+func (e *SizeLimitError) Error() string {
+	return fmt.Sprintf("%s exceeds size limit of %d bytes", e.What, e.Limit)
+}
+
+func (e *SizeLimitError) Retryable() bool {
+	return false
+}
+
+// PoisonMessageError is reported by SqsConsumer for messages received more than MaxReceives times.
+// They are moved to the dead-letter queue if configured, deleted otherwise.
+type PoisonMessageError struct {
+	MessageID    string
+	ReceiveCount int
+}
+
+func (e *PoisonMessageError) Error() string {
+	return fmt.Sprintf("sqs message %s discarded after %d receives", e.MessageID, e.ReceiveCount)
+}
+
+func (e *PoisonMessageError) Retryable() bool {
+	return false
+}
+
+// QuarantineError is returned by HandleSmtp and HandleEventBridge for messages quarantined or rejected by
+// QuarantinePolicy or middleware (see Exchange.Quarantine). The message was handled, batch and queue consumers
+// don't redeliver it. Use Process to get the output and isolated MIME content.
+type QuarantineError struct {
+	Action  VerdictAction
+	Reasons []string
+}
+
+func (e *QuarantineError) Error() string {
+	verdict := "quarantined"
+	if e.Action == VerdictReject {
+		verdict = "rejected"
+	}
+	return fmt.Sprintf("message %s: %s", verdict, strings.Join(e.Reasons, ", "))
+}
+
+func (e *QuarantineError) Retryable() bool {
+	return false
+}
+
+// quarantined reports whether err is a QuarantineError, i.e. the message was handled
+func quarantined(err error) bool {
+	var quarantineErr *QuarantineError
+	return errors.As(err, &quarantineErr)
+}
+
+// unmarshalJSON wraps json errors in ParseError, path is the location of data within the notification
+func unmarshalJSON(data []byte, v interface{}, path string) error {
+	err := json.Unmarshal(data, v)
+	if err == nil {
+		return nil
+	}
+
+	parseErr := &ParseError{Path: path, Err: err}
+	var typeErr *json.UnmarshalTypeError
+	var syntaxErr *json.SyntaxError
+	if errors.As(err, &typeErr) {
+		parseErr.Offset = typeErr.Offset
+		if typeErr.Field != "" {
+			if path != "" {
+				parseErr.Path = path + "." + typeErr.Field
+			} else {
+				parseErr.Path = typeErr.Field
+			}
+		}
+	} else if errors.As(err, &syntaxErr) {
+		parseErr.Offset = syntaxErr.Offset
+	}
+	return parseErr
+}
+
+func newS3Error(bucket string, key string, err error) *S3Error {
+	s3Err := &S3Error{
+		Kind:   S3ErrorOther,
+		Bucket: bucket,
+		Key:    key,
+		Err:    err,
+	}
+
+	if request.IsErrorThrottle(err) {
+		s3Err.Kind = S3ErrorThrottled
+		return s3Err
+	}
+
+	var reqErr awserr.RequestFailure
+	if errors.As(err, &reqErr) {
+		switch reqErr.StatusCode() {
+		case http.StatusTooManyRequests, http.StatusServiceUnavailable:
+			// S3 throttles with 503 SlowDown
+			s3Err.Kind = S3ErrorThrottled
+			return s3Err
+		case http.StatusNotFound:
+			s3Err.Kind = S3ErrorNotFound
+			return s3Err
+		case http.StatusForbidden:
+			s3Err.Kind = S3ErrorAccessDenied
+			return s3Err
+		}
+	}
+
+	var awsErr awserr.Error
+	if errors.As(err, &awsErr) {
+		switch awsErr.Code() {
+		case s3.ErrCodeNoSuchKey, s3.ErrCodeNoSuchBucket, "NotFound":
+			s3Err.Kind = S3ErrorNotFound
+		case "AccessDenied", "Forbidden":
+			s3Err.Kind = S3ErrorAccessDenied
+		}
+	}
+	return s3Err
+}
@@ -0,0 +1,107 @@
+package awshandler
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/awstesting/unit"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// mocking aws s3 client which always responds with given status code
+func statusSvc(statusCode int, code string) *s3.S3 {
+	svc := s3.New(unit.Session)
+	svc.Handlers.Send.Clear()
+	svc.Handlers.Send.PushBack(func(r *request.Request) {
+		body := "<Error><Code>" + code + "</Code><Message>mocked</Message></Error>"
+		r.HTTPResponse = &http.Response{
+			StatusCode: statusCode,
+			Body:       ioutil.NopCloser(bytes.NewReader([]byte(body))),
+			Header:     http.Header{},
+		}
+	})
+	svc.Handlers.Retry.Clear()
+	return svc
+}
+
+func TestParseErrorPath(t *testing.T) {
+	svc, _, _ := dlLoggingSvc([]byte{})
+	smtpHandler := NewAwsSmtpHandler(svc)
+
+	_, err := smtpHandler.HandleSmtp([]byte(`{"notificationType":"Bounce","mail":{"destination":"jane@example.com"}}`))
+	var parseErr *ParseError
+	if !errors.As(err, &parseErr) {
+		t.Fatalf("expected ParseError, got %v", err)
+	}
+	if parseErr.Path != "mail.destination" {
+		t.Fatalf("expected path mail.destination, got %q", parseErr.Path)
+	}
+	if IsRetryable(err) {
+		t.Fatalf("parse errors shouldn't be retryable")
+	}
+
+	_, err = smtpHandler.HandleSmtp([]byte(`{"detail-type":"Email Bounced","detail":{"mail":[]}}`))
+	if !errors.As(err, &parseErr) || parseErr.Path != "detail.mail" {
+		t.Fatalf("expected ParseError at detail.mail, got %v", err)
+	}
+}
+
+func TestUnsupportedTypeError(t *testing.T) {
+	svc, _, _ := dlLoggingSvc([]byte{})
+	_, err := NewAwsSmtpHandler(svc).HandleSmtp([]byte(`{"notificationType":"Open"}`))
+
+	var typeErr *UnsupportedTypeError
+	if !errors.As(err, &typeErr) || typeErr.Type != "Open" {
+		t.Fatalf("expected UnsupportedTypeError, got %v", err)
+	}
+}
+
+func TestS3ErrorKinds(t *testing.T) {
+	payload, err := LoadPayload("test_data/received.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		status    int
+		code      string
+		kind      S3ErrorKind
+		retryable bool
+	}{
+		{http.StatusNotFound, "NoSuchKey", S3ErrorNotFound, false},
+		{http.StatusForbidden, "AccessDenied", S3ErrorAccessDenied, false},
+		{http.StatusServiceUnavailable, "SlowDown", S3ErrorThrottled, true},
+		{http.StatusInternalServerError, "InternalError", S3ErrorOther, true},
+	}
+	for _, test := range tests {
+		_, err := NewAwsSmtpHandler(statusSvc(test.status, test.code)).HandleSmtp(payload)
+
+		var s3Err *S3Error
+		if !errors.As(err, &s3Err) {
+			t.Fatalf("expected S3Error for %d, got %v", test.status, err)
+		}
+		if s3Err.Kind != test.kind {
+			t.Fatalf("expected %s for %d, got %s", test.kind, test.status, s3Err.Kind)
+		}
+		if IsRetryable(err) != test.retryable {
+			t.Fatalf("expected retryable %v for %d", test.retryable, test.status)
+		}
+		if errors.Is(err, S3FileNotFoundError) != (test.kind == S3ErrorNotFound) {
+			t.Fatalf("expected errors.Is(S3FileNotFoundError) only for not found errors")
+		}
+	}
+}
+
+func TestSignatureErrorIs(t *testing.T) {
+	err := &SignatureError{Err: errors.New("crypto/rsa: verification error")}
+	if !errors.Is(err, SignatureInvalid) {
+		t.Fatalf("expected SignatureError to match SignatureInvalid")
+	}
+	if IsRetryable(err) {
+		t.Fatalf("invalid signature shouldn't be retryable")
+	}
+}
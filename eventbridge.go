@@ -0,0 +1,79 @@
+package awshandler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+
+	"github.com/igorrendulic/couchdb-experiment/email/mime/handler"
+)
+
+// EventBridgeEvent is an Amazon EventBridge envelope of SES event (SES event publishing)
+type EventBridgeEvent struct {
+	Version    string          `json:"version"`
+	ID         string          `json:"id"`
+	DetailType string          `json:"detail-type"` // e.g. Email Bounced
+	Source     string          `json:"source"`      // aws.ses
+	Account    string          `json:"account"`
+	Time       string          `json:"time"`
+	Region     string          `json:"region"`
+	Resources  []string        `json:"resources"`
+	Detail     json.RawMessage `json:"detail"` // SES event, same shape as SNS notification with eventType instead of notificationType
+}
+
+// EventBridge detail types mapped to SES notification types
+var eventBridgeDetailTypes = map[string]NotificationType{
+	"Email Bounced":            NotificationTypeBounce,
+	"Email Complaint Received": NotificationTypeComplaint,
+	"Email Delivered":          NotificationTypeDelivery,
+}
+
+// HandleEventBridge handles SES event published to Amazon EventBridge
+func (p *AwsSmtpHandler) HandleEventBridge(message []byte) (*handler.MailReceived, error) {
+	return p.HandleEventBridgeContext(context.Background(), message)
+}
+
+// HandleEventBridgeContext is HandleEventBridge with S3 download bound to ctx. Quarantined and rejected messages return QuarantineError.
+func (p *AwsSmtpHandler) HandleEventBridgeContext(ctx context.Context, message []byte) (*handler.MailReceived, error) {
+	ctx, span := p.tracer.Start(ctx, "ses.HandleEventBridge")
+	ex := newExchange(ctx, message)
+
+	err := p.checkMessageSize(ex)
+	if err == nil {
+		err = p.intercept(StageRaw, ex)
+	}
+	if err == nil {
+		ex.Message, err = decodeEventBridge(message)
+	}
+	if err == nil {
+		err = p.processMessage(ctx, ex)
+	}
+	if err := p.finish(span, ex, err); err != nil {
+		return nil, err
+	}
+	return output(ex)
+}
+
+// decoding SES event from EventBridge envelope to MessageJSON
+func decodeEventBridge(message []byte) (*MessageJSON, error) {
+	var event EventBridgeEvent
+	if err := unmarshalJSON(message, &event, ""); err != nil {
+		return nil, err
+	}
+
+	notificationType, ok := eventBridgeDetailTypes[event.DetailType]
+	if !ok {
+		return nil, &UnsupportedTypeError{Type: event.DetailType}
+	}
+
+	var messageJson MessageJSON
+	if err := unmarshalJSON(event.Detail, &messageJson, "detail"); err != nil {
+		return nil, err
+	}
+	if messageJson.Mail == nil {
+		return nil, &ParseError{Path: "detail.mail", Err: errors.New("missing mail")}
+	}
+	messageJson.NotificationType = string(notificationType)
+
+	return &messageJson, nil
+}
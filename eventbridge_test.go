@@ -0,0 +1,48 @@
+package awshandler
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestAwsHandlerEventBridge(t *testing.T) {
+	svc, _, _ := dlLoggingSvc([]byte{})
+	smtpHandler := NewAwsSmtpHandler(svc)
+
+	for _, name := range []string{"bounce", "complaint", "delivery"} {
+		snsPayload, err := LoadPayload("test_data/" + name + ".json")
+		if err != nil {
+			t.Fatal(err)
+		}
+		ebPayload, err := LoadPayload("test_data/eventbridge-" + name + ".json")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		expected, err := smtpHandler.HandleSmtp(snsPayload)
+		if err != nil {
+			t.Fatal(err)
+		}
+		actual, err := smtpHandler.HandleSmtp(ebPayload)
+		if err != nil {
+			t.Fatalf("eventbridge-%s.json expected to be handled without error: %v", name, err)
+		}
+
+		if actual.Timestamp != 1453906778237 {
+			t.Fatalf("eventbridge-%s.json expected timestamp 1453906778237, got %d", name, actual.Timestamp)
+		}
+		if !reflect.DeepEqual(expected, actual) {
+			t.Fatalf("eventbridge-%s.json expected to map the same as %s.json", name, name)
+		}
+	}
+}
+
+func TestAwsHandlerEventBridgeUnsupported(t *testing.T) {
+	svc, _, _ := dlLoggingSvc([]byte{})
+	smtpHandler := newAwsSmtpHandler(svc)
+
+	_, err := smtpHandler.HandleEventBridge([]byte(`{"detail-type":"Email Opened","source":"aws.ses","detail":{"eventType":"Open"}}`))
+	if err == nil {
+		t.Fatalf("expected error for unsupported detail type")
+	}
+}
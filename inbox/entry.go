@@ -0,0 +1,129 @@
+package inbox
+
+import (
+	"errors"
+
+	awshandler "github.com/igorrendulic/couchdb-email-aws-parse"
+	"github.com/igorrendulic/couchdb-experiment/email/mime/handler"
+)
+
+// annotation keys set by Router.Middleware
+const (
+	AnnotationEntries  = "inbox.entries"  // []*Entry
+	AnnotationUnrouted = "inbox.unrouted" // []string recipients without a mailbox
+)
+
+// Entry is a delivery record of a Received message to a single mailbox
+type Entry struct {
+	ID         string   `json:"id"` // deterministic: SES messageId and mailbox
+	MailboxID  string   `json:"mailboxId"`
+	MessageID  string   `json:"messageId"`  // SES messageId
+	Recipients []string `json:"recipients"` // recipients routed to the mailbox (several aliases can share a mailbox)
+	Tags       []string `json:"tags,omitempty"`
+	Timestamp  int64    `json:"timestamp"`
+	Source     string   `json:"source,omitempty"`
+	Subject    string   `json:"subject,omitempty"`
+	ObjectURL  string   `json:"objectUrl,omitempty"` // S3 location of MIME content if stored by SES
+}
+
+// Recipients returns envelope recipients of the message: Receipt.Recipients (recipients matched by the receipt rule)
+// or Mail.Destination if the receipt doesn't list them
+func Recipients(mail *handler.MailReceived) []string {
+	if mail.Receipt != nil && len(mail.Receipt.Recipients) > 0 {
+		return mail.Receipt.Recipients
+	}
+	if mail.Mail != nil {
+		return mail.Mail.Destination
+	}
+	return nil
+}
+
+// Entries routes recipients of a Received notification and returns one entry per mailbox in order of recipients,
+// together with recipients which didn't match any rule. Notifications without SES messageId have no entries,
+// their IDs wouldn't be unique.
+func (r *Router) Entries(mail *handler.MailReceived) ([]*Entry, []string) {
+	entries := []*Entry{}
+	unrouted := []string{}
+	if messageID(mail) == "" {
+		return entries, unrouted
+	}
+	byMailbox := map[string]*Entry{}
+
+	for _, recipient := range Recipients(mail) {
+		route, ok := r.Route(recipient)
+		if !ok {
+			unrouted = append(unrouted, recipient)
+			continue
+		}
+
+		entry, exists := byMailbox[route.MailboxID]
+		if !exists {
+			entry = newEntry(mail, route.MailboxID)
+			byMailbox[route.MailboxID] = entry
+			entries = append(entries, entry)
+		}
+		if !contains(entry.Recipients, route.Address) {
+			entry.Recipients = append(entry.Recipients, route.Address)
+		}
+		if route.Tag != "" && !contains(entry.Tags, route.Tag) {
+			entry.Tags = append(entry.Tags, route.Tag)
+		}
+	}
+	return entries, unrouted
+}
+
+// Middleware annotates Received notifications with inbox entries and unrouted recipients at output stage.
+// With skipUnrouted messages without any routed recipient are skipped (awshandler.ErrSkip).
+// Notifications without SES messageId fail with *awshandler.ParseError.
+func (r *Router) Middleware(skipUnrouted bool) awshandler.Middleware {
+	return func(stage awshandler.Stage, ex *awshandler.Exchange) error {
+		if stage != awshandler.StageOutput || ex.Output == nil || ex.Output.NotificationType != string(awshandler.NotificationTypeReceived) {
+			return nil
+		}
+		if messageID(ex.Output) == "" {
+			return &awshandler.ParseError{Path: "mail.messageId", Err: errors.New("missing message id")}
+		}
+		entries, unrouted := r.Entries(ex.Output)
+		ex.Annotate(AnnotationEntries, entries)
+		ex.Annotate(AnnotationUnrouted, unrouted)
+		if skipUnrouted && len(entries) == 0 {
+			return awshandler.ErrSkip
+		}
+		return nil
+	}
+}
+
+func newEntry(mail *handler.MailReceived, mailboxID string) *Entry {
+	entry := &Entry{
+		MailboxID: mailboxID,
+		Timestamp: mail.Timestamp,
+	}
+	if m := mail.Mail; m != nil {
+		entry.MessageID = m.MessageID
+		entry.Source = m.Source
+		if m.CommonHeaders != nil {
+			entry.Subject = m.CommonHeaders.Subject
+		}
+	}
+	if mail.Receipt != nil && mail.Receipt.Action != nil {
+		entry.ObjectURL = mail.Receipt.Action.ObjectURL
+	}
+	entry.ID = entry.MessageID + ":" + mailboxID
+	return entry
+}
+
+func messageID(mail *handler.MailReceived) string {
+	if mail.Mail == nil {
+		return ""
+	}
+	return mail.Mail.MessageID
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,189 @@
+// Package inbox routes recipients of Received mail to mailboxes and produces per-recipient inbox entries
+package inbox
+
+import (
+	"fmt"
+	"net/mail"
+	"strings"
+)
+
+// DefaultSeparators separate the local part from the plus-address tag (john+news@example.com)
+const DefaultSeparators = "+"
+
+// Rule maps recipients matching Pattern to Mailbox. Patterns in order of precedence:
+//
+//	john@example.com  exact address, also of tagged recipients (john+news@example.com) unless the tagged address has a rule
+//	*@example.com     catch-all of a domain
+//	*@*.example.com   catch-all of all subdomains (not example.com itself)
+//	*                 catch-all of all recipients
+//
+// Mailbox can reference the matched address with {local} (without tag), {domain} and {tag}, e.g. "user:{local}@{domain}".
+type Rule struct {
+	Pattern string `json:"pattern" yaml:"pattern"`
+	Mailbox string `json:"mailbox" yaml:"mailbox"`
+}
+
+// Route is a recipient routed to a mailbox
+type Route struct {
+	Recipient string // recipient as received
+	Address   string // normalized address without tag
+	Tag       string // plus-address tag, empty if none
+	MailboxID string
+	Pattern   string // pattern of the matched rule
+}
+
+// Router maps recipients to mailboxes, safe for concurrent use after creation
+type Router struct {
+	separators string
+	exact      map[string]Rule
+	domains    map[string]Rule
+	subdomains map[string]Rule
+	catchAll   *Rule
+}
+
+// RouterOption configures Router created by NewRouter
+type RouterOption func(r *Router) error
+
+// WithSeparators sets characters separating plus-address tags (e.g. "+-"), empty disables plus-addressing
+func WithSeparators(separators string) RouterOption {
+	return func(r *Router) error {
+		if strings.Contains(separators, "@") {
+			return fmt.Errorf("invalid plus-address separators %q", separators)
+		}
+		r.separators = separators
+		return nil
+	}
+}
+
+// NewRouter creates router of rules, rules with duplicate patterns are an error
+func NewRouter(rules []Rule, opts ...RouterOption) (*Router, error) {
+	r := &Router{
+		separators: DefaultSeparators,
+		exact:      map[string]Rule{},
+		domains:    map[string]Rule{},
+		subdomains: map[string]Rule{},
+	}
+	for _, opt := range opts {
+		if err := opt(r); err != nil {
+			return nil, err
+		}
+	}
+	for _, rule := range rules {
+		if err := r.add(rule); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+func (r *Router) add(rule Rule) error {
+	if rule.Mailbox == "" {
+		return fmt.Errorf("invalid inbox rule %q: mailbox is required", rule.Pattern)
+	}
+	pattern := strings.ToLower(strings.TrimSpace(rule.Pattern))
+	if pattern == "*" {
+		if r.catchAll != nil {
+			return fmt.Errorf("duplicate inbox rule %q", rule.Pattern)
+		}
+		r.catchAll = &rule
+		return nil
+	}
+
+	local, domain, ok := strings.Cut(pattern, "@")
+	if !ok || local == "" || domain == "" || strings.Contains(domain, "@") {
+		return fmt.Errorf("invalid inbox rule pattern %q", rule.Pattern)
+	}
+
+	var target map[string]Rule
+	key := domain
+	switch {
+	case local == "*" && strings.HasPrefix(domain, "*."):
+		target, key = r.subdomains, strings.TrimPrefix(domain, "*.")
+	case local == "*":
+		target = r.domains
+	case strings.Contains(pattern, "*"):
+		return fmt.Errorf("invalid inbox rule pattern %q: wildcards are only supported as whole local part or leading subdomain", rule.Pattern)
+	default:
+		target, key = r.exact, local+"@"+domain
+	}
+	if _, exists := target[key]; exists {
+		return fmt.Errorf("duplicate inbox rule %q", rule.Pattern)
+	}
+	target[key] = rule
+	return nil
+}
+
+// Route maps recipient to mailbox, false if no rule matches or the recipient isn't a valid address
+func (r *Router) Route(recipient string) (*Route, bool) {
+	local, domain, ok := split(recipient)
+	if !ok {
+		return nil, false
+	}
+
+	// exact rule of the tagged address takes precedence over rules matching the address without tag
+	rule, ok := r.exact[local+"@"+domain]
+	tag := ""
+	if !ok {
+		local, tag = r.untag(local)
+		rule, ok = r.match(local, domain)
+	}
+	if !ok {
+		return nil, false
+	}
+	address := local + "@" + domain
+	mailbox := strings.NewReplacer("{local}", local, "{domain}", domain, "{tag}", tag).Replace(rule.Mailbox)
+	return &Route{
+		Recipient: recipient,
+		Address:   address,
+		Tag:       tag,
+		MailboxID: mailbox,
+		Pattern:   rule.Pattern,
+	}, true
+}
+
+func (r *Router) match(local string, domain string) (Rule, bool) {
+	if rule, ok := r.exact[local+"@"+domain]; ok {
+		return rule, true
+	}
+	if rule, ok := r.domains[domain]; ok {
+		return rule, true
+	}
+	// most specific parent domain first (a.b.example.com matches *@*.b.example.com before *@*.example.com)
+	for parent := domain; ; {
+		dot := strings.Index(parent, ".")
+		if dot < 0 {
+			break
+		}
+		parent = parent[dot+1:]
+		if rule, ok := r.subdomains[parent]; ok {
+			return rule, true
+		}
+	}
+	if r.catchAll != nil {
+		return *r.catchAll, true
+	}
+	return Rule{}, false
+}
+
+// splitting recipient (optionally with display name) into lowercase local part and domain
+func split(recipient string) (string, string, bool) {
+	address := strings.TrimSpace(recipient)
+	if parsed, err := mail.ParseAddress(address); err == nil {
+		address = parsed.Address
+	}
+	at := strings.LastIndex(address, "@")
+	if at <= 0 || at == len(address)-1 {
+		return "", "", false
+	}
+	return strings.ToLower(address[:at]), strings.ToLower(address[at+1:]), true
+}
+
+// splitting local part into local part without tag and tag
+func (r *Router) untag(local string) (string, string) {
+	if r.separators != "" {
+		if i := strings.IndexAny(local, r.separators); i > 0 {
+			return local[:i], local[i+1:]
+		}
+	}
+	return local, ""
+}
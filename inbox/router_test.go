@@ -0,0 +1,162 @@
+package inbox
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	awshandler "github.com/igorrendulic/couchdb-email-aws-parse"
+	"github.com/igorrendulic/couchdb-experiment/email/mime/handler"
+)
+
+var testRules = []Rule{
+	{Pattern: "john@example.com", Mailbox: "john"},
+	{Pattern: "j.doe@example.com", Mailbox: "john"},
+	{Pattern: "john+billing@example.com", Mailbox: "billing"},
+	{Pattern: "*@example.com", Mailbox: "shared"},
+	{Pattern: "*@*.example.com", Mailbox: "user:{local}@{domain}"},
+	{Pattern: "*@*.eu.example.com", Mailbox: "eu"},
+}
+
+func newTestRouter(t *testing.T, opts ...RouterOption) *Router {
+	router, err := NewRouter(testRules, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return router
+}
+
+func TestRoute(t *testing.T) {
+	router := newTestRouter(t)
+
+	tests := []struct {
+		recipient string
+		mailbox   string
+		tag       string
+	}{
+		{"john@example.com", "john", ""},
+		{"John+News@Example.com", "john", "news"},
+		{"John+Billing@Example.com", "billing", ""},
+		{"John Doe <j.doe@example.com>", "john", ""},
+		{"anyone@example.com", "shared", ""},
+		{"jane@support.example.com", "user:jane@support.example.com", ""},
+		{"jane+x@a.eu.example.com", "eu", "x"},
+		{"nobody@example.org", "", ""},
+		{"invalid", "", ""},
+	}
+	for _, test := range tests {
+		route, ok := router.Route(test.recipient)
+		if test.mailbox == "" {
+			if ok {
+				t.Errorf("%s: expected no route, got %+v", test.recipient, route)
+			}
+			continue
+		}
+		if !ok || route.MailboxID != test.mailbox || route.Tag != test.tag {
+			t.Errorf("%s: unexpected route %+v", test.recipient, route)
+		}
+	}
+
+	router = newTestRouter(t, WithSeparators(""))
+	if route, _ := router.Route("john+news@example.com"); route.MailboxID != "shared" {
+		t.Fatalf("plus-addressing should be disabled, got %+v", route)
+	}
+	router = newTestRouter(t, WithSeparators("+-"))
+	if route, _ := router.Route("john-news@example.com"); route.MailboxID != "john" || route.Tag != "news" {
+		t.Fatalf("expected - to separate tag, got %+v", route)
+	}
+	if _, err := NewRouter(testRules, WithSeparators("@")); err == nil {
+		t.Fatal("expected invalid separators error")
+	}
+}
+
+func TestNewRouterInvalidRules(t *testing.T) {
+	for _, rules := range [][]Rule{
+		{{Pattern: "john@example.com"}},
+		{{Pattern: "example.com", Mailbox: "m"}},
+		{{Pattern: "jo*@example.com", Mailbox: "m"}},
+		{{Pattern: "*", Mailbox: "a"}, {Pattern: "*", Mailbox: "b"}},
+		{{Pattern: "*@example.com", Mailbox: "a"}, {Pattern: "*@Example.com", Mailbox: "b"}},
+	} {
+		if _, err := NewRouter(rules); err == nil {
+			t.Errorf("expected error for %+v", rules)
+		}
+	}
+}
+
+func TestEntries(t *testing.T) {
+	router := newTestRouter(t)
+	mail := &handler.MailReceived{
+		NotificationType: "Received",
+		Timestamp:        1677000000000,
+		Mail: &handler.Mail{
+			MessageID:     "abc",
+			Source:        "sender@example.net",
+			Destination:   []string{"ignored@example.com"},
+			CommonHeaders: &handler.CommonHeaders{Subject: "hello"},
+		},
+		Receipt: &handler.Receipt{
+			Recipients: []string{"john+a@example.com", "j.doe@example.com", "jane@support.example.com", "other@example.org"},
+			Action:     &handler.Action{Type: "S3", ObjectURL: "s3://bucket/abc"},
+		},
+	}
+
+	entries, unrouted := router.Entries(mail)
+	if !reflect.DeepEqual(unrouted, []string{"other@example.org"}) {
+		t.Fatalf("unexpected unrouted %v", unrouted)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 entries, got %d", len(entries))
+	}
+	john := entries[0]
+	if john.ID != "abc:john" || !reflect.DeepEqual(john.Recipients, []string{"john@example.com", "j.doe@example.com"}) ||
+		!reflect.DeepEqual(john.Tags, []string{"a"}) || john.Subject != "hello" || john.ObjectURL != "s3://bucket/abc" {
+		t.Fatalf("unexpected entry %+v", john)
+	}
+	if entries[1].MailboxID != "user:jane@support.example.com" {
+		t.Fatalf("unexpected entry %+v", entries[1])
+	}
+
+	mail.Receipt.Recipients = nil
+	entries, _ = router.Entries(mail)
+	if len(entries) != 1 || entries[0].MailboxID != "shared" {
+		t.Fatalf("expected destination fallback, got %+v", entries)
+	}
+
+	mail.Mail.MessageID = ""
+	if entries, _ = router.Entries(mail); len(entries) != 0 {
+		t.Fatalf("expected no entries without message id, got %+v", entries)
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	router := newTestRouter(t)
+	ex := &awshandler.Exchange{
+		Output: &handler.MailReceived{
+			NotificationType: "Received",
+			Mail:             &handler.Mail{MessageID: "abc", Destination: []string{"nobody@example.org"}},
+		},
+		Annotations: map[string]interface{}{},
+	}
+
+	if err := router.Middleware(false)(awshandler.StageOutput, ex); err != nil {
+		t.Fatal(err)
+	}
+	if entries := ex.Annotations[AnnotationEntries].([]*Entry); len(entries) != 0 {
+		t.Fatalf("unexpected entries %+v", entries)
+	}
+	if err := router.Middleware(true)(awshandler.StageOutput, ex); !errors.Is(err, awshandler.ErrSkip) {
+		t.Fatalf("expected skip, got %v", err)
+	}
+
+	ex.Output.Mail.MessageID = ""
+	var parseErr *awshandler.ParseError
+	if err := router.Middleware(false)(awshandler.StageOutput, ex); !errors.As(err, &parseErr) {
+		t.Fatalf("expected parse error without message id, got %v", err)
+	}
+
+	ex.Output.NotificationType = "Bounce"
+	if err := router.Middleware(true)(awshandler.StageOutput, ex); err != nil {
+		t.Fatalf("bounces shouldn't be routed: %v", err)
+	}
+}
@@ -0,0 +1,105 @@
+package awshandler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/igorrendulic/couchdb-email-aws-parse/sns"
+	"github.com/igorrendulic/couchdb-experiment/email/mime/handler"
+)
+
+// SNS timestamp format (always with milliseconds), must be preserved for signature verification
+const snsTimestampFormat = "2006-01-02T15:04:05.000Z"
+
+// LambdaHandler adapts handler.SmtpHandler to AWS Lambda SNS and SQS event sources.
+// SNS envelopes are passed to the handler which checks their topic (WithTopics) and signature before unwrapping them.
+//
+//	lambda.Start(awshandler.NewLambdaHandler(smtpHandler).HandleSQSEvent)
+type LambdaHandler struct {
+	handler     handler.SmtpHandler
+	concurrency int
+}
+
+func NewLambdaHandler(h handler.SmtpHandler) *LambdaHandler {
+	return &LambdaHandler{
+		handler:     h,
+		concurrency: DefaultBatchConcurrency,
+	}
+}
+
+// HandleSNSEvent handles SES notifications delivered to Lambda directly by SNS.
+// SNS doesn't support partial batch failures, so an error is returned if any of the records failed.
+// Quarantined messages aren't failures, they are left out of the output.
+func (l *LambdaHandler) HandleSNSEvent(ctx context.Context, event events.SNSEvent) ([]*handler.MailReceived, error) {
+	messages := make([]*BatchMessage, len(event.Records))
+	for i, record := range event.Records {
+		body, err := json.Marshal(SnsPayloadFromLambda(record.SNS))
+		if err != nil {
+			return nil, err
+		}
+		messages[i] = &BatchMessage{ID: record.SNS.MessageID, Body: body}
+	}
+
+	results := HandleBatchContext(ctx, l.handler, messages, l.concurrency)
+
+	output := []*handler.MailReceived{}
+	var firstErr error
+	failed := 0
+	for _, r := range results {
+		if quarantined(r.Err) {
+			continue
+		}
+		if r.Err != nil {
+			if firstErr == nil {
+				firstErr = r.Err
+			}
+			failed++
+			continue
+		}
+		if r.Mail != nil {
+			output = append(output, r.Mail)
+		}
+	}
+	if firstErr != nil {
+		return output, fmt.Errorf("%d of %d sns records failed: %w", failed, len(results), firstErr)
+	}
+	return output, nil
+}
+
+// HandleSQSEvent handles SES notifications (raw or SNS-wrapped) delivered to Lambda by SQS.
+// Failed messages are reported as batch item failures (requires ReportBatchItemFailures on the event source mapping).
+func (l *LambdaHandler) HandleSQSEvent(ctx context.Context, event events.SQSEvent) (events.SQSEventResponse, error) {
+	messages := make([]*BatchMessage, len(event.Records))
+	for i, record := range event.Records {
+		messages[i] = &BatchMessage{ID: record.MessageId, Body: []byte(record.Body)}
+	}
+
+	results := HandleBatchContext(ctx, l.handler, messages, l.concurrency)
+
+	response := events.SQSEventResponse{
+		BatchItemFailures: []events.SQSBatchItemFailure{},
+	}
+	for _, id := range BatchItemFailures(results) {
+		response.BatchItemFailures = append(response.BatchItemFailures, events.SQSBatchItemFailure{ItemIdentifier: id})
+	}
+	return response, nil
+}
+
+// SnsPayloadFromLambda reconstructs sns.Payload from Lambda SNS record (Lambda uses SigningCertUrl and UnsubscribeUrl casing
+// and parses Timestamp which has to be formatted back for signature verification)
+func SnsPayloadFromLambda(entity events.SNSEntity) *sns.Payload {
+	return &sns.Payload{
+		Message:          entity.Message,
+		MessageId:        entity.MessageID,
+		Signature:        entity.Signature,
+		SignatureVersion: entity.SignatureVersion,
+		SigningCertURL:   entity.SigningCertURL,
+		Subject:          entity.Subject,
+		Timestamp:        entity.Timestamp.UTC().Format(snsTimestampFormat),
+		TopicArn:         entity.TopicArn,
+		Type:             entity.Type,
+		UnsubscribeURL:   entity.UnsubscribeURL,
+	}
+}
@@ -0,0 +1,119 @@
+package awshandler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+func TestSnsPayloadFromLambda(t *testing.T) {
+	payload, err := LoadPayload("test_data/lambda-sns-event.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var event events.SNSEvent
+	if err := json.Unmarshal(payload, &event); err != nil {
+		t.Fatal(err)
+	}
+
+	snsPayload := SnsPayloadFromLambda(event.Records[0].SNS)
+	if snsPayload.SigningCertURL != "https://sns.us-west-2.amazonaws.com/SimpleNotificationService-56e67fcb41f6fec09b0196692625d385.pem" {
+		t.Fatalf("expected SigningCertUrl to be mapped, got %q", snsPayload.SigningCertURL)
+	}
+	if snsPayload.UnsubscribeURL == "" {
+		t.Fatalf("expected UnsubscribeUrl to be mapped")
+	}
+	// timestamp has to be byte for byte the same as the signed one
+	if snsPayload.Timestamp != "2016-01-27T14:59:38.230Z" {
+		t.Fatalf("expected original timestamp, got %q", snsPayload.Timestamp)
+	}
+}
+
+func TestLambdaHandleSNSEvent(t *testing.T) {
+	payload, err := LoadPayload("test_data/lambda-sns-event.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var event events.SNSEvent
+	if err := json.Unmarshal(payload, &event); err != nil {
+		t.Fatal(err)
+	}
+
+	svc, _, _ := dlLoggingSvc([]byte{})
+	smtpHandler, err := NewAwsSmtpHandlerWithOptions(WithS3(svc), WithSignatureVerification(true, false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lambdaHandler := NewLambdaHandler(smtpHandler)
+
+	mails, err := lambdaHandler.HandleSNSEvent(context.Background(), event)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mails) != 1 || mails[0].NotificationType != "Bounce" {
+		t.Fatalf("expected single bounce, got %v", mails)
+	}
+}
+
+func TestLambdaTopicAllowList(t *testing.T) {
+	payload, err := LoadPayload("test_data/lambda-sns-event.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var event events.SNSEvent
+	if err := json.Unmarshal(payload, &event); err != nil {
+		t.Fatal(err)
+	}
+	bounce, err := LoadPayload("test_data/bounce.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	svc, _, _ := dlLoggingSvc([]byte{})
+	smtpHandler, err := NewAwsSmtpHandlerWithOptions(WithS3(svc), WithTopics("arn:aws:sns:us-west-2:123456:other"), WithSignatureVerification(true, false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lambdaHandler := NewLambdaHandler(smtpHandler)
+
+	var topicErr *TopicNotAllowedError
+	if _, err := lambdaHandler.HandleSNSEvent(context.Background(), event); !errors.As(err, &topicErr) {
+		t.Fatalf("expected topic not allowed error, got %v", err)
+	}
+	response, err := lambdaHandler.HandleSQSEvent(context.Background(), events.SQSEvent{
+		Records: []events.SQSMessage{{MessageId: "1", Body: string(wrapInSnsEnvelope(t, bounce))}},
+	})
+	if err != nil || len(response.BatchItemFailures) != 1 {
+		t.Fatalf("expected envelope from other topic to fail, got %v %v", response.BatchItemFailures, err)
+	}
+}
+
+func TestLambdaHandleSQSEvent(t *testing.T) {
+	bounce, err := LoadPayload("test_data/bounce.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	svc, _, _ := dlLoggingSvc([]byte{})
+	smtpHandler, err := NewAwsSmtpHandlerWithOptions(WithS3(svc), WithSignatureVerification(true, false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lambdaHandler := NewLambdaHandler(smtpHandler)
+
+	response, err := lambdaHandler.HandleSQSEvent(context.Background(), events.SQSEvent{
+		Records: []events.SQSMessage{
+			{MessageId: "1", Body: string(wrapInSnsEnvelope(t, bounce))},
+			{MessageId: "2", Body: "{}"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(response.BatchItemFailures) != 1 || response.BatchItemFailures[0].ItemIdentifier != "2" {
+		t.Fatalf("expected message 2 to be reported as failed, got %v", response.BatchItemFailures)
+	}
+}
@@ -0,0 +1,250 @@
+package awshandler
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/mail"
+	"strings"
+	"unicode/utf8"
+)
+
+// Logger receives structured log events, args are alternating keys and values.
+type Logger interface {
+	Debug(msg string, args ...interface{})
+	Info(msg string, args ...interface{})
+	Warn(msg string, args ...interface{})
+	Error(msg string, args ...interface{})
+}
+
+type nopLogger struct{}
+
+func (nopLogger) Debug(msg string, args ...interface{}) {}
+func (nopLogger) Info(msg string, args ...interface{})  {}
+func (nopLogger) Warn(msg string, args ...interface{})  {}
+func (nopLogger) Error(msg string, args ...interface{}) {}
+
+// AddressRedaction is how email addresses are written to logs
+type AddressRedaction int
+
+const (
+	RedactAddressMask AddressRedaction = iota // first character of local part and domain (j***@example.com)
+	RedactAddressHash                         // keyed hash of the local part and domain (e.g. to correlate log lines of the same recipient), requires HashKey
+	RedactAddressNone                         // logged as is
+)
+
+// RedactionPolicy controls which personal data from notifications is written to logs
+type RedactionPolicy struct {
+	Addresses AddressRedaction
+	HashKey   []byte   // HMAC key for RedactAddressHash, addresses are masked if empty
+	Subjects  bool     // log subjects as is
+	Headers   []string // names of headers logged at message stage, addresses in From, To, Cc, Reply-To, Sender and Return-Path are redacted
+}
+
+// DefaultRedactionPolicy masks addresses and doesn't log subjects or headers
+var DefaultRedactionPolicy = RedactionPolicy{Addresses: RedactAddressMask}
+
+const redacted = "[redacted]"
+
+// headers holding addresses, redacted the same way as other addresses
+var addressHeaders = map[string]bool{
+	"from":        true,
+	"to":          true,
+	"cc":          true,
+	"bcc":         true,
+	"reply-to":    true,
+	"sender":      true,
+	"return-path": true,
+}
+
+// SetLogger enables logging of handling stages with DefaultRedactionPolicy (unless set by SetRedactionPolicy)
+func (p *AwsSmtpHandler) SetLogger(logger Logger) {
+	if logger == nil {
+		logger = nopLogger{}
+	}
+	p.logger = logger
+}
+
+// SetRedactionPolicy sets redaction of personal data in logs, see RedactionPolicy.Validate
+func (p *AwsSmtpHandler) SetRedactionPolicy(policy RedactionPolicy) {
+	p.redaction = policy
+}
+
+// Validate fails for RedactAddressHash without HashKey, unkeyed hashes of addresses can be reversed with a dictionary
+func (r RedactionPolicy) Validate() error {
+	if r.Addresses == RedactAddressHash && len(r.HashKey) == 0 {
+		return errors.New("address hashing requires a HashKey")
+	}
+	return nil
+}
+
+// Address redacts a single email address (optionally with display name, which is always dropped)
+func (r RedactionPolicy) Address(address string) string {
+	if addr, err := mail.ParseAddress(address); err == nil {
+		address = addr.Address
+	}
+
+	switch r.Addresses {
+	case RedactAddressNone:
+		return address
+	case RedactAddressHash:
+		if len(r.HashKey) == 0 {
+			break
+		}
+		local, domain := splitAddress(address)
+		mac := hmac.New(sha256.New, r.HashKey)
+		mac.Write([]byte(strings.ToLower(local)))
+		return hex.EncodeToString(mac.Sum(nil)[:8]) + "@" + domain
+	}
+
+	local, domain := splitAddress(address)
+	if local == "" {
+		return "***@" + domain
+	}
+	_, size := utf8.DecodeRuneInString(local)
+	return local[:size] + "***@" + domain
+}
+
+// AddressList redacts all addresses
+func (r RedactionPolicy) AddressList(addresses []string) []string {
+	out := make([]string, len(addresses))
+	for i, a := range addresses {
+		out[i] = r.Address(a)
+	}
+	return out
+}
+
+// Subject redacts subject unless subjects are allowed by the policy
+func (r RedactionPolicy) Subject(subject string) string {
+	if r.Subjects || subject == "" {
+		return subject
+	}
+	return redacted
+}
+
+// Header returns value of the header to log, false if header isn't allowed by the policy
+func (r RedactionPolicy) Header(name string, value string) (string, bool) {
+	allowed := false
+	for _, h := range r.Headers {
+		if strings.EqualFold(h, name) {
+			allowed = true
+			break
+		}
+	}
+	if !allowed {
+		return "", false
+	}
+
+	lower := strings.ToLower(name)
+	if lower == "subject" {
+		return r.Subject(value), true
+	}
+	if addressHeaders[lower] {
+		addresses, err := mail.ParseAddressList(value)
+		if err != nil {
+			return redacted, true
+		}
+		out := make([]string, len(addresses))
+		for i, a := range addresses {
+			out[i] = r.Address(a.Address)
+		}
+		return strings.Join(out, ", "), true
+	}
+	return value, true
+}
+
+func splitAddress(address string) (string, string) {
+	at := strings.LastIndex(address, "@")
+	if at < 0 {
+		return address, ""
+	}
+	return address[:at], strings.ToLower(address[at+1:])
+}
+
+// logging a stage of handling, before middleware is called
+func (p *AwsSmtpHandler) logStage(stage Stage, ex *Exchange) {
+	args := []interface{}{"stage", stage.String()}
+
+	switch stage {
+	case StageRaw:
+		args = append(args, "bytes", len(ex.Raw))
+	case StageEnvelope:
+		args = append(args, "sns_type", ex.Envelope.Type, "topic_arn", ex.Envelope.TopicArn, "sns_message_id", ex.Envelope.MessageId)
+	case StageMessage:
+		args = append(args, p.messageLogArgs(ex.Message)...)
+		if headers := p.headerLogValues(ex.Message); len(headers) > 0 {
+			args = append(args, "headers", headers)
+		}
+	case StageOutput:
+		args = append(args, "notification_type", ex.Output.NotificationType)
+	}
+
+	p.logger.Debug("ses message stage", args...)
+}
+
+// logging outcome of handling a message
+func (p *AwsSmtpHandler) logResult(ex *Exchange, err error) {
+	args := []interface{}{}
+	if ex.Envelope != nil {
+		args = append(args, "topic_arn", ex.Envelope.TopicArn, "sns_message_id", ex.Envelope.MessageId)
+	}
+	if ex.Message != nil {
+		args = append(args, p.messageLogArgs(ex.Message)...)
+	}
+
+	if err != nil {
+		args = append(args, "error", err.Error(), "error_kind", ErrorKind(err), "retryable", IsRetryable(err))
+		if IsRetryable(err) {
+			p.logger.Warn("ses message failed", args...)
+		} else {
+			p.logger.Error("ses message failed", args...)
+		}
+		return
+	}
+	if ex.Quarantine != nil {
+		args = append(args, "quarantine", ex.Quarantine.Action.String(), "quarantine_reason", ex.Quarantine.Reason())
+	}
+	if ex.AutoReply != nil {
+		args = append(args, "auto_reply", string(ex.AutoReply.Kind))
+	}
+	if ex.Output == nil {
+		p.logger.Debug("ses message skipped", args...)
+		return
+	}
+	if ex.Output.NotificationType == string(NotificationTypeSubscriptionConfirmation) {
+		p.logger.Info("sns subscription confirmed", args...)
+		return
+	}
+	p.logger.Info("ses message handled", args...)
+}
+
+func (p *AwsSmtpHandler) messageLogArgs(messageJson *MessageJSON) []interface{} {
+	args := []interface{}{"notification_type", messageJson.NotificationType}
+	m := messageJson.Mail
+	if m == nil {
+		return args
+	}
+	args = append(args,
+		"message_id", m.MessageID,
+		"source", p.redaction.Address(m.Source),
+		"destination", p.redaction.AddressList(m.Destination),
+	)
+	if m.CommonHeaders != nil && m.CommonHeaders.Subject != "" {
+		args = append(args, "subject", p.redaction.Subject(m.CommonHeaders.Subject))
+	}
+	return args
+}
+
+func (p *AwsSmtpHandler) headerLogValues(messageJson *MessageJSON) map[string][]string {
+	if len(p.redaction.Headers) == 0 || messageJson.Mail == nil {
+		return nil
+	}
+	headers := map[string][]string{}
+	for _, h := range messageJson.Mail.Headers {
+		if value, ok := p.redaction.Header(h.Name, h.Value); ok {
+			headers[h.Name] = append(headers[h.Name], value)
+		}
+	}
+	return headers
+}
@@ -0,0 +1,114 @@
+package awshandler
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+)
+
+type recordingLogger struct {
+	m     sync.Mutex
+	lines []string
+}
+
+func (l *recordingLogger) log(level string, msg string, args ...interface{}) {
+	l.m.Lock()
+	defer l.m.Unlock()
+	l.lines = append(l.lines, fmt.Sprintf("%s %s %v", level, msg, args))
+}
+
+func (l *recordingLogger) Debug(msg string, args ...interface{}) { l.log("DEBUG", msg, args...) }
+func (l *recordingLogger) Info(msg string, args ...interface{})  { l.log("INFO", msg, args...) }
+func (l *recordingLogger) Warn(msg string, args ...interface{})  { l.log("WARN", msg, args...) }
+func (l *recordingLogger) Error(msg string, args ...interface{}) { l.log("ERROR", msg, args...) }
+
+func TestLoggingRedactsAddresses(t *testing.T) {
+	received, err := LoadPayload("test_data/received.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	logger := &recordingLogger{}
+	svc, _, _ := dlLoggingSvc([]byte{})
+	smtpHandler := newAwsSmtpHandler(svc)
+	smtpHandler.SetLogger(logger)
+	smtpHandler.SetRedactionPolicy(RedactionPolicy{Headers: []string{"From", "Subject", "MIME-Version"}})
+
+	if _, err := smtpHandler.HandleSmtp(received); err != nil {
+		t.Fatal(err)
+	}
+
+	output := strings.Join(logger.lines, "\n")
+	for _, leaked := range []string{"example@example.com", "example@mail.io", "Igor Rendulic", "howdi"} {
+		if strings.Contains(output, leaked) {
+			t.Fatalf("%q leaked to logs:\n%s", leaked, output)
+		}
+	}
+	for _, expected := range []string{"e***@example.com", "e***@mail.io", "MIME-Version:[1.0]", "INFO ses message handled", "s3 mime content downloaded"} {
+		if !strings.Contains(output, expected) {
+			t.Fatalf("expected %q in logs:\n%s", expected, output)
+		}
+	}
+}
+
+func TestLoggingFailure(t *testing.T) {
+	logger := &recordingLogger{}
+	svc, _, _ := dlLoggingSvc([]byte{})
+	smtpHandler := newAwsSmtpHandler(svc)
+	smtpHandler.SetLogger(logger)
+
+	if _, err := smtpHandler.HandleSmtp([]byte(`{"notificationType":"Unknown"}`)); err == nil {
+		t.Fatal("expected error")
+	}
+	last := logger.lines[len(logger.lines)-1]
+	if !strings.HasPrefix(last, "ERROR ses message failed") || !strings.Contains(last, "unsupported_type") {
+		t.Fatalf("unexpected log line: %s", last)
+	}
+}
+
+func TestRedactionPolicy(t *testing.T) {
+	mask := RedactionPolicy{}
+	if a := mask.Address("John Doe <John.Doe@Example.com>"); a != "J***@example.com" {
+		t.Fatalf("unexpected masked address: %s", a)
+	}
+
+	if a := mask.Address("élodie@example.com"); a != "é***@example.com" {
+		t.Fatalf("expected first rune to be kept, got %q", a)
+	}
+
+	hash := RedactionPolicy{Addresses: RedactAddressHash, HashKey: []byte("secret")}
+	a1 := hash.Address("john@example.com")
+	if a1 != hash.Address("John <JOHN@example.com>") || !strings.HasSuffix(a1, "@example.com") || strings.Contains(a1, "john") {
+		t.Fatalf("unexpected hashed address: %s", a1)
+	}
+	other := RedactionPolicy{Addresses: RedactAddressHash, HashKey: []byte("other")}
+	if other.Address("john@example.com") == a1 {
+		t.Fatalf("expected hash to depend on the key")
+	}
+	unkeyed := RedactionPolicy{Addresses: RedactAddressHash}
+	if unkeyed.Validate() == nil || hash.Validate() != nil {
+		t.Fatalf("expected hashing without key to be invalid")
+	}
+	if a := unkeyed.Address("john@example.com"); a != "j***@example.com" {
+		t.Fatalf("expected unkeyed hashing to mask, got %s", a)
+	}
+
+	if s := mask.Subject("password reset"); s != redacted {
+		t.Fatalf("expected redacted subject, got %s", s)
+	}
+	if s := (RedactionPolicy{Subjects: true}).Subject("password reset"); s != "password reset" {
+		t.Fatalf("expected subject, got %s", s)
+	}
+
+	headers := RedactionPolicy{Headers: []string{"to", "x-mailer"}}
+	if v, ok := headers.Header("To", "a@example.com, Bob <bob@example.org>"); !ok || v != "a***@example.com, b***@example.org" {
+		t.Fatalf("unexpected To header: %s", v)
+	}
+	if v, ok := headers.Header("X-Mailer", "Thunderbird"); !ok || v != "Thunderbird" {
+		t.Fatalf("unexpected X-Mailer header: %s", v)
+	}
+	if _, ok := headers.Header("Received", "from example.com"); ok {
+		t.Fatalf("expected header not in allow list to be dropped")
+	}
+}
@@ -0,0 +1,198 @@
+package mailauth
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// maximum number of ARC sets (RFC 8617 section 4.2.1)
+const maxARCInstances = 50
+
+const (
+	headerARCSeal                  = "ARC-Seal"
+	headerARCMessageSignature      = "ARC-Message-Signature"
+	headerARCAuthenticationResults = "ARC-Authentication-Results"
+)
+
+// ARCResult is the validation result of the ARC chain (pass, fail or none without ARC header fields)
+type ARCResult struct {
+	Status    Status
+	Instances int      // number of ARC sets
+	Domains   []string // sealing domains by instance (index 0 is i=1)
+	Reason    string
+}
+
+// a single ARC set (instance)
+type arcSet struct {
+	results   *headerField
+	signature *headerField
+	seal      *headerField
+}
+
+// validating the chain of ARC sets of the message
+func (v *Verifier) validateARC(ctx context.Context, m *message) *ARCResult {
+	result := &ARCResult{Status: StatusFail}
+	fail := func(format string, args ...interface{}) *ARCResult {
+		result.Reason = fmt.Sprintf(format, args...)
+		return result
+	}
+
+	sets := map[int]*arcSet{}
+	for _, h := range m.headers {
+		var slot **headerField
+		set := func(i int) *arcSet {
+			if sets[i] == nil {
+				sets[i] = &arcSet{}
+			}
+			return sets[i]
+		}
+		i, err := arcInstance(h)
+		switch {
+		case strings.EqualFold(h.Name, headerARCAuthenticationResults):
+			if err != nil {
+				return fail("invalid %s: %v", h.Name, err)
+			}
+			slot = &set(i).results
+		case strings.EqualFold(h.Name, headerARCMessageSignature):
+			if err != nil {
+				return fail("invalid %s: %v", h.Name, err)
+			}
+			slot = &set(i).signature
+		case strings.EqualFold(h.Name, headerARCSeal):
+			if err != nil {
+				return fail("invalid %s: %v", h.Name, err)
+			}
+			slot = &set(i).seal
+		default:
+			continue
+		}
+		if *slot != nil {
+			return fail("duplicate %s for i=%d", h.Name, i)
+		}
+		*slot = h
+	}
+
+	if len(sets) == 0 {
+		result.Status = StatusNone
+		return result
+	}
+	result.Instances = len(sets)
+	if len(sets) > maxARCInstances {
+		return fail("too many arc sets")
+	}
+	for i := 1; i <= len(sets); i++ {
+		set, ok := sets[i]
+		if !ok {
+			return fail("missing arc set i=%d", i)
+		}
+		if set.results == nil || set.signature == nil || set.seal == nil {
+			return fail("incomplete arc set i=%d", i)
+		}
+		tags, err := parseTags(set.seal.Value)
+		if err != nil {
+			return fail("invalid arc seal i=%d: %v", i, err)
+		}
+		result.Domains = append(result.Domains, strings.ToLower(tags["d"]))
+		expected := "pass"
+		if i == 1 {
+			expected = "none"
+		}
+		if cv := strings.ToLower(tags["cv"]); cv != expected {
+			return fail("arc seal i=%d has cv=%s", i, cv)
+		}
+	}
+
+	// only the most recent message signature is validated, older ones are expected to break with modifications
+	latest := sets[len(sets)]
+	if signature := v.verifyDKIMField(ctx, m, latest.signature, true); signature.Status != StatusPass {
+		if signature.Status == StatusTempError {
+			result.Status = StatusTempError
+		}
+		return fail("arc message signature i=%d: %s", len(sets), signature.Reason)
+	}
+
+	for i := len(sets); i >= 1; i-- {
+		if status, reason := v.verifySeal(ctx, sets, i); status != StatusPass {
+			if status == StatusTempError {
+				result.Status = StatusTempError
+			}
+			return fail("arc seal i=%d: %s", i, reason)
+		}
+	}
+
+	result.Status = StatusPass
+	return result
+}
+
+// verifying ARC-Seal of instance i over all ARC sets up to i (RFC 8617 section 5.1.1)
+func (v *Verifier) verifySeal(ctx context.Context, sets map[int]*arcSet, i int) (Status, string) {
+	seal := sets[i].seal
+	tags, err := parseTags(seal.Value)
+	if err != nil {
+		return StatusPermError, err.Error()
+	}
+	if _, ok := tags["h"]; ok {
+		return StatusPermError, "h= tag not allowed in arc seal"
+	}
+	for _, tag := range []string{"a", "b", "d", "s"} {
+		if tags[tag] == "" {
+			return StatusPermError, "missing " + tag + "= tag"
+		}
+	}
+	newHash, cryptoHash, keyType, ok := signatureAlgorithm(strings.ToLower(tags["a"]))
+	if !ok {
+		return StatusPermError, "unsupported algorithm " + tags["a"]
+	}
+	signature, err := base64.StdEncoding.DecodeString(stripWhitespace(tags["b"]))
+	if err != nil {
+		return StatusPermError, "invalid b= tag"
+	}
+
+	h := newHash()
+	for j := 1; j <= i; j++ {
+		h.Write([]byte(canonicalHeader(CanonicalizationRelaxed, sets[j].results)))
+		h.Write([]byte(canonicalHeader(CanonicalizationRelaxed, sets[j].signature)))
+		if j < i {
+			h.Write([]byte(canonicalHeader(CanonicalizationRelaxed, sets[j].seal)))
+		}
+	}
+	h.Write([]byte(signatureHeader(CanonicalizationRelaxed, seal)))
+
+	key, status, reason := v.lookupKey(ctx, tags["s"], strings.ToLower(tags["d"]), keyType)
+	if key == nil {
+		return status, reason
+	}
+	if err := verifySignature(key, cryptoHash, h.Sum(nil), signature); err != nil {
+		return StatusFail, err.Error()
+	}
+	return StatusPass, ""
+}
+
+// instance number from i= tag, ARC-Authentication-Results start with it and aren't a tag list
+func arcInstance(field *headerField) (int, error) {
+	var number string
+	if strings.EqualFold(field.Name, headerARCAuthenticationResults) {
+		first, _, _ := strings.Cut(field.Value, ";")
+		name, value, found := strings.Cut(strings.TrimSpace(first), "=")
+		if !found || strings.TrimSpace(name) != "i" {
+			return 0, fmt.Errorf("missing i= tag")
+		}
+		number = value
+	} else if strings.EqualFold(field.Name, headerARCSeal) || strings.EqualFold(field.Name, headerARCMessageSignature) {
+		tags, err := parseTags(field.Value)
+		if err != nil {
+			return 0, err
+		}
+		number = tags["i"]
+	} else {
+		return 0, nil
+	}
+	i, err := strconv.Atoi(strings.TrimSpace(number))
+	if err != nil || i < 1 || i > maxARCInstances {
+		return 0, fmt.Errorf("invalid instance %q", number)
+	}
+	return i, nil
+}
@@ -0,0 +1,129 @@
+package mailauth
+
+import (
+	"bytes"
+	"strings"
+)
+
+// Canonicalization algorithm of headers or body (RFC 6376 section 3.4)
+type Canonicalization string
+
+const (
+	CanonicalizationSimple  Canonicalization = "simple"
+	CanonicalizationRelaxed Canonicalization = "relaxed"
+)
+
+// parsing c= tag (header/body, body defaults to simple)
+func parseCanonicalization(c string) (Canonicalization, Canonicalization, bool) {
+	if c == "" {
+		return CanonicalizationSimple, CanonicalizationSimple, true
+	}
+	header, body, found := strings.Cut(c, "/")
+	if !found {
+		body = string(CanonicalizationSimple)
+	}
+	h, b := Canonicalization(header), Canonicalization(body)
+	valid := func(c Canonicalization) bool { return c == CanonicalizationSimple || c == CanonicalizationRelaxed }
+	return h, b, valid(h) && valid(b)
+}
+
+func canonicalHeader(c Canonicalization, field *headerField) string {
+	if c == CanonicalizationSimple {
+		return field.Raw
+	}
+	return strings.ToLower(strings.TrimSpace(field.Name)) + ":" + relaxedValue(field.Raw[strings.Index(field.Raw, ":")+1:]) + "\r\n"
+}
+
+// header value unfolded with whitespace runs reduced to single space and trimmed
+func relaxedValue(value string) string {
+	return strings.Join(strings.FieldsFunc(unfold(value), func(r rune) bool { return r == ' ' || r == '\t' }), " ")
+}
+
+func canonicalBody(c Canonicalization, body []byte) []byte {
+	lines := bytes.Split(body, []byte("\r\n"))
+	if c == CanonicalizationRelaxed {
+		for i, line := range lines {
+			lines[i] = relaxedBodyLine(line)
+		}
+	}
+	// trailing empty lines are ignored
+	for len(lines) > 0 && len(lines[len(lines)-1]) == 0 {
+		lines = lines[:len(lines)-1]
+	}
+	if len(lines) == 0 {
+		if c == CanonicalizationSimple {
+			return []byte("\r\n")
+		}
+		return []byte{}
+	}
+	return append(bytes.Join(lines, []byte("\r\n")), '\r', '\n')
+}
+
+func relaxedBodyLine(line []byte) []byte {
+	out := make([]byte, 0, len(line))
+	space := false
+	for _, b := range line {
+		if b == ' ' || b == '\t' {
+			space = true
+			continue
+		}
+		if space {
+			out = append(out, ' ')
+			space = false
+		}
+		out = append(out, b)
+	}
+	return out
+}
+
+// selecting signed header fields listed in h= tag: for repeated names fields are used from the bottom up,
+// names without a (remaining) field are signed as empty (RFC 6376 section 5.4.2)
+func selectHeaders(m *message, names []string) []*headerField {
+	used := map[string]int{}
+	selected := []*headerField{}
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		key := strings.ToLower(name)
+		fields := m.fields(name)
+		n := used[key]
+		used[key] = n + 1
+		if n < len(fields) {
+			selected = append(selected, fields[len(fields)-1-n])
+		}
+	}
+	return selected
+}
+
+// signature header field with the value of b= removed, without the trailing CRLF
+func signatureHeader(c Canonicalization, field *headerField) string {
+	stripped := &headerField{Name: field.Name, Raw: removeSignatureValue(field.Raw)}
+	return strings.TrimSuffix(canonicalHeader(c, stripped), "\r\n")
+}
+
+// removing value of b= tag (but not bh=) keeping everything else as is
+func removeSignatureValue(raw string) string {
+	colon := strings.Index(raw, ":")
+	value := raw[colon+1:]
+	start := 0
+	for start < len(value) {
+		end := strings.Index(value[start:], ";")
+		if end < 0 {
+			end = len(value)
+		} else {
+			end += start
+		}
+		tag := value[start:end]
+		if eq := strings.Index(tag, "="); eq >= 0 && strings.TrimSpace(tag[:eq]) == "b" {
+			// keep the tag name and =, drop the value up to ; or end of the header (including final CRLF)
+			valueEnd := end
+			if end == len(value) {
+				// keep trailing CRLF of the header field
+				trimmed := strings.TrimRight(value[start+eq+1:end], "\r\n")
+				valueEnd = start + eq + 1 + len(trimmed)
+			}
+			return raw[:colon+1] + value[:start+eq+1] + value[valueEnd:]
+		}
+		start = end + 1
+	}
+	return raw
+}
@@ -0,0 +1,245 @@
+package mailauth
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Status is the result of a single check (RFC 8601 result values)
+type Status string
+
+const (
+	StatusNone      Status = "none"
+	StatusPass      Status = "pass"
+	StatusFail      Status = "fail"
+	StatusNeutral   Status = "neutral"
+	StatusSoftFail  Status = "softfail"
+	StatusPolicy    Status = "policy"
+	StatusTempError Status = "temperror"
+	StatusPermError Status = "permerror"
+)
+
+// minimum accepted RSA key size
+const minRSAKeyBits = 1024
+
+// DKIMResult is the verification result of a single DKIM-Signature header field
+type DKIMResult struct {
+	Status     Status
+	Domain     string // d=
+	Selector   string // s=
+	Identifier string // i=, @domain if not set
+	Algorithm  string // a=
+	Headers    []string
+	BodyLength int64 // l=, -1 if the whole body is signed
+	Reason     string
+}
+
+// verifying DKIM-Signature (or ARC-Message-Signature when arc is set) field of the message
+func (v *Verifier) verifyDKIMField(ctx context.Context, m *message, field *headerField, arc bool) *DKIMResult {
+	result := &DKIMResult{Status: StatusPermError, BodyLength: -1}
+	fail := func(status Status, format string, args ...interface{}) *DKIMResult {
+		result.Status = status
+		result.Reason = fmt.Sprintf(format, args...)
+		return result
+	}
+
+	tags, err := parseTags(field.Value)
+	if err != nil {
+		return fail(StatusPermError, "%v", err)
+	}
+	result.Domain = strings.ToLower(tags["d"])
+	result.Selector = tags["s"]
+	result.Algorithm = strings.ToLower(tags["a"])
+	result.Identifier = tags["i"]
+	if result.Identifier == "" {
+		result.Identifier = "@" + result.Domain
+	}
+
+	required := []string{"a", "b", "bh", "d", "h", "s"}
+	if !arc {
+		required = append(required, "v")
+		if tags["v"] != "" && tags["v"] != "1" {
+			return fail(StatusPermError, "unsupported version %s", tags["v"])
+		}
+	}
+	for _, tag := range required {
+		if _, ok := tags[tag]; !ok {
+			return fail(StatusPermError, "missing %s= tag", tag)
+		}
+	}
+	if !arc && !strings.HasSuffix(strings.ToLower(result.Identifier), strings.ToLower("@"+result.Domain)) &&
+		!strings.HasSuffix(strings.ToLower(result.Identifier), "."+result.Domain) {
+		return fail(StatusPermError, "identifier %s not within domain %s", result.Identifier, result.Domain)
+	}
+
+	for _, name := range strings.Split(tags["h"], ":") {
+		result.Headers = append(result.Headers, strings.TrimSpace(name))
+	}
+	if !containsFold(result.Headers, "from") && !arc {
+		return fail(StatusPermError, "from header isn't signed")
+	}
+
+	if x := tags["x"]; x != "" {
+		expires, err := strconv.ParseInt(x, 10, 64)
+		if err != nil {
+			return fail(StatusPermError, "invalid x= tag")
+		}
+		if v.now().After(time.Unix(expires, 0)) {
+			return fail(StatusFail, "signature expired")
+		}
+	}
+
+	headerCanon, bodyCanon, ok := parseCanonicalization(tags["c"])
+	if !ok {
+		return fail(StatusPermError, "invalid canonicalization %s", tags["c"])
+	}
+	newHash, cryptoHash, keyType, ok := signatureAlgorithm(result.Algorithm)
+	if !ok {
+		return fail(StatusPermError, "unsupported algorithm %s", result.Algorithm)
+	}
+
+	// body hash
+	body := canonicalBody(bodyCanon, m.body)
+	if l := tags["l"]; l != "" {
+		length, err := strconv.ParseInt(l, 10, 64)
+		if err != nil || length < 0 {
+			return fail(StatusPermError, "invalid l= tag")
+		}
+		if length > int64(len(body)) {
+			return fail(StatusFail, "body shorter than l=%d", length)
+		}
+		result.BodyLength = length
+		body = body[:length]
+	}
+	bodyHash, err := base64.StdEncoding.DecodeString(stripWhitespace(tags["bh"]))
+	if err != nil {
+		return fail(StatusPermError, "invalid bh= tag")
+	}
+	h := newHash()
+	h.Write(body)
+	if !bytes.Equal(h.Sum(nil), bodyHash) {
+		return fail(StatusFail, "body hash mismatch")
+	}
+
+	// header hash
+	signature, err := base64.StdEncoding.DecodeString(stripWhitespace(tags["b"]))
+	if err != nil {
+		return fail(StatusPermError, "invalid b= tag")
+	}
+	h = newHash()
+	for _, selected := range selectHeaders(m, result.Headers) {
+		h.Write([]byte(canonicalHeader(headerCanon, selected)))
+	}
+	h.Write([]byte(signatureHeader(headerCanon, field)))
+
+	key, status, reason := v.lookupKey(ctx, result.Selector, result.Domain, keyType)
+	if key == nil {
+		return fail(status, "%s", reason)
+	}
+	if err := verifySignature(key, cryptoHash, h.Sum(nil), signature); err != nil {
+		return fail(StatusFail, "%v", err)
+	}
+	result.Status = StatusPass
+	return result
+}
+
+func signatureAlgorithm(algorithm string) (func() hash.Hash, crypto.Hash, string, bool) {
+	switch algorithm {
+	case "rsa-sha256":
+		return sha256.New, crypto.SHA256, "rsa", true
+	case "rsa-sha1":
+		return sha1.New, crypto.SHA1, "rsa", true
+	case "ed25519-sha256":
+		return sha256.New, crypto.SHA256, "ed25519", true
+	}
+	return nil, 0, "", false
+}
+
+// looking up public key of selector._domainkey.domain, nil key with status and reason on failure
+func (v *Verifier) lookupKey(ctx context.Context, selector string, domain string, keyType string) (crypto.PublicKey, Status, string) {
+	name := selector + "._domainkey." + domain
+	records, err := v.resolver.LookupTXT(ctx, name)
+	if isNotFound(err) || (err == nil && len(records) == 0) {
+		return nil, StatusPermError, "no key for signature at " + name
+	}
+	if err != nil {
+		return nil, StatusTempError, "key lookup failed: " + err.Error()
+	}
+
+	tags, err := parseTags(strings.Join(records, ""))
+	if err != nil {
+		return nil, StatusPermError, "invalid key record: " + err.Error()
+	}
+	if version, ok := tags["v"]; ok && version != "DKIM1" {
+		return nil, StatusPermError, "invalid key record version " + version
+	}
+	if k := tags["k"]; k != "" && k != keyType || k == "" && keyType != "rsa" {
+		return nil, StatusPermError, "key type doesn't match signature algorithm"
+	}
+	data := stripWhitespace(tags["p"])
+	if data == "" {
+		return nil, StatusPermError, "key revoked"
+	}
+	der, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		return nil, StatusPermError, "invalid key data"
+	}
+
+	if keyType == "ed25519" {
+		if len(der) != ed25519.PublicKeySize {
+			return nil, StatusPermError, "invalid ed25519 key size"
+		}
+		return ed25519.PublicKey(der), StatusPass, ""
+	}
+
+	var key interface{}
+	if key, err = x509.ParsePKIXPublicKey(der); err != nil {
+		if key, err = x509.ParsePKCS1PublicKey(der); err != nil {
+			return nil, StatusPermError, "invalid rsa key"
+		}
+	}
+	rsaKey, ok := key.(*rsa.PublicKey)
+	if !ok {
+		return nil, StatusPermError, "key isn't an rsa key"
+	}
+	if rsaKey.N.BitLen() < minRSAKeyBits {
+		return nil, StatusPermError, "rsa key too short"
+	}
+	return rsaKey, StatusPass, ""
+}
+
+func verifySignature(key crypto.PublicKey, cryptoHash crypto.Hash, hashed []byte, signature []byte) error {
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		return rsa.VerifyPKCS1v15(k, cryptoHash, hashed, signature)
+	case ed25519.PublicKey:
+		// ed25519-sha256 signs the SHA-256 hash of the data (RFC 8463)
+		if !ed25519.Verify(k, hashed, signature) {
+			return errors.New("ed25519 verification error")
+		}
+		return nil
+	}
+	return errors.New("unsupported key")
+}
+
+func containsFold(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
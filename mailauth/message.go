@@ -0,0 +1,135 @@
+// Package mailauth re-verifies DKIM signatures, SPF and ARC chains of downloaded MIME content.
+// DNS lookups go through Resolver so verification can be tested with a fake resolver.
+package mailauth
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+)
+
+// maximum number of header fields parsed from a message
+const maxHeaderFields = 1000
+
+// header field as it appears in the message, Raw includes folding and the terminating CRLF
+type headerField struct {
+	Name  string // as written
+	Value string // unfolded value without leading whitespace
+	Raw   string
+}
+
+// message split into header fields and body with CRLF line endings
+type message struct {
+	headers []*headerField
+	body    []byte
+}
+
+func parseMessage(raw []byte) (*message, error) {
+	raw = normalizeLineEndings(raw)
+
+	headerEnd := bytes.Index(raw, []byte("\r\n\r\n"))
+	var header, body []byte
+	switch {
+	case len(raw) == 0:
+	case bytes.HasPrefix(raw, []byte("\r\n")):
+		body = raw[2:]
+	case headerEnd >= 0:
+		header, body = raw[:headerEnd+2], raw[headerEnd+4:]
+	case bytes.HasSuffix(raw, []byte("\r\n")):
+		header = raw
+	default:
+		header = append(append([]byte{}, raw...), '\r', '\n')
+	}
+
+	m := &message{body: body}
+	for len(header) > 0 {
+		end := 0
+		for {
+			i := bytes.Index(header[end:], []byte("\r\n"))
+			if i < 0 {
+				return nil, errors.New("malformed header")
+			}
+			end += i + 2
+			// folded lines continue with whitespace
+			if end >= len(header) || (header[end] != ' ' && header[end] != '\t') {
+				break
+			}
+		}
+		raw := string(header[:end])
+		header = header[end:]
+
+		colon := strings.Index(raw, ":")
+		if colon <= 0 {
+			return nil, errors.New("malformed header field: " + strings.TrimSpace(raw))
+		}
+		m.headers = append(m.headers, &headerField{
+			Name:  strings.TrimRight(raw[:colon], " \t"),
+			Value: strings.TrimSpace(unfold(raw[colon+1:])),
+			Raw:   raw,
+		})
+		if len(m.headers) > maxHeaderFields {
+			return nil, errors.New("too many header fields")
+		}
+	}
+	return m, nil
+}
+
+// header fields with name (case-insensitive) in order of appearance
+func (m *message) fields(name string) []*headerField {
+	fields := []*headerField{}
+	for _, h := range m.headers {
+		if strings.EqualFold(h.Name, name) {
+			fields = append(fields, h)
+		}
+	}
+	return fields
+}
+
+func normalizeLineEndings(raw []byte) []byte {
+	if !bytes.Contains(raw, []byte("\n")) || (bytes.Count(raw, []byte("\n")) == bytes.Count(raw, []byte("\r\n"))) {
+		return raw
+	}
+	out := make([]byte, 0, len(raw)+bytes.Count(raw, []byte("\n")))
+	for i, b := range raw {
+		if b == '\n' && (i == 0 || raw[i-1] != '\r') {
+			out = append(out, '\r')
+		}
+		out = append(out, b)
+	}
+	return out
+}
+
+func unfold(s string) string {
+	return strings.NewReplacer("\r\n", "", "\n", "").Replace(s)
+}
+
+// tag=value lists of DKIM-Signature, ARC-Seal, ARC-Message-Signature and DKIM key records
+func parseTags(s string) (map[string]string, error) {
+	tags := map[string]string{}
+	for _, part := range strings.Split(s, ";") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		eq := strings.Index(part, "=")
+		if eq <= 0 {
+			return nil, errors.New("malformed tag: " + part)
+		}
+		name := strings.TrimSpace(part[:eq])
+		if _, exists := tags[name]; exists {
+			return nil, errors.New("duplicate tag: " + name)
+		}
+		tags[name] = strings.TrimSpace(part[eq+1:])
+	}
+	return tags, nil
+}
+
+// removing all whitespace (base64 values may be folded)
+func stripWhitespace(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r == ' ' || r == '\t' || r == '\r' || r == '\n' {
+			return -1
+		}
+		return r
+	}, s)
+}
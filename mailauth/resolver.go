@@ -0,0 +1,24 @@
+package mailauth
+
+import (
+	"context"
+	"errors"
+	"net"
+)
+
+// Resolver looks up DNS records used by DKIM, SPF and ARC verification, *net.Resolver satisfies the interface.
+// Missing records are reported as *net.DNSError with IsNotFound set.
+type Resolver interface {
+	LookupTXT(ctx context.Context, name string) ([]string, error)
+	LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error)
+	LookupMX(ctx context.Context, name string) ([]*net.MX, error)
+	LookupAddr(ctx context.Context, addr string) ([]string, error)
+}
+
+var _ Resolver = (*net.Resolver)(nil)
+
+// true if the record doesn't exist (NXDOMAIN or no records of the type)
+func isNotFound(err error) bool {
+	var dnsErr *net.DNSError
+	return errors.As(err, &dnsErr) && dnsErr.IsNotFound
+}
@@ -0,0 +1,538 @@
+package mailauth
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// limits of RFC 7208 section 4.6.4
+const (
+	spfLookupLimit     = 10
+	spfVoidLookupLimit = 2
+)
+
+// SPFResult is the result of SPF evaluation of the sending IP for the MAIL FROM (or HELO) domain
+type SPFResult struct {
+	Status    Status
+	Domain    string // evaluated domain
+	Sender    string // MAIL FROM address, postmaster@HELO if empty
+	IP        net.IP
+	Mechanism string // matched mechanism (e.g. ip4:192.0.2.0/24), empty if none matched
+	Reason    string
+}
+
+// state of a single check_host evaluation shared across include and redirect
+type spfEvaluation struct {
+	v           *Verifier
+	ip          net.IP
+	sender      string
+	helo        string
+	lookups     int
+	voidLookups int
+}
+
+type spfError struct {
+	status Status
+	reason string
+}
+
+func (e *spfError) Error() string {
+	return e.reason
+}
+
+func permError(format string, args ...interface{}) error {
+	return &spfError{status: StatusPermError, reason: fmt.Sprintf(format, args...)}
+}
+
+// CheckSPF evaluates SPF policy of the MAIL FROM domain for ip (HELO domain when mailFrom is empty)
+func (v *Verifier) CheckSPF(ctx context.Context, ip net.IP, helo string, mailFrom string) *SPFResult {
+	sender := strings.Trim(mailFrom, "<> ")
+	if sender == "" {
+		sender = "postmaster@" + helo
+	}
+	at := strings.LastIndex(sender, "@")
+	if at < 0 {
+		sender = "postmaster@" + sender
+		at = len("postmaster")
+	}
+	domain := strings.ToLower(strings.TrimSuffix(sender[at+1:], "."))
+	result := &SPFResult{Domain: domain, Sender: sender, IP: ip}
+	if ip == nil {
+		result.Status, result.Reason = StatusNone, "sender ip unknown"
+		return result
+	}
+	if domain == "" {
+		result.Status, result.Reason = StatusNone, "no sender domain"
+		return result
+	}
+
+	e := &spfEvaluation{v: v, ip: ip, sender: sender, helo: helo}
+	status, mechanism, err := e.checkHost(ctx, domain, 0)
+	result.Status, result.Mechanism = status, mechanism
+	if err != nil {
+		result.Reason = err.Error()
+		if spfErr, ok := err.(*spfError); ok {
+			result.Status = spfErr.status
+		}
+	}
+	return result
+}
+
+func (e *spfEvaluation) checkHost(ctx context.Context, domain string, depth int) (Status, string, error) {
+	if depth > spfLookupLimit {
+		return StatusPermError, "", permError("include loop")
+	}
+	record, err := e.record(ctx, domain)
+	if err != nil || record == "" {
+		if record == "" && err == nil {
+			return StatusNone, "", nil
+		}
+		return "", "", err
+	}
+
+	redirect := ""
+	for _, term := range strings.Fields(record)[1:] {
+		if name, value, ok := modifier(term); ok {
+			switch name {
+			case "redirect":
+				if redirect != "" {
+					return "", "", permError("duplicate redirect modifier")
+				}
+				redirect = value
+			case "exp":
+			default:
+				// unknown modifiers are ignored
+			}
+			continue
+		}
+
+		qualifier, mechanism := qualify(term)
+		matched, err := e.mechanism(ctx, domain, mechanism, depth)
+		if err != nil {
+			return "", "", err
+		}
+		if matched {
+			return qualifier, term, nil
+		}
+	}
+
+	if redirect != "" {
+		if err := e.countLookup(); err != nil {
+			return "", "", err
+		}
+		target, err := e.expand(redirect, domain)
+		if err != nil {
+			return "", "", err
+		}
+		status, mechanism, err := e.checkHost(ctx, target, depth+1)
+		if err == nil && status == StatusNone {
+			return "", "", permError("redirect to %s without spf record", target)
+		}
+		return status, mechanism, err
+	}
+	return StatusNeutral, "", nil
+}
+
+// the single v=spf1 record of domain, empty if there's none
+func (e *spfEvaluation) record(ctx context.Context, domain string) (string, error) {
+	txts, err := e.v.resolver.LookupTXT(ctx, domain)
+	if isNotFound(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", &spfError{status: StatusTempError, reason: "txt lookup of " + domain + " failed: " + err.Error()}
+	}
+	records := []string{}
+	for _, txt := range txts {
+		if strings.EqualFold(txt, "v=spf1") || strings.HasPrefix(strings.ToLower(txt), "v=spf1 ") {
+			records = append(records, txt)
+		}
+	}
+	switch len(records) {
+	case 0:
+		return "", nil
+	case 1:
+		return records[0], nil
+	}
+	return "", permError("multiple spf records of %s", domain)
+}
+
+func modifier(term string) (string, string, bool) {
+	eq := strings.Index(term, "=")
+	if eq <= 0 || strings.ContainsAny(term[:eq], ":/") {
+		return "", "", false
+	}
+	return strings.ToLower(term[:eq]), term[eq+1:], true
+}
+
+func qualify(term string) (Status, string) {
+	switch term[0] {
+	case '+':
+		return StatusPass, term[1:]
+	case '-':
+		return StatusFail, term[1:]
+	case '~':
+		return StatusSoftFail, term[1:]
+	case '?':
+		return StatusNeutral, term[1:]
+	}
+	return StatusPass, term
+}
+
+func (e *spfEvaluation) mechanism(ctx context.Context, domain string, mechanism string, depth int) (bool, error) {
+	name, arg := mechanism, ""
+	if i := strings.IndexAny(mechanism, ":/"); i >= 0 {
+		name, arg = mechanism[:i], mechanism[i:]
+	}
+	name = strings.ToLower(name)
+
+	switch name {
+	case "all":
+		if arg != "" {
+			return false, permError("invalid mechanism %s", mechanism)
+		}
+		return true, nil
+
+	case "ip4", "ip6":
+		if !strings.HasPrefix(arg, ":") {
+			return false, permError("invalid mechanism %s", mechanism)
+		}
+		network := arg[1:]
+		if !strings.Contains(network, "/") {
+			if name == "ip4" {
+				network += "/32"
+			} else {
+				network += "/128"
+			}
+		}
+		_, cidr, err := net.ParseCIDR(network)
+		if err != nil || (name == "ip4") != (cidr.IP.To4() != nil) {
+			return false, permError("invalid mechanism %s", mechanism)
+		}
+		return cidr.Contains(e.ip), nil
+
+	case "include":
+		if err := e.countLookup(); err != nil {
+			return false, err
+		}
+		target, err := e.domainArg(arg, domain, true)
+		if err != nil {
+			return false, err
+		}
+		status, _, err := e.checkHost(ctx, target, depth+1)
+		if err != nil {
+			return false, err
+		}
+		switch status {
+		case StatusPass:
+			return true, nil
+		case StatusNone:
+			return false, permError("include of %s without spf record", target)
+		}
+		return false, nil
+
+	case "a", "mx":
+		if err := e.countLookup(); err != nil {
+			return false, err
+		}
+		domainSpec, cidr4, cidr6, err := splitCIDR(arg)
+		if err != nil {
+			return false, permError("invalid mechanism %s", mechanism)
+		}
+		target, err := e.domainArg(domainSpec, domain, false)
+		if err != nil {
+			return false, err
+		}
+		hosts := []string{target}
+		if name == "mx" {
+			mxs, err := e.v.resolver.LookupMX(ctx, target)
+			if err != nil && !isNotFound(err) {
+				return false, &spfError{status: StatusTempError, reason: "mx lookup of " + target + " failed"}
+			}
+			if len(mxs) == 0 {
+				return false, e.countVoid()
+			}
+			if len(mxs) > spfLookupLimit {
+				return false, permError("too many mx records of %s", target)
+			}
+			hosts = hosts[:0]
+			for _, mx := range mxs {
+				hosts = append(hosts, mx.Host)
+			}
+		}
+		for _, host := range hosts {
+			addrs, err := e.v.resolver.LookupIPAddr(ctx, host)
+			if isNotFound(err) || (err == nil && len(addrs) == 0) {
+				if name == "a" {
+					if err := e.countVoid(); err != nil {
+						return false, err
+					}
+				}
+				continue
+			}
+			if err != nil {
+				return false, &spfError{status: StatusTempError, reason: "address lookup of " + host + " failed"}
+			}
+			for _, addr := range addrs {
+				if matchCIDR(e.ip, addr.IP, cidr4, cidr6) {
+					return true, nil
+				}
+			}
+		}
+		return false, nil
+
+	case "exists":
+		if err := e.countLookup(); err != nil {
+			return false, err
+		}
+		target, err := e.domainArg(arg, domain, true)
+		if err != nil {
+			return false, err
+		}
+		addrs, err := e.v.resolver.LookupIPAddr(ctx, target)
+		if isNotFound(err) || (err == nil && len(addrs) == 0) {
+			return false, e.countVoid()
+		}
+		if err != nil {
+			return false, &spfError{status: StatusTempError, reason: "address lookup of " + target + " failed"}
+		}
+		return true, nil
+
+	case "ptr":
+		// deprecated (RFC 7208 section 5.5) but still published
+		if err := e.countLookup(); err != nil {
+			return false, err
+		}
+		target, err := e.domainArg(arg, domain, false)
+		if err != nil {
+			return false, err
+		}
+		for _, name := range e.validatedNames(ctx) {
+			if strings.EqualFold(name, target) || hasSuffixFold(name, "."+target) {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+	return false, permError("unknown mechanism %s", mechanism)
+}
+
+// names of reverse lookup of ip with forward lookup returning ip (RFC 7208 section 5.5), lookup errors only make
+// the mechanism not match
+func (e *spfEvaluation) validatedNames(ctx context.Context) []string {
+	names, err := e.v.resolver.LookupAddr(ctx, e.ip.String())
+	if err != nil {
+		return nil
+	}
+	if len(names) > spfLookupLimit {
+		names = names[:spfLookupLimit]
+	}
+	validated := []string{}
+	for _, name := range names {
+		name = strings.TrimSuffix(name, ".")
+		addrs, err := e.v.resolver.LookupIPAddr(ctx, name)
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			if addr.IP.Equal(e.ip) {
+				validated = append(validated, name)
+				break
+			}
+		}
+	}
+	return validated
+}
+
+func hasSuffixFold(s string, suffix string) bool {
+	return len(s) >= len(suffix) && strings.EqualFold(s[len(s)-len(suffix):], suffix)
+}
+
+// domain of a mechanism argument (":domain"), the current domain if empty and not required
+func (e *spfEvaluation) domainArg(arg string, current string, required bool) (string, error) {
+	if arg == "" {
+		if required {
+			return "", permError("missing domain")
+		}
+		return current, nil
+	}
+	if !strings.HasPrefix(arg, ":") {
+		return "", permError("invalid domain spec %s", arg)
+	}
+	return e.expand(arg[1:], current)
+}
+
+func (e *spfEvaluation) countLookup() error {
+	e.lookups++
+	if e.lookups > spfLookupLimit {
+		return permError("too many dns lookups")
+	}
+	return nil
+}
+
+func (e *spfEvaluation) countVoid() error {
+	e.voidLookups++
+	if e.voidLookups > spfVoidLookupLimit {
+		return permError("too many void dns lookups")
+	}
+	return nil
+}
+
+// splitting ":domain/24//64" into domain spec (with colon) and prefix lengths (-1 if not set)
+func splitCIDR(arg string) (string, int, int, error) {
+	cidr4, cidr6 := -1, -1
+	if i := strings.Index(arg, "//"); i >= 0 {
+		n, err := strconv.Atoi(arg[i+2:])
+		if err != nil || n < 0 || n > 128 {
+			return "", 0, 0, fmt.Errorf("invalid ip6 cidr")
+		}
+		cidr6, arg = n, arg[:i]
+	}
+	if i := strings.LastIndex(arg, "/"); i >= 0 {
+		n, err := strconv.Atoi(arg[i+1:])
+		if err != nil || n < 0 || n > 32 {
+			return "", 0, 0, fmt.Errorf("invalid ip4 cidr")
+		}
+		cidr4, arg = n, arg[:i]
+	}
+	return arg, cidr4, cidr6, nil
+}
+
+func matchCIDR(ip net.IP, addr net.IP, cidr4 int, cidr6 int) bool {
+	if ip4, addr4 := ip.To4(), addr.To4(); ip4 != nil || addr4 != nil {
+		if ip4 == nil || addr4 == nil {
+			return false
+		}
+		if cidr4 < 0 {
+			cidr4 = 32
+		}
+		mask := net.CIDRMask(cidr4, 32)
+		return ip4.Mask(mask).Equal(addr4.Mask(mask))
+	}
+	if cidr6 < 0 {
+		cidr6 = 128
+	}
+	mask := net.CIDRMask(cidr6, 128)
+	return ip.Mask(mask).Equal(addr.Mask(mask))
+}
+
+// macro expansion of domain specs (RFC 7208 section 7), exp= only letters aren't supported
+func (e *spfEvaluation) expand(spec string, domain string) (string, error) {
+	var out strings.Builder
+	for i := 0; i < len(spec); i++ {
+		c := spec[i]
+		if c != '%' {
+			out.WriteByte(c)
+			continue
+		}
+		if i+1 >= len(spec) {
+			return "", permError("invalid macro in %s", spec)
+		}
+		i++
+		switch spec[i] {
+		case '%':
+			out.WriteByte('%')
+			continue
+		case '_':
+			out.WriteByte(' ')
+			continue
+		case '-':
+			out.WriteString("%20")
+			continue
+		case '{':
+		default:
+			return "", permError("invalid macro in %s", spec)
+		}
+		end := strings.IndexByte(spec[i:], '}')
+		if end < 0 {
+			return "", permError("invalid macro in %s", spec)
+		}
+		value, err := e.macro(spec[i+1:i+end], domain)
+		if err != nil {
+			return "", err
+		}
+		out.WriteString(value)
+		i += end
+	}
+	return strings.TrimSuffix(out.String(), "."), nil
+}
+
+func (e *spfEvaluation) macro(macro string, domain string) (string, error) {
+	if macro == "" {
+		return "", permError("empty macro")
+	}
+	local, senderDomain := "postmaster", domain
+	if at := strings.LastIndex(e.sender, "@"); at >= 0 {
+		local, senderDomain = e.sender[:at], e.sender[at+1:]
+		if local == "" {
+			local = "postmaster"
+		}
+	}
+
+	var value string
+	switch strings.ToLower(macro[:1]) {
+	case "s":
+		value = e.sender
+	case "l":
+		value = local
+	case "o":
+		value = senderDomain
+	case "d":
+		value = domain
+	case "i":
+		if ip4 := e.ip.To4(); ip4 != nil {
+			value = ip4.String()
+		} else {
+			nibbles := []string{}
+			for _, b := range e.ip.To16() {
+				nibbles = append(nibbles, strconv.FormatInt(int64(b>>4), 16), strconv.FormatInt(int64(b&0xf), 16))
+			}
+			value = strings.Join(nibbles, ".")
+		}
+	case "v":
+		value = "in-addr"
+		if e.ip.To4() == nil {
+			value = "ip6"
+		}
+	case "h":
+		value = e.helo
+	case "p":
+		value = "unknown"
+	default:
+		return "", permError("unknown macro letter %s", macro[:1])
+	}
+
+	// transformers: digits (keep rightmost parts), r (reverse) and delimiters
+	rest := macro[1:]
+	digits := 0
+	for len(rest) > 0 && rest[0] >= '0' && rest[0] <= '9' {
+		digits = digits*10 + int(rest[0]-'0')
+		rest = rest[1:]
+	}
+	reverse := false
+	if len(rest) > 0 && (rest[0] == 'r' || rest[0] == 'R') {
+		reverse = true
+		rest = rest[1:]
+	}
+	delimiters := "."
+	if rest != "" {
+		if strings.Trim(rest, ".-+,/_=") != "" {
+			return "", permError("invalid macro delimiters %s", rest)
+		}
+		delimiters = rest
+	}
+
+	parts := strings.FieldsFunc(value, func(r rune) bool { return strings.ContainsRune(delimiters, r) })
+	if reverse {
+		for i, j := 0, len(parts)-1; i < j; i, j = i+1, j-1 {
+			parts[i], parts[j] = parts[j], parts[i]
+		}
+	}
+	if digits > 0 && digits < len(parts) {
+		parts = parts[len(parts)-digits:]
+	}
+	return strings.Join(parts, "."), nil
+}
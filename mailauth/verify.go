@@ -0,0 +1,87 @@
+package mailauth
+
+import (
+	"context"
+	"net"
+	"strings"
+	"time"
+
+	awshandler "github.com/igorrendulic/couchdb-email-aws-parse"
+)
+
+// AnnotationResult is the annotation key of *Result set by Verifier.Middleware
+const AnnotationResult = "mailauth.result"
+
+// Result of re-verification of a message
+type Result struct {
+	SenderIP net.IP // connecting IP from the Received header field added by the receiving server
+	Helo     string
+	MailFrom string
+	DKIM     []*DKIMResult // one per DKIM-Signature in order of appearance
+	SPF      *SPFResult
+	ARC      *ARCResult
+}
+
+// Verifier re-verifies DKIM signatures, SPF and ARC chains of messages
+type Verifier struct {
+	resolver Resolver
+	now      func() time.Time
+}
+
+// NewVerifier creates verifier using resolver for DNS lookups (net.DefaultResolver if nil)
+func NewVerifier(resolver Resolver) *Verifier {
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+	return &Verifier{resolver: resolver, now: time.Now}
+}
+
+// SetClock sets time used to check signature expiration
+func (v *Verifier) SetClock(now func() time.Time) {
+	v.now = now
+}
+
+// Verify verifies raw MIME received from the MAIL FROM sender. Sender IP and HELO name are taken from the topmost
+// Received header field (added by SES when receiving the message).
+func (v *Verifier) Verify(ctx context.Context, raw []byte, mailFrom string) (*Result, error) {
+	m, err := parseMessage(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &Result{MailFrom: mailFrom}
+	if received := m.fields("Received"); len(received) > 0 {
+		result.Helo, result.SenderIP = parseReceivedFrom(received[0].Value)
+	}
+
+	for _, field := range m.fields("DKIM-Signature") {
+		result.DKIM = append(result.DKIM, v.verifyDKIMField(ctx, m, field, false))
+	}
+	result.SPF = v.CheckSPF(ctx, result.SenderIP, result.Helo, mailFrom)
+	result.ARC = v.validateARC(ctx, m)
+	return result, nil
+}
+
+// Middleware verifies downloaded MIME of Received notifications at output stage and annotates the result.
+// Verification problems never fail handling, they are reported as temperror or permerror results.
+func (v *Verifier) Middleware() awshandler.Middleware {
+	return func(stage awshandler.Stage, ex *awshandler.Exchange) error {
+		out := ex.Output
+		if stage != awshandler.StageOutput || out == nil || out.Mail == nil || len(out.Mail.RawMime) == 0 {
+			return nil
+		}
+		if result, err := v.Verify(ex.Context(), out.Mail.RawMime, out.Mail.Source); err == nil {
+			ex.Annotate(AnnotationResult, result)
+		}
+		return nil
+	}
+}
+
+// HELO name and IP of "from" clause of Received header field
+func parseReceivedFrom(value string) (string, net.IP) {
+	hop := awshandler.ParseReceived(value)
+	if hop == nil {
+		return "", nil
+	}
+	return strings.Trim(hop.From, "[]"), hop.FromIP
+}
@@ -0,0 +1,368 @@
+package mailauth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+)
+
+// fakeResolver answers lookups from maps, missing names are not found
+type fakeResolver struct {
+	txt  map[string][]string
+	ip   map[string][]string
+	mx   map[string][]string
+	ptr  map[string][]string // names of reverse lookup by IP
+	fail map[string]bool     // names failing with temporary error
+}
+
+func (r *fakeResolver) lookup(name string) error {
+	if r.fail[name] {
+		return &net.DNSError{Err: "timeout", Name: name, IsTimeout: true}
+	}
+	return &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+}
+
+func (r *fakeResolver) LookupTXT(ctx context.Context, name string) ([]string, error) {
+	if txt, ok := r.txt[strings.ToLower(name)]; ok && !r.fail[name] {
+		return txt, nil
+	}
+	return nil, r.lookup(name)
+}
+
+func (r *fakeResolver) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	ips, ok := r.ip[strings.ToLower(host)]
+	if !ok || r.fail[host] {
+		return nil, r.lookup(host)
+	}
+	addrs := []net.IPAddr{}
+	for _, ip := range ips {
+		addrs = append(addrs, net.IPAddr{IP: net.ParseIP(ip)})
+	}
+	return addrs, nil
+}
+
+func (r *fakeResolver) LookupMX(ctx context.Context, name string) ([]*net.MX, error) {
+	hosts, ok := r.mx[strings.ToLower(name)]
+	if !ok || r.fail[name] {
+		return nil, r.lookup(name)
+	}
+	mxs := []*net.MX{}
+	for _, h := range hosts {
+		mxs = append(mxs, &net.MX{Host: h})
+	}
+	return mxs, nil
+}
+
+func (r *fakeResolver) LookupAddr(ctx context.Context, addr string) ([]string, error) {
+	names, ok := r.ptr[addr]
+	if !ok || r.fail[addr] {
+		return nil, r.lookup(addr)
+	}
+	return names, nil
+}
+
+const testMessage = "Received: from mail.example.org (mail.example.org [192.0.2.10])\r\n" +
+	" by inbound-smtp.us-east-1.amazonaws.com with SMTP id abc\r\n" +
+	" for inbox@example.com; Mon, 13 Mar 2023 20:08:18 +0000\r\n" +
+	"From: Sender <sender@example.org>\r\n" +
+	"To: inbox@example.com\r\n" +
+	"Subject:  Hello   world \r\n" +
+	"\r\n" +
+	"Hi there  \r\n" +
+	"\r\n" +
+	"\r\n"
+
+// signing header fields of raw with relaxed canonicalization, returning the signature header field to prepend
+func sign(t *testing.T, name string, raw string, tags string, headers []string, key crypto.Signer, prefix string) string {
+	m, err := parseMessage([]byte(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	algorithm := "rsa-sha256"
+	if _, ok := key.(ed25519.PrivateKey); ok {
+		algorithm = "ed25519-sha256"
+	}
+
+	value := fmt.Sprintf(" a=%s; %s", algorithm, tags)
+	if name != headerARCSeal {
+		body := sha256.Sum256(canonicalBody(CanonicalizationRelaxed, m.body))
+		value += fmt.Sprintf("; c=relaxed/relaxed; h=%s; bh=%s", strings.Join(headers, ":"), base64.StdEncoding.EncodeToString(body[:]))
+	}
+	value += "; b="
+	field := &headerField{Name: name, Value: strings.TrimSpace(value), Raw: name + ":" + value + "\r\n"}
+
+	h := sha256.New()
+	h.Write([]byte(prefix))
+	for _, selected := range selectHeaders(m, headers) {
+		h.Write([]byte(canonicalHeader(CanonicalizationRelaxed, selected)))
+	}
+	h.Write([]byte(signatureHeader(CanonicalizationRelaxed, field)))
+
+	var signature []byte
+	if edKey, ok := key.(ed25519.PrivateKey); ok {
+		signature = ed25519.Sign(edKey, h.Sum(nil))
+	} else {
+		signature, err = key.Sign(rand.Reader, h.Sum(nil), crypto.SHA256)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return name + ":" + value + base64.StdEncoding.EncodeToString(signature) + "\r\n"
+}
+
+func keyRecord(t *testing.T, key crypto.Signer) string {
+	if edKey, ok := key.(ed25519.PrivateKey); ok {
+		return "v=DKIM1; k=ed25519; p=" + base64.StdEncoding.EncodeToString(edKey.Public().(ed25519.PublicKey))
+	}
+	der, err := x509.MarshalPKIXPublicKey(key.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return "v=DKIM1; k=rsa; p=" + base64.StdEncoding.EncodeToString(der)
+}
+
+func newTestKeys(t *testing.T) (*rsa.PrivateKey, ed25519.PrivateKey) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rsaKey, edKey
+}
+
+func TestVerifyDKIM(t *testing.T) {
+	rsaKey, edKey := newTestKeys(t)
+	resolver := &fakeResolver{txt: map[string][]string{
+		"rsa._domainkey.example.org":     {keyRecord(t, rsaKey)},
+		"ed._domainkey.example.org":      {keyRecord(t, edKey)},
+		"revoked._domainkey.example.org": {"v=DKIM1; p="},
+	}}
+	verifier := NewVerifier(resolver)
+	headers := []string{"From", "To", "Subject"}
+
+	signed := sign(t, "DKIM-Signature", testMessage, "v=1; d=example.org; s=rsa", headers, rsaKey, "") +
+		sign(t, "DKIM-Signature", testMessage, "v=1; d=example.org; s=ed", headers, edKey, "") +
+		testMessage
+	// relaxed canonicalization tolerates whitespace changes and trailing empty lines
+	modified := strings.Replace(signed, "Subject:  Hello   world \r\n", "Subject: Hello world\r\n", 1) + "\r\n"
+	result, err := verifier.Verify(context.Background(), []byte(modified), "bounce@example.org")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.DKIM) != 2 {
+		t.Fatalf("expected 2 signatures, got %d", len(result.DKIM))
+	}
+	for _, r := range result.DKIM {
+		if r.Status != StatusPass || r.Domain != "example.org" {
+			t.Fatalf("unexpected result %+v", r)
+		}
+	}
+	if result.SenderIP.String() != "192.0.2.10" || result.Helo != "mail.example.org" {
+		t.Fatalf("unexpected sender %s %s", result.Helo, result.SenderIP)
+	}
+
+	tampered := strings.Replace(signed, "Hi there", "Hi here", 1)
+	result, _ = verifier.Verify(context.Background(), []byte(tampered), "")
+	if result.DKIM[0].Status != StatusFail || result.DKIM[0].Reason != "body hash mismatch" {
+		t.Fatalf("expected body hash failure, got %+v", result.DKIM[0])
+	}
+
+	tampered = strings.Replace(signed, "To: inbox@", "To: other@", 1)
+	result, _ = verifier.Verify(context.Background(), []byte(tampered), "")
+	if result.DKIM[0].Status != StatusFail || result.DKIM[1].Status != StatusFail {
+		t.Fatalf("expected signature failure, got %+v %+v", result.DKIM[0], result.DKIM[1])
+	}
+
+	for selector, status := range map[string]Status{"revoked": StatusPermError, "missing": StatusPermError} {
+		raw := sign(t, "DKIM-Signature", testMessage, "v=1; d=example.org; s="+selector, headers, rsaKey, "") + testMessage
+		result, _ = verifier.Verify(context.Background(), []byte(raw), "")
+		if result.DKIM[0].Status != status {
+			t.Errorf("%s: expected %s, got %+v", selector, status, result.DKIM[0])
+		}
+	}
+
+	resolver.fail = map[string]bool{"rsa._domainkey.example.org": true}
+	result, _ = verifier.Verify(context.Background(), []byte(signed), "")
+	if result.DKIM[0].Status != StatusTempError {
+		t.Fatalf("expected temperror, got %+v", result.DKIM[0])
+	}
+}
+
+func TestVerifyARC(t *testing.T) {
+	rsaKey, edKey := newTestKeys(t)
+	resolver := &fakeResolver{txt: map[string][]string{
+		"arc._domainkey.forwarder.example": {keyRecord(t, rsaKey)},
+		"arc._domainkey.list.example":      {keyRecord(t, edKey)},
+	}}
+	verifier := NewVerifier(resolver)
+
+	// seal message by a forwarder (i=1) and a mailing list (i=2)
+	seal := func(raw string, i int, domain string, key crypto.Signer, cv string) string {
+		aar := fmt.Sprintf("ARC-Authentication-Results: i=%d; %s; spf=pass smtp.mailfrom=example.org\r\n", i, domain)
+		ams := sign(t, headerARCMessageSignature, aar+raw, fmt.Sprintf("i=%d; d=%s; s=arc", i, domain), []string{"From", "Subject"}, key, "")
+		m, err := parseMessage([]byte(aar + ams + raw))
+		if err != nil {
+			t.Fatal(err)
+		}
+		prefix := ""
+		for j := 1; j < i; j++ {
+			for _, name := range []string{headerARCAuthenticationResults, headerARCMessageSignature, headerARCSeal} {
+				for _, f := range m.fields(name) {
+					if n, _ := arcInstance(f); n == j {
+						prefix += canonicalHeader(CanonicalizationRelaxed, f)
+					}
+				}
+			}
+		}
+		prefix += canonicalHeader(CanonicalizationRelaxed, m.fields(headerARCAuthenticationResults)[0])
+		prefix += canonicalHeader(CanonicalizationRelaxed, m.fields(headerARCMessageSignature)[0])
+		as := sign(t, headerARCSeal, "", fmt.Sprintf("i=%d; cv=%s; d=%s; s=arc", i, cv, domain), nil, key, prefix)
+		return as + ams + aar + raw
+	}
+
+	once := seal(testMessage, 1, "forwarder.example", rsaKey, "none")
+	twice := seal(once, 2, "list.example", edKey, "pass")
+
+	result, err := verifier.Verify(context.Background(), []byte(twice), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.ARC.Status != StatusPass || result.ARC.Instances != 2 || result.ARC.Domains[1] != "list.example" {
+		t.Fatalf("unexpected arc result %+v", result.ARC)
+	}
+
+	result, _ = verifier.Verify(context.Background(), []byte(testMessage), "")
+	if result.ARC.Status != StatusNone {
+		t.Fatalf("expected no arc chain, got %+v", result.ARC)
+	}
+
+	tampered := strings.Replace(twice, "spf=pass smtp.mailfrom=example.org\r\nARC-Authentication-Results: i=1", "spf=fail smtp.mailfrom=example.org\r\nARC-Authentication-Results: i=1", 1)
+	tampered = strings.Replace(tampered, "i=1; forwarder.example; spf=pass", "i=1; forwarder.example; spf=fail", 1)
+	result, _ = verifier.Verify(context.Background(), []byte(tampered), "")
+	if result.ARC.Status != StatusFail || !strings.Contains(result.ARC.Reason, "arc seal i=") {
+		t.Fatalf("expected seal failure, got %+v", result.ARC)
+	}
+
+	broken := seal(testMessage, 1, "forwarder.example", rsaKey, "pass")
+	result, _ = verifier.Verify(context.Background(), []byte(broken), "")
+	if result.ARC.Status != StatusFail {
+		t.Fatalf("expected cv failure, got %+v", result.ARC)
+	}
+}
+
+func TestCheckSPF(t *testing.T) {
+	resolver := &fakeResolver{
+		txt: map[string][]string{
+			"example.org":         {"v=spf1 ip4:198.51.100.0/24 include:_spf.example.net mx a:relay.example.org/30 -all", "google-site-verification=x"},
+			"_spf.example.net":    {"v=spf1 ip6:2001:db8::/32 ~all"},
+			"redirect.example":    {"v=spf1 redirect=example.org"},
+			"soft.example":        {"v=spf1 ~all"},
+			"macro.example":       {"v=spf1 exists:%{ir}.%{l1r-}.allow.macro.example -all"},
+			"double.example":      {"v=spf1 -all", "v=spf1 +all"},
+			"loop.example":        {"v=spf1 include:loop.example -all"},
+			"broken.example":      {"v=spf1 ip4:300.1.1.1 -all"},
+			"temp.example":        {"v=spf1 include:unreachable.example -all"},
+			"unreachable.example": {"v=spf1 -all"},
+			"void.example":        {"v=spf1 a:x1.void.example a:x2.void.example a:x3.void.example -all"},
+			"ptr.example":         {"v=spf1 ptr -all"},
+			"ptrdomain.example":   {"v=spf1 ptr:example.org -all"},
+		},
+		ip: map[string][]string{
+			"mx1.example.org":                       {"203.0.113.5"},
+			"relay.example.org":                     {"192.0.2.9"},
+			"10.2.0.192.bounce.allow.macro.example": {"127.0.0.2"},
+			"mail.ptr.example":                      {"192.0.2.30"},
+			"forged.ptr.example":                    {"192.0.2.99"},
+		},
+		mx: map[string][]string{"example.org": {"mx1.example.org"}},
+		ptr: map[string][]string{
+			"192.0.2.30":  {"mail.ptr.example."},
+			"192.0.2.31":  {"forged.ptr.example."},
+			"192.0.2.10":  {"mail.example.org."},
+			"203.0.113.5": {"mx1.example.org."},
+		},
+		fail: map[string]bool{"unreachable.example": true},
+	}
+	verifier := NewVerifier(resolver)
+
+	tests := []struct {
+		ip       string
+		mailFrom string
+		status   Status
+	}{
+		{"198.51.100.7", "bounce@example.org", StatusPass},
+		{"2001:db8::1", "bounce@example.org", StatusPass},
+		{"2001:db9::1", "bounce@example.org", StatusFail},
+		{"203.0.113.5", "bounce@example.org", StatusPass},
+		{"192.0.2.10", "bounce@example.org", StatusPass},
+		{"192.0.2.20", "bounce@example.org", StatusFail},
+		{"198.51.100.7", "bounce@redirect.example", StatusPass},
+		{"192.0.2.20", "<bounce@soft.example>", StatusSoftFail},
+		{"192.0.2.10", "bounce@macro.example", StatusPass},
+		{"192.0.2.11", "bounce@macro.example", StatusFail},
+		{"192.0.2.10", "bounce@double.example", StatusPermError},
+		{"192.0.2.10", "bounce@loop.example", StatusPermError},
+		{"192.0.2.10", "bounce@broken.example", StatusPermError},
+		{"192.0.2.10", "bounce@temp.example", StatusTempError},
+		{"192.0.2.10", "bounce@void.example", StatusPermError},
+		{"192.0.2.10", "bounce@nospf.example", StatusNone},
+		{"192.0.2.30", "bounce@ptr.example", StatusPass},
+		{"192.0.2.31", "bounce@ptr.example", StatusFail},
+		{"192.0.2.40", "bounce@ptr.example", StatusFail},
+		{"192.0.2.10", "bounce@ptrdomain.example", StatusFail},
+		{"203.0.113.5", "bounce@ptrdomain.example", StatusPass},
+		{"192.0.2.10", "", StatusNone},
+	}
+	for _, test := range tests {
+		result := verifier.CheckSPF(context.Background(), net.ParseIP(test.ip), "mail.example.org", test.mailFrom)
+		if result.Status != test.status {
+			t.Errorf("%s from %s: expected %s, got %s (%s)", test.mailFrom, test.ip, test.status, result.Status, result.Reason)
+		}
+	}
+
+	result := verifier.CheckSPF(context.Background(), net.ParseIP("198.51.100.7"), "example.org", "")
+	if result.Status != StatusPass || result.Sender != "postmaster@example.org" || result.Mechanism != "ip4:198.51.100.0/24" {
+		t.Fatalf("unexpected helo result %+v", result)
+	}
+}
+
+func TestCanonicalization(t *testing.T) {
+	if body := canonicalBody(CanonicalizationSimple, []byte("a \r\n\r\n")); string(body) != "a \r\n" {
+		t.Fatalf("unexpected simple body %q", body)
+	}
+	if body := canonicalBody(CanonicalizationRelaxed, []byte("a  b \t\r\n\r\n")); string(body) != "a b\r\n" {
+		t.Fatalf("unexpected relaxed body %q", body)
+	}
+	if body := canonicalBody(CanonicalizationSimple, nil); string(body) != "\r\n" {
+		t.Fatalf("unexpected empty simple body %q", body)
+	}
+	field := &headerField{Name: "Subject", Raw: "SubJect : a \r\n\t b \r\n"}
+	if h := canonicalHeader(CanonicalizationRelaxed, field); h != "subject:a b\r\n" {
+		t.Fatalf("unexpected relaxed header %q", h)
+	}
+	if stripped := removeSignatureValue("DKIM-Signature: a=x; bh=abc; b=sig\r\n nature; d=x\r\n"); stripped != "DKIM-Signature: a=x; bh=abc; b=; d=x\r\n" {
+		t.Fatalf("unexpected stripped signature %q", stripped)
+	}
+	if stripped := removeSignatureValue("DKIM-Signature: a=x; b=signature\r\n"); stripped != "DKIM-Signature: a=x; b=\r\n" {
+		t.Fatalf("unexpected stripped signature %q", stripped)
+	}
+}
+
+func TestResolverErrors(t *testing.T) {
+	if !isNotFound(fmt.Errorf("wrapped: %w", &net.DNSError{IsNotFound: true})) || isNotFound(errors.New("other")) {
+		t.Fatal("unexpected not found classification")
+	}
+}
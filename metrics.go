@@ -0,0 +1,63 @@
+package awshandler
+
+import (
+	"strings"
+	"time"
+
+	"github.com/igorrendulic/couchdb-email-aws-parse/sns"
+)
+
+// Metrics receives measurements from AwsSmtpHandler and SNS signature verification.
+// Implementations must be safe for concurrent use. See PrometheusMetrics for the default implementation.
+type Metrics interface {
+	sns.Metrics
+	NotificationHandled(notificationType string, err error)      // every handled message, notificationType is empty if unknown
+	Bounced(domain string, bounceType string)                    // every bounced recipient
+	Complained(domain string, feedbackType string)               // every complained recipient
+	S3Downloaded(bytes int64, duration time.Duration, err error) // MIME content download of Received notification, err is *S3Error or *SizeLimitError
+}
+
+// SetMetrics sets metrics hook on the handler and its SNS signature verification
+func (p *AwsSmtpHandler) SetMetrics(metrics Metrics) {
+	p.metrics = metrics
+	p.verifier.Metrics = metrics
+}
+
+// recording outcome of handling a message
+func (p *AwsSmtpHandler) recordMetrics(ex *Exchange, err error) {
+	if p.metrics == nil {
+		return
+	}
+
+	notificationType := ""
+	if ex.Output != nil {
+		notificationType = ex.Output.NotificationType
+	} else if ex.Message != nil {
+		notificationType = ex.Message.NotificationType
+	} else if ex.Envelope != nil {
+		notificationType = ex.Envelope.Type
+	}
+	p.metrics.NotificationHandled(notificationType, err)
+
+	if err != nil || ex.Output == nil {
+		return
+	}
+	if bounce := ex.Output.Bounce; bounce != nil {
+		for _, r := range bounce.BouncedRecipients {
+			p.metrics.Bounced(emailDomain(r.EmailAddress), bounce.BounceType)
+		}
+	}
+	if complaint := ex.Output.Complaint; complaint != nil {
+		for _, r := range complaint.ComplainedRecipients {
+			p.metrics.Complained(emailDomain(r.EmailAddress), complaint.ComplaintFeedbackType)
+		}
+	}
+}
+
+func emailDomain(address string) string {
+	at := strings.LastIndex(address, "@")
+	if at < 0 {
+		return ""
+	}
+	return strings.ToLower(strings.TrimRight(address[at+1:], "> "))
+}
@@ -0,0 +1,59 @@
+package awshandler
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestPrometheusMetrics(t *testing.T) {
+	svc, _, _ := dlLoggingSvc([]byte("From: example@example.com\r\n\r\nhowdi"))
+	smtpHandler := newAwsSmtpHandler(svc)
+	metrics := NewPrometheusMetrics("ses")
+	smtpHandler.SetMetrics(metrics)
+
+	for _, name := range []string{"received", "bounce", "complaint"} {
+		payload, err := LoadPayload("test_data/" + name + ".json")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := smtpHandler.HandleSmtp(payload); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := smtpHandler.HandleSmtp([]byte(`{"notificationType":"Open"}`)); err == nil {
+		t.Fatalf("expected unsupported type error")
+	}
+
+	limited, err := NewAwsSmtpHandlerWithOptions(WithS3(svc), WithMaxMimeSize(10), WithMetrics(metrics))
+	if err != nil {
+		t.Fatal(err)
+	}
+	received, err := LoadPayload("test_data/received.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := limited.HandleSmtp(received); err == nil {
+		t.Fatalf("expected size limit error")
+	}
+
+	rec := httptest.NewRecorder()
+	metrics.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body := rec.Body.String()
+
+	expected := []string{
+		`ses_notifications_total{type="Received",error=""} 1`,
+		`ses_notifications_total{type="Open",error="unsupported_type"} 1`,
+		`ses_bounced_recipients_total{domain="example.com",bounce_type="Permanent"} 1`,
+		`ses_complained_recipients_total{domain="example.com",feedback_type="abuse"} 1`,
+		`ses_s3_download_bytes_total 34`,
+		`ses_s3_download_duration_seconds_count 2`,
+		`ses_s3_download_errors_total{error="size_limit"} 1`,
+		`ses_signature_verification_failures_total 0`,
+	}
+	for _, line := range expected {
+		if !strings.Contains(body, line+"\n") {
+			t.Fatalf("expected %q in metrics:\n%s", line, body)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package awshandler
+
+import (
+	"context"
+	"errors"
+
+	"github.com/igorrendulic/couchdb-email-aws-parse/sns"
+	"github.com/igorrendulic/couchdb-experiment/email/mime/handler"
+)
+
+// ErrSkip returned by middleware stops handling of the message without error.
+// HandleSmtp returns Exchange.Output as set at that point (nil unless set by middleware).
+var ErrSkip = errors.New("skip message")
+
+// Stage of message handling at which middleware is called
+type Stage int
+
+const (
+	StageRaw      Stage = iota // raw message bytes, nothing parsed yet
+	StageEnvelope              // SNS envelope parsed (only for messages delivered in SNS envelope)
+	StageMessage               // SES notification parsed, before mapping (and S3 download for Received)
+	StageOutput                // MailReceived mapped
+)
+
+func (s Stage) String() string {
+	switch s {
+	case StageRaw:
+		return "raw"
+	case StageEnvelope:
+		return "envelope"
+	case StageMessage:
+		return "message"
+	case StageOutput:
+		return "output"
+	}
+	return "unknown"
+}
+
+// Exchange carries intermediate results of handling a single message
+type Exchange struct {
+	Raw         []byte                   // message as received
+	Envelope    *sns.Payload             // SNS envelope, nil for raw message delivery and EventBridge events
+	Message     *MessageJSON             // parsed SES notification, nil for subscription confirmations
+	Output      *handler.MailReceived    // mapped output
+	Quarantine  *Quarantine              // set if Received message failed verdicts of QuarantinePolicy
+	AuthResults []*AuthenticationResults // parsed Authentication-Results headers of Received notifications (not in Output, see AuthResultsOf)
+	Trace       *ReceivedTrace           // hops parsed from Received headers of Received notifications (not in Output, see ReceivedTraceOf)
+	AutoReply   *AutoReply               // set if Received message was sent automatically (out-of-office, autoresponder, bulk), not in Output (see AutoReplyOf)
+	Annotations map[string]interface{}   // set by middleware
+
+	ctx context.Context
+}
+
+func newExchange(ctx context.Context, message []byte) *Exchange {
+	return &Exchange{
+		Raw:         message,
+		Annotations: map[string]interface{}{},
+		ctx:         ctx,
+	}
+}
+
+// Context of handling the message (e.g. passed to HandleSmtpContext), middleware should use it for any I/O.
+// Background context is returned for exchanges not created by the handler.
+func (ex *Exchange) Context() context.Context {
+	if ex.ctx == nil {
+		return context.Background()
+	}
+	return ex.ctx
+}
+
+// Annotate attaches value to the exchange for later stages or callers of Process
+func (ex *Exchange) Annotate(key string, value interface{}) {
+	if ex.Annotations == nil {
+		ex.Annotations = map[string]interface{}{}
+	}
+	ex.Annotations[key] = value
+}
+
+// Middleware is called at every stage of handling a message in order of registration.
+// Returning ErrSkip short-circuits handling without error, any other error fails handling.
+type Middleware func(stage Stage, ex *Exchange) error
+
+// Use appends middleware to the handler
+func (p *AwsSmtpHandler) Use(middleware ...Middleware) {
+	p.middleware = append(p.middleware, middleware...)
+}
+
+func (p *AwsSmtpHandler) intercept(stage Stage, ex *Exchange) error {
+	p.logStage(stage, ex)
+	for _, mw := range p.middleware {
+		if err := mw(stage, ex); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func skipped(err error) error {
+	if errors.Is(err, ErrSkip) {
+		return nil
+	}
+	return err
+}
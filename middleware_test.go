@@ -0,0 +1,128 @@
+package awshandler
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestMiddlewareStages(t *testing.T) {
+	bounce, err := LoadPayload("test_data/bounce.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	svc, _, _ := dlLoggingSvc([]byte{})
+	smtpHandler, err := NewAwsSmtpHandlerWithOptions(WithS3(svc), WithSignatureVerification(true, false))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stages := []string{}
+	smtpHandler.Use(func(stage Stage, ex *Exchange) error {
+		stages = append(stages, stage.String())
+		if stage == StageEnvelope && ex.Envelope.TopicArn != "arn:aws:sns:us-west-2:123456:bounce" {
+			t.Fatalf("expected parsed SNS envelope at envelope stage")
+		}
+		if stage == StageMessage && ex.Message.Bounce == nil {
+			t.Fatalf("expected parsed SES message at message stage")
+		}
+		if stage == StageOutput {
+			ex.Annotate("bounced", len(ex.Output.Bounce.BouncedRecipients))
+		}
+		return nil
+	})
+
+	ex, err := smtpHandler.Process(wrapInSnsEnvelope(t, bounce))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(stages, ",") != "raw,envelope,message,output" {
+		t.Fatalf("unexpected stages: %v", stages)
+	}
+	if ex.Annotations["bounced"] != 1 {
+		t.Fatalf("expected annotation from middleware, got %v", ex.Annotations)
+	}
+}
+
+func TestMiddlewareSkip(t *testing.T) {
+	received, err := LoadPayload("test_data/received.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	svc, names, _ := dlLoggingSvc([]byte{})
+	smtpHandler := newAwsSmtpHandler(svc)
+	smtpHandler.Use(func(stage Stage, ex *Exchange) error {
+		if stage == StageMessage && ex.Message.Mail.MessageID == "4lemd3cvrcl5fefchgm609pk8iihuj0hhca8fe81" {
+			// e.g. already seen message
+			return ErrSkip
+		}
+		return nil
+	})
+
+	mail, err := smtpHandler.HandleSmtp(received)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mail != nil {
+		t.Fatalf("expected no output for skipped message")
+	}
+	if len(*names) != 0 {
+		t.Fatalf("expected S3 download to be skipped, got %v", *names)
+	}
+}
+
+func TestMiddlewareError(t *testing.T) {
+	delivery, err := LoadPayload("test_data/delivery.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	svc, _, _ := dlLoggingSvc([]byte{})
+	smtpHandler := newAwsSmtpHandler(svc)
+	rejected := errors.New("rejected")
+	smtpHandler.Use(func(stage Stage, ex *Exchange) error {
+		if stage == StageRaw {
+			return rejected
+		}
+		return nil
+	})
+
+	if _, err := smtpHandler.HandleSmtp(delivery); !errors.Is(err, rejected) {
+		t.Fatalf("expected middleware error, got %v", err)
+	}
+}
+
+type contextKey struct{}
+
+func TestMiddlewareContext(t *testing.T) {
+	bounce, err := LoadPayload("test_data/bounce.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	svc, _, _ := dlLoggingSvc([]byte{})
+	smtpHandler := newAwsSmtpHandler(svc)
+
+	seen := 0
+	smtpHandler.Use(func(stage Stage, ex *Exchange) error {
+		if ex.Context().Value(contextKey{}) != "request" {
+			t.Fatalf("expected context of HandleSmtpContext at %s stage", stage)
+		}
+		seen++
+		return nil
+	})
+
+	ctx := context.WithValue(context.Background(), contextKey{}, "request")
+	if _, err := smtpHandler.HandleSmtpContext(ctx, bounce); err != nil {
+		t.Fatal(err)
+	}
+	if seen == 0 {
+		t.Fatalf("expected middleware to be called")
+	}
+	if (&Exchange{}).Context() == nil {
+		t.Fatalf("expected background context for exchange not created by the handler")
+	}
+}
@@ -0,0 +1,162 @@
+package awshandler
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/aws/aws-sdk-go/aws/arn"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Option configures AwsSmtpHandler created by NewAwsSmtpHandlerWithOptions
+type Option func(p *AwsSmtpHandler) error
+
+// NewAwsSmtpHandlerWithOptions creates handler configured by opts, WithS3 is required.
+//
+//	h, err := awshandler.NewAwsSmtpHandlerWithOptions(
+//		awshandler.WithS3(s3.New(sess)),
+//		awshandler.WithTopics("arn:aws:sns:us-west-2:123456789012:ses-received"),
+//		awshandler.WithMaxMimeSize(10<<20),
+//	)
+func NewAwsSmtpHandlerWithOptions(opts ...Option) (*AwsSmtpHandler, error) {
+	p := newAwsSmtpHandler(nil)
+	for _, opt := range opts {
+		if err := opt(p); err != nil {
+			return nil, err
+		}
+	}
+	if p.svc == nil {
+		return nil, errors.New("s3 client is required (WithS3)")
+	}
+	return p, nil
+}
+
+// WithS3 sets S3 client used to download MIME content of Received notifications
+func WithS3(svc s3iface.S3API) Option {
+	return func(p *AwsSmtpHandler) error {
+		p.svc = svc
+		return nil
+	}
+}
+
+// WithTopics only allows SNS envelopes from the listed topics (TopicNotAllowedError otherwise).
+// Raw message delivery and EventBridge events carry no topic and aren't checked.
+func WithTopics(topicArns ...string) Option {
+	return func(p *AwsSmtpHandler) error {
+		for _, topicArn := range topicArns {
+			parsed, err := arn.Parse(topicArn)
+			if err != nil {
+				return fmt.Errorf("invalid topic arn %q: %w", topicArn, err)
+			}
+			if parsed.Service != "sns" {
+				return fmt.Errorf("invalid topic arn %q: not an sns topic", topicArn)
+			}
+			p.topics[topicArn] = true
+		}
+		return nil
+	}
+}
+
+// WithMaxMessageSize limits size of handled messages in bytes (SizeLimitError otherwise), 0 for no limit
+func WithMaxMessageSize(size int64) Option {
+	return func(p *AwsSmtpHandler) error {
+		if size < 0 {
+			return fmt.Errorf("invalid max message size: %d", size)
+		}
+		p.maxMessageSize = size
+		return nil
+	}
+}
+
+// WithMaxMimeSize limits size of MIME content downloaded from S3 in bytes (SizeLimitError otherwise), 0 for no limit
+func WithMaxMimeSize(size int64) Option {
+	return func(p *AwsSmtpHandler) error {
+		if size < 0 {
+			return fmt.Errorf("invalid max mime size: %d", size)
+		}
+		p.maxMimeSize = size
+		return nil
+	}
+}
+
+// WithSignatureVerification toggles SNS signature verification of subscription confirmations and notifications.
+// Both are enabled by default, disable them only for envelopes authenticated otherwise (e.g. in tests).
+// Messages without SNS envelope (raw message delivery, EventBridge events) carry no signature and are accepted
+// unverified, use WithRequireEnvelope for endpoints receiving only SNS envelopes (e.g. an HTTP subscription).
+func WithSignatureVerification(subscriptions bool, notifications bool) Option {
+	return func(p *AwsSmtpHandler) error {
+		p.verifySubscriptions = subscriptions
+		p.verifyNotifications = notifications
+		return nil
+	}
+}
+
+// WithRequireEnvelope rejects messages without SNS envelope in HandleSmtp and Process with SignatureError
+// (HandleEventBridge isn't affected)
+func WithRequireEnvelope(required bool) Option {
+	return func(p *AwsSmtpHandler) error {
+		p.requireEnvelope = required
+		return nil
+	}
+}
+
+// WithHTTPClient sets client used to fetch SNS signing certificates and confirm subscriptions
+func WithHTTPClient(client *http.Client) Option {
+	return func(p *AwsSmtpHandler) error {
+		if client == nil {
+			return errors.New("http client is nil")
+		}
+		p.httpClient = client
+		p.verifier.Client = client
+		return nil
+	}
+}
+
+// WithMetrics is SetMetrics as an option
+func WithMetrics(metrics Metrics) Option {
+	return func(p *AwsSmtpHandler) error {
+		p.SetMetrics(metrics)
+		return nil
+	}
+}
+
+// WithTracerProvider is SetTracerProvider as an option
+func WithTracerProvider(tp trace.TracerProvider) Option {
+	return func(p *AwsSmtpHandler) error {
+		p.SetTracerProvider(tp)
+		return nil
+	}
+}
+
+// WithLogger is SetLogger and SetRedactionPolicy as an option, invalid policy is an error
+func WithLogger(logger Logger, policy RedactionPolicy) Option {
+	return func(p *AwsSmtpHandler) error {
+		if err := policy.Validate(); err != nil {
+			return err
+		}
+		p.SetLogger(logger)
+		p.SetRedactionPolicy(policy)
+		return nil
+	}
+}
+
+// WithTimeouts is SetTimeouts as an option
+func WithTimeouts(timeouts Timeouts) Option {
+	return func(p *AwsSmtpHandler) error {
+		if timeouts.Verify < 0 || timeouts.Subscribe < 0 || timeouts.Download < 0 {
+			return fmt.Errorf("invalid negative timeout: %+v", timeouts)
+		}
+		p.SetTimeouts(timeouts)
+		return nil
+	}
+}
+
+// WithMiddleware is Use as an option
+func WithMiddleware(middleware ...Middleware) Option {
+	return func(p *AwsSmtpHandler) error {
+		p.Use(middleware...)
+		return nil
+	}
+}
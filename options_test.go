@@ -0,0 +1,197 @@
+package awshandler
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestOptionsValidation(t *testing.T) {
+	svc, _, _ := dlLoggingSvc([]byte{})
+
+	if _, err := NewAwsSmtpHandlerWithOptions(); err == nil {
+		t.Fatal("expected missing s3 client error")
+	}
+	if _, err := NewAwsSmtpHandlerWithOptions(WithS3(svc), WithTopics("arn:aws:sqs:us-west-2:123456:queue")); err == nil {
+		t.Fatal("expected invalid topic error")
+	}
+	if _, err := NewAwsSmtpHandlerWithOptions(WithS3(svc), WithMaxMimeSize(-1)); err == nil {
+		t.Fatal("expected invalid size error")
+	}
+	if _, err := NewAwsSmtpHandlerWithOptions(WithS3(svc), WithTimeouts(Timeouts{Download: -time.Second})); err == nil {
+		t.Fatal("expected invalid timeout error")
+	}
+	if _, err := NewAwsSmtpHandlerWithOptions(WithS3(svc), WithLogger(nil, RedactionPolicy{Addresses: RedactAddressHash})); err == nil {
+		t.Fatal("expected address hashing without key to be an error")
+	}
+}
+
+func TestOptionsTopicAllowList(t *testing.T) {
+	bounce, err := LoadPayload("test_data/bounce.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	svc, _, _ := dlLoggingSvc([]byte{})
+
+	allowed, err := NewAwsSmtpHandlerWithOptions(WithS3(svc), WithTopics("arn:aws:sns:us-west-2:123456:bounce"), WithSignatureVerification(true, false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := allowed.HandleSmtp(wrapInSnsEnvelope(t, bounce)); err != nil {
+		t.Fatal(err)
+	}
+
+	denied, err := NewAwsSmtpHandlerWithOptions(WithS3(svc), WithTopics("arn:aws:sns:us-west-2:123456:other"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = denied.HandleSmtp(wrapInSnsEnvelope(t, bounce))
+	var topicErr *TopicNotAllowedError
+	if !errors.As(err, &topicErr) || IsRetryable(err) {
+		t.Fatalf("expected topic not allowed error, got %v", err)
+	}
+}
+
+func TestOptionsNotificationVerificationDefault(t *testing.T) {
+	bounce, err := LoadPayload("test_data/bounce.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	svc, _, _ := dlLoggingSvc([]byte{})
+
+	// unsigned envelope is rejected unless verification is disabled explicitly
+	if _, err := NewAwsSmtpHandler(svc).HandleSmtp(wrapInSnsEnvelope(t, bounce)); !errors.Is(err, SignatureInvalid) {
+		t.Fatalf("expected signature error, got %v", err)
+	}
+	h, err := NewAwsSmtpHandlerWithOptions(WithS3(svc), WithSignatureVerification(true, false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := h.HandleSmtp(wrapInSnsEnvelope(t, bounce)); err != nil {
+		t.Fatal(err)
+	}
+
+	// raw message delivery carries no signature, accepted unless envelope is required
+	if _, err := h.HandleSmtp(bounce); err != nil {
+		t.Fatal(err)
+	}
+	eventBridge, err := LoadPayload("test_data/eventbridge-bounce.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	h, err = NewAwsSmtpHandlerWithOptions(WithS3(svc), WithRequireEnvelope(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, message := range [][]byte{bounce, eventBridge} {
+		if _, err := h.HandleSmtp(message); !errors.Is(err, SignatureInvalid) {
+			t.Fatalf("expected signature error for message without envelope, got %v", err)
+		}
+	}
+}
+
+func TestOptionsSizeLimits(t *testing.T) {
+	received, err := LoadPayload("test_data/received.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	svc, _, _ := dlLoggingSvc(make([]byte, 1024))
+
+	h, err := NewAwsSmtpHandlerWithOptions(WithS3(svc), WithMaxMessageSize(100))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := h.HandleSmtp(received); ErrorKind(err) != "size_limit" {
+		t.Fatalf("expected message size error, got %v", err)
+	}
+
+	h, err = NewAwsSmtpHandlerWithOptions(WithS3(svc), WithMaxMimeSize(512))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sizeErr *SizeLimitError
+	if _, err := h.HandleSmtp(received); !errors.As(err, &sizeErr) || sizeErr.What != "mime" {
+		t.Fatalf("expected mime size error, got %v", err)
+	}
+
+	h, err = NewAwsSmtpHandlerWithOptions(WithS3(svc), WithMaxMimeSize(1024))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mail, err := h.HandleSmtp(received)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mail.Mail.RawMime) != 1024 {
+		t.Fatalf("expected full mime content, got %d bytes", len(mail.Mail.RawMime))
+	}
+}
+
+func TestLoadConfigFile(t *testing.T) {
+	dir := t.TempDir()
+	yamlPath := filepath.Join(dir, "config.yaml")
+	jsonPath := filepath.Join(dir, "config.json")
+	os.WriteFile(yamlPath, []byte("topics:\n  - arn:aws:sns:us-west-2:123456:bounce\nmaxMimeSize: 1048576\ndownloadTimeout: 30s\nskipNotificationVerification: true\n"), 0600)
+	os.WriteFile(jsonPath, []byte(`{"topics":["arn:aws:sns:us-west-2:123456:bounce"],"maxMimeSize":1048576,"downloadTimeout":"30s","skipNotificationVerification":true}`), 0600)
+
+	for _, path := range []string{yamlPath, jsonPath} {
+		config, err := LoadConfigFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(config.Topics) != 1 || config.MaxMimeSize != 1048576 || time.Duration(config.DownloadTimeout) != 30*time.Second || !config.SkipNotificationVerification {
+			t.Fatalf("unexpected config from %s: %+v", path, config)
+		}
+
+		svc, _, _ := dlLoggingSvc([]byte{})
+		h, err := NewAwsSmtpHandlerWithOptions(WithS3(svc), WithConfig(config))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !h.topics["arn:aws:sns:us-west-2:123456:bounce"] || h.timeouts.Download != 30*time.Second || h.verifyNotifications || !h.verifySubscriptions {
+			t.Fatalf("config not applied from %s", path)
+		}
+	}
+
+	os.WriteFile(jsonPath, []byte(`{"downloadTimeout":"soon"}`), 0600)
+	if _, err := LoadConfigFile(jsonPath); err == nil {
+		t.Fatal("expected invalid duration error")
+	}
+}
+
+func TestLoadConfigFromEnv(t *testing.T) {
+	t.Setenv("SES_HANDLER_TOPICS", "arn:aws:sns:us-west-2:123456:bounce, arn:aws:sns:us-west-2:123456:received")
+	t.Setenv("SES_HANDLER_MAX_MESSAGE_SIZE", "262144")
+	t.Setenv("SES_HANDLER_SKIP_SUBSCRIPTION_VERIFICATION", "true")
+	t.Setenv("SES_HANDLER_REQUIRE_ENVELOPE", "true")
+	t.Setenv("SES_HANDLER_HTTP_TIMEOUT", "5s")
+	t.Setenv("SES_HANDLER_QUARANTINE_VIRUS", "reject")
+	t.Setenv("SES_HANDLER_QUARANTINE_DMARC_POLICIES", "reject, quarantine")
+
+	config, err := LoadConfigFromEnv("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(config.Topics) != 2 || config.MaxMessageSize != 262144 || !config.SkipSubscriptionVerification || time.Duration(config.HTTPTimeout) != 5*time.Second {
+		t.Fatalf("unexpected config: %+v", config)
+	}
+
+	svc, _, _ := dlLoggingSvc([]byte{})
+	h, err := NewAwsSmtpHandlerWithOptions(WithS3(svc), WithConfig(config))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.verifySubscriptions || !h.requireEnvelope || h.httpClient == nil || h.httpClient.Timeout != 5*time.Second {
+		t.Fatalf("config not applied")
+	}
+	if h.quarantine.Virus != VerdictReject || len(h.quarantine.DmarcPolicies) != 2 {
+		t.Fatalf("quarantine policy not applied: %+v", h.quarantine)
+	}
+
+	t.Setenv("SES_HANDLER_MAX_MIME_SIZE", "large")
+	if _, err := LoadConfigFromEnv(""); err == nil {
+		t.Fatal("expected invalid value error")
+	}
+}
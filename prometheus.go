@@ -0,0 +1,230 @@
+package awshandler
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// default histogram buckets (seconds), same as Prometheus client defaults
+var defaultDurationBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// PrometheusMetrics is Metrics implementation exposing measurements in Prometheus text exposition format.
+// It implements http.Handler so it can be scraped directly:
+//
+//	metrics := awshandler.NewPrometheusMetrics("ses")
+//	smtpHandler.SetMetrics(metrics)
+//	http.Handle("/metrics", metrics)
+type PrometheusMetrics struct {
+	namespace string
+
+	mu                   sync.Mutex
+	notifications        *counterVec
+	bounces              *counterVec
+	complaints           *counterVec
+	signatureFailures    *counterVec
+	certificateFetch     *histogram
+	certificateFetchErrs *counterVec
+	s3DownloadBytes      *counterVec
+	s3Download           *histogram
+	s3DownloadErrs       *counterVec
+}
+
+var _ Metrics = (*PrometheusMetrics)(nil)
+
+// NewPrometheusMetrics creates metrics with names prefixed by namespace (e.g. ses_notifications_total)
+func NewPrometheusMetrics(namespace string) *PrometheusMetrics {
+	return &PrometheusMetrics{
+		namespace:            namespace,
+		notifications:        newCounterVec("notifications_total", "Handled SES notifications by type and error kind.", "type", "error"),
+		bounces:              newCounterVec("bounced_recipients_total", "Bounced recipients by domain and bounce type.", "domain", "bounce_type"),
+		complaints:           newCounterVec("complained_recipients_total", "Complained recipients by domain and feedback type.", "domain", "feedback_type"),
+		signatureFailures:    newCounterVec("signature_verification_failures_total", "Failed SNS signature verifications."),
+		certificateFetch:     newHistogram("certificate_fetch_duration_seconds", "SNS signing certificate fetch latency.", defaultDurationBuckets),
+		certificateFetchErrs: newCounterVec("certificate_fetch_errors_total", "Failed SNS signing certificate fetches."),
+		s3DownloadBytes:      newCounterVec("s3_download_bytes_total", "Bytes of MIME content downloaded from S3."),
+		s3Download:           newHistogram("s3_download_duration_seconds", "S3 MIME content download latency.", defaultDurationBuckets),
+		s3DownloadErrs:       newCounterVec("s3_download_errors_total", "Failed S3 MIME content downloads by error kind.", "error"),
+	}
+}
+
+func (m *PrometheusMetrics) NotificationHandled(notificationType string, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.notifications.add(1, notificationType, ErrorKind(err))
+}
+
+func (m *PrometheusMetrics) Bounced(domain string, bounceType string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.bounces.add(1, domain, bounceType)
+}
+
+func (m *PrometheusMetrics) Complained(domain string, feedbackType string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.complaints.add(1, domain, feedbackType)
+}
+
+func (m *PrometheusMetrics) SignatureVerified(err error) {
+	if err == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.signatureFailures.add(1)
+}
+
+func (m *PrometheusMetrics) CertificateFetched(duration time.Duration, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.certificateFetch.observe(duration.Seconds())
+	if err != nil {
+		m.certificateFetchErrs.add(1)
+	}
+}
+
+func (m *PrometheusMetrics) S3Downloaded(bytes int64, duration time.Duration, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.s3DownloadBytes.add(float64(bytes))
+	m.s3Download.observe(duration.Seconds())
+	if err != nil {
+		m.s3DownloadErrs.add(1, ErrorKind(err))
+	}
+}
+
+// ServeHTTP writes all metrics in Prometheus text exposition format
+func (m *PrometheusMetrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	m.WriteTo(w)
+}
+
+// WriteTo writes all metrics in Prometheus text exposition format
+func (m *PrometheusMetrics) WriteTo(w io.Writer) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var sb strings.Builder
+	m.notifications.write(&sb, m.namespace)
+	m.bounces.write(&sb, m.namespace)
+	m.complaints.write(&sb, m.namespace)
+	m.signatureFailures.write(&sb, m.namespace)
+	m.certificateFetch.write(&sb, m.namespace)
+	m.certificateFetchErrs.write(&sb, m.namespace)
+	m.s3DownloadBytes.write(&sb, m.namespace)
+	m.s3Download.write(&sb, m.namespace)
+	m.s3DownloadErrs.write(&sb, m.namespace)
+
+	n, err := io.WriteString(w, sb.String())
+	return int64(n), err
+}
+
+func metricName(namespace string, name string) string {
+	if namespace == "" {
+		return name
+	}
+	return namespace + "_" + name
+}
+
+type counterVec struct {
+	name   string
+	help   string
+	labels []string
+	values map[string]float64 // keyed by formatted label pairs
+}
+
+func newCounterVec(name string, help string, labels ...string) *counterVec {
+	return &counterVec{
+		name:   name,
+		help:   help,
+		labels: labels,
+		values: map[string]float64{},
+	}
+}
+
+func (c *counterVec) add(v float64, labelValues ...string) {
+	c.values[formatLabels(c.labels, labelValues)] += v
+}
+
+func (c *counterVec) write(sb *strings.Builder, namespace string) {
+	name := metricName(namespace, c.name)
+	fmt.Fprintf(sb, "# HELP %s %s\n# TYPE %s counter\n", name, c.help, name)
+	if len(c.labels) == 0 && len(c.values) == 0 {
+		fmt.Fprintf(sb, "%s 0\n", name)
+		return
+	}
+	keys := make([]string, 0, len(c.values))
+	for k := range c.values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(sb, "%s%s %v\n", name, k, c.values[k])
+	}
+}
+
+type histogram struct {
+	name    string
+	help    string
+	buckets []float64
+	counts  []uint64 // per bucket, not cumulative
+	sum     float64
+	count   uint64
+}
+
+func newHistogram(name string, help string, buckets []float64) *histogram {
+	return &histogram{
+		name:    name,
+		help:    help,
+		buckets: buckets,
+		counts:  make([]uint64, len(buckets)),
+	}
+}
+
+func (h *histogram) observe(v float64) {
+	for i, b := range h.buckets {
+		if v <= b {
+			h.counts[i]++
+			break
+		}
+	}
+	h.sum += v
+	h.count++
+}
+
+func (h *histogram) write(sb *strings.Builder, namespace string) {
+	name := metricName(namespace, h.name)
+	fmt.Fprintf(sb, "# HELP %s %s\n# TYPE %s histogram\n", name, h.help, name)
+	var cumulative uint64
+	for i, b := range h.buckets {
+		cumulative += h.counts[i]
+		fmt.Fprintf(sb, "%s_bucket{le=\"%v\"} %d\n", name, b, cumulative)
+	}
+	fmt.Fprintf(sb, "%s_bucket{le=\"+Inf\"} %d\n", name, h.count)
+	fmt.Fprintf(sb, "%s_sum %v\n", name, h.sum)
+	fmt.Fprintf(sb, "%s_count %d\n", name, h.count)
+}
+
+func formatLabels(names []string, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	pairs := make([]string, len(names))
+	for i, n := range names {
+		v := ""
+		if i < len(values) {
+			v = values[i]
+		}
+		pairs[i] = n + "=\"" + escapeLabelValue(v) + "\""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func escapeLabelValue(v string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(v)
+}
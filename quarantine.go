@@ -0,0 +1,123 @@
+package awshandler
+
+import (
+	"fmt"
+	"strings"
+)
+
+// VerdictAction is what happens to a Received message with a failed verdict
+type VerdictAction int
+
+const (
+	VerdictAccept     VerdictAction = iota // handled as usual
+	VerdictQuarantine                      // MIME is downloaded to Exchange.Quarantine, output carries no RawMime
+	VerdictReject                          // MIME isn't downloaded at all
+)
+
+func (a VerdictAction) String() string {
+	switch a {
+	case VerdictQuarantine:
+		return "quarantine"
+	case VerdictReject:
+		return "reject"
+	}
+	return "accept"
+}
+
+func (a *VerdictAction) UnmarshalText(text []byte) error {
+	switch strings.ToLower(string(text)) {
+	case "", "accept":
+		*a = VerdictAccept
+	case "quarantine":
+		*a = VerdictQuarantine
+	case "reject":
+		*a = VerdictReject
+	default:
+		return fmt.Errorf("invalid verdict action: %q", text)
+	}
+	return nil
+}
+
+func (a VerdictAction) MarshalText() ([]byte, error) {
+	return []byte(a.String()), nil
+}
+
+// QuarantinePolicy maps FAIL receipt verdicts of Received notifications to actions, the strictest action wins.
+//
+//	// reject viruses, quarantine DMARC failures of domains publishing p=reject
+//	awshandler.QuarantinePolicy{Virus: awshandler.VerdictReject, Dmarc: awshandler.VerdictQuarantine, DmarcPolicies: []string{"reject"}}
+type QuarantinePolicy struct {
+	Spam          VerdictAction `json:"spam,omitempty" yaml:"spam,omitempty"`
+	Virus         VerdictAction `json:"virus,omitempty" yaml:"virus,omitempty"`
+	Spf           VerdictAction `json:"spf,omitempty" yaml:"spf,omitempty"`
+	Dkim          VerdictAction `json:"dkim,omitempty" yaml:"dkim,omitempty"`
+	Dmarc         VerdictAction `json:"dmarc,omitempty" yaml:"dmarc,omitempty"`
+	DmarcPolicies []string      `json:"dmarcPolicies,omitempty" yaml:"dmarcPolicies,omitempty"` // apply Dmarc only for these published sender policies (none, quarantine, reject), all if empty
+}
+
+// Quarantine is the outcome of QuarantinePolicy for a message (Exchange.Quarantine)
+type Quarantine struct {
+	Action  VerdictAction
+	Reasons []string // failed verdicts, e.g. "virus FAIL" or "dmarc FAIL (policy reject)"
+	RawMime []byte   // MIME content isolated from output, nil when rejected
+}
+
+// Reason is all reasons joined
+func (q *Quarantine) Reason() string {
+	return strings.Join(q.Reasons, ", ")
+}
+
+// WithQuarantinePolicy sets policy of failed spam, virus and authentication verdicts (all accepted by default)
+func WithQuarantinePolicy(policy QuarantinePolicy) Option {
+	return func(p *AwsSmtpHandler) error {
+		p.quarantine = policy
+		return nil
+	}
+}
+
+// evaluating policy for Received notification, nil if the message is accepted
+func (q *QuarantinePolicy) evaluate(messageJson *MessageJSON) *Quarantine {
+	receipt := messageJson.Receipt
+	if receipt == nil || NotificationType(messageJson.NotificationType) != NotificationTypeReceived {
+		return nil
+	}
+
+	result := &Quarantine{}
+	check := func(name string, verdict *VerdictStatus, action VerdictAction, detail string) {
+		if action == VerdictAccept || verdict == nil || !strings.EqualFold(verdict.Status, "FAIL") {
+			return
+		}
+		result.Reasons = append(result.Reasons, name+" FAIL"+detail)
+		if action > result.Action {
+			result.Action = action
+		}
+	}
+	check("spam", receipt.SpamVerdict, q.Spam, "")
+	check("virus", receipt.VirusVerdict, q.Virus, "")
+	check("spf", receipt.SpfVerdict, q.Spf, "")
+	check("dkim", receipt.DkimVerdict, q.Dkim, "")
+	if q.dmarcPolicyApplies(receipt.DmarcPolicy) {
+		detail := ""
+		if receipt.DmarcPolicy != "" {
+			detail = " (policy " + strings.ToLower(receipt.DmarcPolicy) + ")"
+		}
+		check("dmarc", receipt.DmarcVerdict, q.Dmarc, detail)
+	}
+
+	if result.Action == VerdictAccept {
+		return nil
+	}
+	return result
+}
+
+func (q *QuarantinePolicy) dmarcPolicyApplies(policy string) bool {
+	if len(q.DmarcPolicies) == 0 {
+		return true
+	}
+	for _, p := range q.DmarcPolicies {
+		if strings.EqualFold(p, policy) {
+			return true
+		}
+	}
+	return false
+}
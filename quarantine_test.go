@@ -0,0 +1,140 @@
+package awshandler
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/igorrendulic/couchdb-email-aws-parse/awstest"
+	"github.com/igorrendulic/couchdb-experiment/email/mime/handler"
+)
+
+func receivedWithVerdicts(t *testing.T, virus string, dmarc string, dmarcPolicy string) []byte {
+	message, err := LoadPayload("test_data/received.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var messageJson MessageJSON
+	if err := json.Unmarshal(message, &messageJson); err != nil {
+		t.Fatal(err)
+	}
+	messageJson.Receipt.VirusVerdict = &VerdictStatus{Status: virus}
+	messageJson.Receipt.DmarcVerdict = &VerdictStatus{Status: dmarc}
+	messageJson.Receipt.DmarcPolicy = dmarcPolicy
+	data, err := json.Marshal(&messageJson)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestQuarantinePolicy(t *testing.T) {
+	s3Server := awstest.NewS3()
+	s3Server.Put("dev-mailiomailplainreceived", "4lemd3cvrcl5fefchgm609pk8iihuj0hhca8fe81", []byte("Subject: howdi\r\n\r\nhello\r\n"))
+	h, err := NewAwsSmtpHandlerWithOptions(
+		WithS3(s3Server),
+		WithQuarantinePolicy(QuarantinePolicy{Virus: VerdictReject, Dmarc: VerdictQuarantine, DmarcPolicies: []string{"reject"}}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		virus       string
+		dmarc       string
+		dmarcPolicy string
+		quarantine  *Quarantine
+		downloads   int
+	}{
+		{"accepted", "PASS", "PASS", "", nil, 1},
+		{"dmarc fail without reject policy", "PASS", "FAIL", "none", nil, 1},
+		{"dmarc fail with reject policy", "PASS", "FAIL", "REJECT", &Quarantine{Action: VerdictQuarantine, Reasons: []string{"dmarc FAIL (policy reject)"}}, 1},
+		{"virus", "FAIL", "FAIL", "reject", &Quarantine{Action: VerdictReject, Reasons: []string{"virus FAIL", "dmarc FAIL (policy reject)"}}, 0},
+	}
+	for _, test := range tests {
+		gets := len(s3Server.Gets())
+		ex, err := h.Process(receivedWithVerdicts(t, test.virus, test.dmarc, test.dmarcPolicy))
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if downloads := len(s3Server.Gets()) - gets; downloads != test.downloads {
+			t.Errorf("%s: expected %d downloads, got %d", test.name, test.downloads, downloads)
+		}
+
+		if test.quarantine == nil {
+			if ex.Quarantine != nil || len(ex.Output.Mail.RawMime) == 0 {
+				t.Errorf("%s: expected accepted message, got %+v", test.name, ex.Quarantine)
+			}
+			continue
+		}
+		if ex.Quarantine == nil || ex.Quarantine.Action != test.quarantine.Action || ex.Quarantine.Reason() != test.quarantine.Reason() {
+			t.Fatalf("%s: unexpected quarantine %+v", test.name, ex.Quarantine)
+		}
+		if len(ex.Output.Mail.RawMime) != 0 {
+			t.Errorf("%s: quarantined mime shouldn't be in the output", test.name)
+		}
+		if (len(ex.Quarantine.RawMime) > 0) != (test.quarantine.Action == VerdictQuarantine) {
+			t.Errorf("%s: unexpected isolated mime of %d bytes", test.name, len(ex.Quarantine.RawMime))
+		}
+	}
+}
+
+func TestVerdictActionText(t *testing.T) {
+	var policy QuarantinePolicy
+	if err := json.Unmarshal([]byte(`{"virus": "reject", "dmarc": "Quarantine"}`), &policy); err != nil {
+		t.Fatal(err)
+	}
+	if policy.Virus != VerdictReject || policy.Dmarc != VerdictQuarantine || policy.Spam != VerdictAccept {
+		t.Fatalf("unexpected policy %+v", policy)
+	}
+	if err := json.Unmarshal([]byte(`{"virus": "delete"}`), &policy); err == nil {
+		t.Fatal("expected invalid action error")
+	}
+}
+
+func TestQuarantineHandleSmtp(t *testing.T) {
+	s3Server := awstest.NewS3()
+	s3Server.Put("dev-mailiomailplainreceived", "4lemd3cvrcl5fefchgm609pk8iihuj0hhca8fe81", []byte("Subject: howdi\r\n\r\nhello\r\n"))
+	h, err := NewAwsSmtpHandlerWithOptions(
+		WithS3(s3Server),
+		WithQuarantinePolicy(QuarantinePolicy{Virus: VerdictReject, Dmarc: VerdictQuarantine}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mail, err := h.HandleSmtp(receivedWithVerdicts(t, "PASS", "FAIL", ""))
+	var quarantineErr *QuarantineError
+	if !errors.As(err, &quarantineErr) || quarantineErr.Action != VerdictQuarantine || mail != nil {
+		t.Fatalf("expected quarantine error, got %v", err)
+	}
+	if !reflect.DeepEqual(quarantineErr.Reasons, []string{"dmarc FAIL"}) || IsRetryable(err) || ErrorKind(err) != "quarantine" {
+		t.Fatalf("unexpected quarantine error %+v", quarantineErr)
+	}
+
+	_, err = h.HandleSmtp(receivedWithVerdicts(t, "FAIL", "PASS", ""))
+	if !errors.As(err, &quarantineErr) || quarantineErr.Action != VerdictReject || ErrorKind(err) != "reject" {
+		t.Fatalf("expected reject error, got %v", err)
+	}
+
+	// handled, not redelivered
+	results := HandleBatch(h, []*BatchMessage{
+		{ID: "1", Body: receivedWithVerdicts(t, "PASS", "FAIL", "")},
+		{ID: "2", Body: []byte("{")},
+	}, 2)
+	if !errors.As(results[0].Err, &quarantineErr) || !reflect.DeepEqual(BatchItemFailures(results), []string{"2"}) {
+		t.Fatalf("unexpected batch results %v %v", results[0].Err, results[1].Err)
+	}
+
+	dispatched := false
+	d := NewDispatcher(h)
+	d.OnReceived(func(mail *handler.MailReceived) error {
+		dispatched = true
+		return nil
+	})
+	if _, err := d.HandleSmtp(receivedWithVerdicts(t, "PASS", "FAIL", "")); !errors.As(err, &quarantineErr) || dispatched {
+		t.Fatalf("quarantined message shouldn't be dispatched, got %v", err)
+	}
+}
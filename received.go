@@ -0,0 +1,283 @@
+package awshandler
+
+import (
+	"net"
+	"net/mail"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/igorrendulic/couchdb-experiment/email/mime/handler"
+)
+
+// name of header fields parsed by ParseReceivedChain
+const headerReceived = "Received"
+
+// IP addresses in Received comments: [192.0.2.1], [IPv6:2001:db8::1] or bare (192.0.2.1)
+var receivedIPPattern = regexp.MustCompile(`\[(?:IPv6:)?([0-9a-fA-F:.]+)\]|(?:^|[\s(])([0-9]{1,3}(?:\.[0-9]{1,3}){3}|[0-9a-fA-F]{0,4}(?::[0-9a-fA-F]{0,4}){2,7})(?:$|[\s)\]:])`)
+
+// HELO name in comments of Exim (helo=name) and qmail (HELO name)
+var receivedHeloPattern = regexp.MustCompile(`(?i)\bhelo[=\s]+(\[[^\]]*\]|[^\s)]+)`)
+
+// date layouts seen in Received headers which net/mail doesn't parse
+var receivedDateLayouts = []string{
+	"Mon, 2 Jan 2006 15:04:05 -0700 MST",
+	"Mon, 2 Jan 2006 15:04:05 MST",
+	"2 Jan 2006 15:04:05 MST",
+	"Mon Jan 2 15:04:05 2006",
+	"Mon, 2 Jan 2006 15:04",
+	time.RFC3339,
+}
+
+// Hop is a single Received header field: the server identified by By received the message From a client
+type Hop struct {
+	From      string     `json:"from,omitempty"`     // name the client introduced itself with (HELO/EHLO)
+	FromHost  string     `json:"fromHost,omitempty"` // reverse DNS name of the client as recorded by the server
+	FromIP    net.IP     `json:"fromIp,omitempty"`
+	By        string     `json:"by,omitempty"`
+	With      string     `json:"with,omitempty"` // protocol, e.g. ESMTPS
+	ID        string     `json:"id,omitempty"`
+	For       string     `json:"for,omitempty"`
+	Timestamp *time.Time `json:"timestamp,omitempty"` // nil if the date couldn't be parsed
+	Raw       string     `json:"-"`
+}
+
+// ReceivedTrace is the path of a message reconstructed from Received header fields.
+// Trace of Received notifications is Exchange.Trace (Process and middleware),
+// ReceivedTraceOf parses it from handler.MailReceived returned by HandleSmtp and the adapters.
+type ReceivedTrace struct {
+	Hops       []*Hop        `json:"hops"`                 // oldest first
+	OriginIP   net.IP        `json:"originIp,omitempty"`   // client IP of the oldest hop with a public address, see ParseReceivedChain
+	OriginHelo string        `json:"originHelo,omitempty"` // HELO name of that hop
+	Latency    time.Duration `json:"latency"`              // between the oldest and newest hop timestamps
+}
+
+// ParseReceivedChain builds trace from values of Received header fields in order of appearance (newest first).
+// Hops without a parsable clause are skipped.
+//
+// The origin is the client of the oldest hop with a public address, skipping hops whose client is the server of an
+// earlier hop: such clients are relays of the sender (e.g. Exchange Online mailbox servers after MAPI submission)
+// and the next hop is where the message left the sender's network. Without such a hop the oldest relay with a public
+// address or the oldest hop with any address is used.
+func ParseReceivedChain(values []string) *ReceivedTrace {
+	trace := &ReceivedTrace{Hops: []*Hop{}}
+	for i := len(values) - 1; i >= 0; i-- {
+		if hop := ParseReceived(values[i]); hop != nil {
+			trace.Hops = append(trace.Hops, hop)
+		}
+	}
+
+	var first, last *time.Time
+	var origin, relay, fallback *Hop
+	servers := map[string]bool{}
+	for _, hop := range trace.Hops {
+		if hop.FromIP != nil {
+			relayed := servers[strings.ToLower(hop.From)] || servers[strings.ToLower(hop.FromHost)]
+			switch {
+			case origin == nil && !relayed && isPublicIP(hop.FromIP):
+				origin = hop
+			case relay == nil && isPublicIP(hop.FromIP):
+				relay = hop
+			}
+			if fallback == nil {
+				fallback = hop
+			}
+		}
+		if hop.By != "" {
+			servers[strings.ToLower(hop.By)] = true
+		}
+		if hop.Timestamp == nil {
+			continue
+		}
+		if first == nil || hop.Timestamp.Before(*first) {
+			first = hop.Timestamp
+		}
+		if last == nil || hop.Timestamp.After(*last) {
+			last = hop.Timestamp
+		}
+	}
+	for _, hop := range []*Hop{origin, relay, fallback} {
+		if hop != nil {
+			trace.OriginIP, trace.OriginHelo = hop.FromIP, hop.From
+			break
+		}
+	}
+	if first != nil {
+		trace.Latency = last.Sub(*first)
+	}
+	return trace
+}
+
+// ParseReceived parses a single Received header field value, nil if it has neither from nor by clause
+func ParseReceived(value string) *Hop {
+	hop := &Hop{Raw: value}
+
+	clauses, date := value, ""
+	if semicolon := strings.LastIndex(value, ";"); semicolon >= 0 {
+		clauses, date = value[:semicolon], value[semicolon+1:]
+	}
+	if t := parseReceivedDate(date); !t.IsZero() {
+		hop.Timestamp = &t
+	}
+
+	var clause *string
+	fromComments := []string{}
+	for _, word := range receivedWords(clauses) {
+		if strings.HasPrefix(word, "(") {
+			if clause == &hop.From {
+				fromComments = append(fromComments, word)
+			}
+			continue
+		}
+		switch strings.ToLower(word) {
+		case "from":
+			clause = &hop.From
+			continue
+		case "by":
+			clause = &hop.By
+			continue
+		case "with":
+			clause = &hop.With
+			continue
+		case "id":
+			clause = &hop.ID
+			continue
+		case "for":
+			clause = &hop.For
+			continue
+		case "via":
+			clause = nil
+			continue
+		}
+		if clause != nil && *clause == "" {
+			*clause = strings.Trim(word, "<>")
+		}
+	}
+	if hop.From == "" && hop.By == "" {
+		return nil
+	}
+
+	hop.From, hop.FromHost, hop.FromIP = parseReceivedFrom(hop.From, fromComments)
+	hop.By = strings.TrimSuffix(hop.By, ".")
+	return hop
+}
+
+// HELO name, reverse DNS host and IP of from clause and its comments
+func parseReceivedFrom(from string, comments []string) (string, string, net.IP) {
+	helo, host := strings.TrimSuffix(from, "."), ""
+	var ip net.IP
+
+	for _, comment := range comments {
+		inner := strings.TrimSpace(strings.TrimSuffix(strings.TrimPrefix(comment, "("), ")"))
+		// Exim writes the address as the from word and the HELO name in the comment: from [192.0.2.1] (helo=client.example)
+		if match := receivedHeloPattern.FindStringSubmatch(inner); match != nil {
+			if helo == "" || strings.EqualFold(helo, "unknown") || strings.HasPrefix(helo, "[") {
+				helo = strings.TrimSuffix(match[1], ".")
+			}
+			inner = receivedHeloPattern.ReplaceAllString(inner, "")
+		}
+		if ip == nil {
+			if match := receivedIPPattern.FindStringSubmatch(inner); match != nil {
+				candidate := match[1]
+				if candidate == "" {
+					candidate = match[2]
+				}
+				ip = net.ParseIP(candidate)
+			}
+		}
+		// (host.example [192.0.2.1]), (host.example. [192.0.2.1]) or (IDENT:user@host.example [192.0.2.1])
+		if fields := strings.Fields(inner); host == "" && len(fields) > 0 {
+			name := fields[0]
+			if at := strings.LastIndex(name, "@"); at >= 0 {
+				name = name[at+1:]
+			}
+			if strings.Contains(name, ".") && !strings.ContainsAny(name, "=[]()") && net.ParseIP(name) == nil {
+				host = strings.TrimSuffix(name, ".")
+			}
+		}
+	}
+	// address literal as the from word is the client address only if the server recorded none
+	if literal := strings.TrimPrefix(strings.Trim(from, "[]"), "IPv6:"); ip == nil {
+		ip = net.ParseIP(literal)
+	}
+	return helo, host, ip
+}
+
+// words and parenthesized comments (kept as single words including nested parentheses)
+func receivedWords(s string) []string {
+	words := []string{}
+	for i := 0; i < len(s); {
+		switch c := s[i]; {
+		case c == ' ' || c == '\t' || c == '\r' || c == '\n':
+			i++
+		case c == '(':
+			depth, start := 0, i
+			for ; i < len(s); i++ {
+				if s[i] == '(' {
+					depth++
+				} else if s[i] == ')' {
+					depth--
+					if depth == 0 {
+						i++
+						break
+					}
+				}
+			}
+			words = append(words, s[start:i])
+		default:
+			start := i
+			for i < len(s) && !strings.ContainsRune(" \t\r\n(", rune(s[i])) {
+				i++
+			}
+			words = append(words, s[start:i])
+		}
+	}
+	return words
+}
+
+func parseReceivedDate(date string) time.Time {
+	date = strings.Join(strings.Fields(date), " ")
+	if date == "" {
+		return time.Time{}
+	}
+	if t, err := mail.ParseDate(date); err == nil {
+		return t
+	}
+	// trailing comments such as (PDT) or (envelope-from ...)
+	if i := strings.Index(date, "("); i > 0 {
+		if t, err := mail.ParseDate(strings.TrimSpace(date[:i])); err == nil {
+			return t
+		}
+		date = strings.TrimSpace(date[:i])
+	}
+	for _, layout := range receivedDateLayouts {
+		if t, err := time.Parse(layout, date); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+func isPublicIP(ip net.IP) bool {
+	return ip.IsGlobalUnicast() && !ip.IsPrivate()
+}
+
+// ReceivedTraceOf parses Received headers of MIME content of a Received notification as handled by HandleSmtp,
+// Dispatcher, HandleBatch, LambdaHandler and bulk ingestion. Nil if the mail has no MIME content or Received headers.
+func ReceivedTraceOf(mail *handler.MailReceived) *ReceivedTrace {
+	return parseReceivedHeaders(mimeHeaders(mail))
+}
+
+// parsing Received headers of the notification
+func parseReceivedHeaders(headers []*HeaderAttribute) *ReceivedTrace {
+	values := []string{}
+	for _, h := range headers {
+		if strings.EqualFold(h.Name, headerReceived) {
+			values = append(values, h.Value)
+		}
+	}
+	if len(values) == 0 {
+		return nil
+	}
+	return ParseReceivedChain(values)
+}
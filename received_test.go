@@ -0,0 +1,116 @@
+package awshandler
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/igorrendulic/couchdb-email-aws-parse/awstest"
+	"github.com/igorrendulic/couchdb-experiment/email/mime/handler"
+)
+
+func TestParseReceived(t *testing.T) {
+	hop := ParseReceived("from mail.example.org (mail.example.org [192.0.2.1]) by mx.example.com (Postfix) with ESMTPS id 4PbXyZ for <user@example.com>; Tue, 14 Mar 2023 10:00:00 +0000 (UTC)")
+	if hop == nil || hop.From != "mail.example.org" || hop.FromHost != "mail.example.org" || hop.FromIP.String() != "192.0.2.1" ||
+		hop.By != "mx.example.com" || hop.With != "ESMTPS" || hop.ID != "4PbXyZ" || hop.For != "user@example.com" ||
+		hop.Timestamp == nil || !hop.Timestamp.Equal(time.Date(2023, 3, 14, 10, 0, 0, 0, time.UTC)) {
+		t.Fatalf("unexpected hop %+v", hop)
+	}
+
+	hop = ParseReceived("from [192.0.2.44] (helo=client.example.org) by relay.example.com with esmtpsa; garbage date")
+	if hop.From != "client.example.org" || hop.FromIP.String() != "192.0.2.44" || hop.Timestamp != nil {
+		t.Fatalf("unexpected exim hop %+v", hop)
+	}
+	if b, _ := json.Marshal(hop); strings.Contains(string(b), "timestamp") {
+		t.Fatalf("unparsable date should be omitted: %s", b)
+	}
+
+	// address literal as HELO name, client address in comment
+	hop = ParseReceived("from [192.168.1.23] ([2a02:8108:1140:a600::1f2a]) by smtp.gmail.com with ESMTPSA id x")
+	if hop.From != "[192.168.1.23]" || hop.FromIP.String() != "2a02:8108:1140:a600::1f2a" {
+		t.Fatalf("unexpected literal hop %+v", hop)
+	}
+
+	for _, invalid := range []string{"", "(qmail 12345 invoked by uid 89); 18 Mar 2023 09:14:58 -0000", "not a valid received header"} {
+		if hop := ParseReceived(invalid); hop != nil {
+			t.Errorf("%q: expected no hop, got %+v", invalid, hop)
+		}
+	}
+}
+
+func TestParseReceivedChainFixtures(t *testing.T) {
+	fixtures, err := filepath.Glob("test_data/received/*.eml")
+	if err != nil || len(fixtures) == 0 {
+		t.Fatalf("no fixtures: %v", err)
+	}
+	for _, fixture := range fixtures {
+		name := strings.TrimSuffix(filepath.Base(fixture), ".eml")
+		t.Run(name, func(t *testing.T) {
+			f, err := os.Open(fixture)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			header, err := textproto.NewReader(bufio.NewReader(f)).ReadMIMEHeader()
+			if err != nil {
+				t.Fatal(err)
+			}
+			trace := ParseReceivedChain(header.Values(headerReceived))
+			if trace.OriginIP == nil || len(trace.Hops) == 0 {
+				t.Fatalf("no origin in trace %+v", trace)
+			}
+			awstest.GoldenJSON(t, filepath.Join("test_data/golden/received", name+".json"), trace)
+		})
+	}
+}
+
+func TestReceivedTrace(t *testing.T) {
+	received, err := LoadPayload("test_data/received.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	svc, _, _ := dlLoggingSvc([]byte("mime"))
+	ex, err := newAwsSmtpHandler(svc).Process(received)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ex.Trace == nil || len(ex.Trace.Hops) != 2 {
+		t.Fatalf("unexpected trace %+v", ex.Trace)
+	}
+	// Gmail's first hop has no from clause and its server relays to SES, which is the only hop with an address
+	if ex.Trace.OriginIP.String() != "209.85.128.47" || ex.Trace.OriginHelo != "mail-wm1-f47.google.com" || ex.Trace.Latency != 0 {
+		t.Fatalf("unexpected origin %+v", ex.Trace)
+	}
+	if ex.Trace.Hops[0].By != "mail-wm1-f47.google.com" || ex.Trace.Hops[1].By != "inbound-smtp.us-west-2.amazonaws.com" {
+		t.Fatalf("unexpected hop order %+v %+v", ex.Trace.Hops[0], ex.Trace.Hops[1])
+	}
+}
+
+func TestReceivedTraceOf(t *testing.T) {
+	received, err := LoadPayload("test_data/received.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mime, err := os.ReadFile("test_data/received/outlook.eml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	svc, _, _ := dlLoggingSvc(mime)
+
+	results := HandleBatch(NewAwsSmtpHandler(svc), []*BatchMessage{{ID: "1", Body: received}}, 1)
+	if results[0].Err != nil {
+		t.Fatal(results[0].Err)
+	}
+	trace := ReceivedTraceOf(results[0].Mail)
+	if trace == nil || len(trace.Hops) != 3 || trace.OriginIP.String() != "40.107.22.71" {
+		t.Fatalf("unexpected trace %+v", trace)
+	}
+	if trace := ReceivedTraceOf(&handler.MailReceived{NotificationType: "Bounce"}); trace != nil {
+		t.Fatalf("expected no trace without mime content, got %+v", trace)
+	}
+}
@@ -0,0 +1,235 @@
+package rules
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	awshandler "github.com/igorrendulic/couchdb-email-aws-parse"
+	"github.com/igorrendulic/couchdb-experiment/email/mime/handler"
+)
+
+// AnnotationDecision is the annotation key of *Decision set by Engine.Middleware
+const AnnotationDecision = "rules.decision"
+
+// default timeout of webhook requests
+const defaultWebhookTimeout = 10 * time.Second
+
+// ActionHandler executes store or quarantine actions of matched rules
+type ActionHandler func(ctx context.Context, action *Action, ex *awshandler.Exchange) error
+
+// Decision is the outcome of evaluating rules for a message
+type Decision struct {
+	Rules             []string  // names of matched rules in order of evaluation
+	Actions           []*Action // actions of matched rules in order
+	Tags              []string
+	Drop              bool
+	Quarantine        bool
+	QuarantineReasons []string
+
+	actionRules []string // name of the rule of each action
+}
+
+// Engine evaluates rules in order after mapping of Received notifications
+type Engine struct {
+	rules      []*compiledRule
+	handlers   map[ActionType]ActionHandler
+	httpClient *http.Client
+}
+
+// NewEngine compiles rules, invalid patterns or actions are an error
+func NewEngine(set *RuleSet) (*Engine, error) {
+	e := &Engine{
+		handlers:   map[ActionType]ActionHandler{},
+		httpClient: &http.Client{Timeout: defaultWebhookTimeout},
+	}
+	names := map[string]bool{}
+	for _, rule := range set.Rules {
+		compiled, err := compile(rule)
+		if err != nil {
+			return nil, err
+		}
+		if names[rule.Name] {
+			return nil, fmt.Errorf("duplicate rule %s", rule.Name)
+		}
+		names[rule.Name] = true
+		e.rules = append(e.rules, compiled)
+	}
+	return e, nil
+}
+
+// Handle registers handler of store or quarantine actions. Store actions without a handler fail handling,
+// quarantine without a handler only isolates the message (see Execute).
+func (e *Engine) Handle(actionType ActionType, fn ActionHandler) {
+	e.handlers[actionType] = fn
+}
+
+// SetHTTPClient sets client of webhook requests
+func (e *Engine) SetHTTPClient(client *http.Client) {
+	e.httpClient = client
+}
+
+// Evaluate matches rules against the message without executing actions
+func (e *Engine) Evaluate(m *Message) *Decision {
+	decision := &Decision{}
+	for _, rule := range e.rules {
+		if !rule.matches(m) {
+			continue
+		}
+		decision.Rules = append(decision.Rules, rule.Name)
+		for _, action := range rule.Actions {
+			decision.Actions = append(decision.Actions, action)
+			decision.actionRules = append(decision.actionRules, rule.Name)
+			switch action.Type {
+			case ActionDrop:
+				decision.Drop = true
+			case ActionTag:
+				decision.Tags = appendUnique(decision.Tags, action.Value)
+			case ActionQuarantine:
+				decision.Quarantine = true
+				decision.QuarantineReasons = append(decision.QuarantineReasons, rule.Name+": "+action.Value)
+			}
+		}
+		if rule.Stop {
+			break
+		}
+	}
+	return decision
+}
+
+// Execute runs webhook, store and quarantine actions of the decision, returns awshandler.ErrSkip if the message was dropped.
+// Quarantined messages are isolated before running actions the same way as by awshandler.QuarantinePolicy:
+// Exchange.Quarantine is set and RawMime moved there from the output.
+//
+// Actions are executed at least once: a failing action (e.g. retryable WebhookError) fails handling and the redelivered
+// message runs all actions again, including those that already succeeded. Webhooks receive IdempotencyKey to
+// deduplicate, store and quarantine handlers should be idempotent.
+func (e *Engine) Execute(ctx context.Context, decision *Decision, ex *awshandler.Exchange) error {
+	if decision.Quarantine {
+		quarantine(ex, decision.QuarantineReasons)
+	}
+	for i, action := range decision.Actions {
+		var err error
+		switch action.Type {
+		case ActionWebhook:
+			var rule string
+			if i < len(decision.actionRules) {
+				rule = decision.actionRules[i]
+			}
+			err = e.webhook(ctx, action, rule, decision, ex.Output)
+		case ActionStore, ActionQuarantine:
+			fn, ok := e.handlers[action.Type]
+			if !ok && action.Type == ActionStore {
+				err = fmt.Errorf("no handler for store action (target %s)", action.Value)
+			} else if ok {
+				err = fn(ctx, action, ex)
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if decision.Drop {
+		return awshandler.ErrSkip
+	}
+	return nil
+}
+
+// Middleware evaluates rules and executes actions for Received notifications at output stage,
+// the decision is annotated as AnnotationDecision
+func (e *Engine) Middleware() awshandler.Middleware {
+	return func(stage awshandler.Stage, ex *awshandler.Exchange) error {
+		if stage != awshandler.StageOutput || ex.Output == nil || ex.Output.NotificationType != string(awshandler.NotificationTypeReceived) {
+			return nil
+		}
+		decision := e.Evaluate(NewMessage(ex))
+		ex.Annotate(AnnotationDecision, decision)
+		return e.Execute(ex.Context(), decision, ex)
+	}
+}
+
+// isolating MIME content of the output, rejected messages stay rejected
+func quarantine(ex *awshandler.Exchange, reasons []string) {
+	if ex.Quarantine == nil {
+		ex.Quarantine = &awshandler.Quarantine{Action: awshandler.VerdictQuarantine}
+	}
+	ex.Quarantine.Reasons = append(ex.Quarantine.Reasons, reasons...)
+	if ex.Output != nil && ex.Output.Mail != nil && ex.Output.Mail.RawMime != nil {
+		ex.Quarantine.RawMime = ex.Output.Mail.RawMime
+		ex.Output.Mail.RawMime = nil
+	}
+}
+
+// IdempotencyHeader is the request header of webhook actions carrying WebhookPayload.IdempotencyKey
+const IdempotencyHeader = "Idempotency-Key"
+
+// WebhookPayload is the JSON body posted by webhook actions
+type WebhookPayload struct {
+	IdempotencyKey string                `json:"idempotencyKey"` // SES messageId and rule name, same on redelivery
+	Rules          []string              `json:"rules"`
+	Tags           []string              `json:"tags,omitempty"`
+	Mail           *handler.MailReceived `json:"mail"`
+}
+
+// WebhookError is returned when webhook request failed or didn't respond with 2xx status
+type WebhookError struct {
+	URL        string
+	StatusCode int // 0 if request failed
+	Err        error
+}
+
+func (e *WebhookError) Error() string {
+	if e.StatusCode != 0 {
+		return fmt.Sprintf("webhook %s responded with status %d", e.URL, e.StatusCode)
+	}
+	return fmt.Sprintf("webhook %s failed: %v", e.URL, e.Err)
+}
+
+func (e *WebhookError) Unwrap() error {
+	return e.Err
+}
+
+// Retryable if request failed, the server failed or rate limited
+func (e *WebhookError) Retryable() bool {
+	return e.StatusCode == 0 || e.StatusCode >= 500 || e.StatusCode == http.StatusTooManyRequests
+}
+
+func (e *Engine) webhook(ctx context.Context, action *Action, rule string, decision *Decision, mail *handler.MailReceived) error {
+	var messageID string
+	if mail != nil && mail.Mail != nil {
+		messageID = mail.Mail.MessageID
+	}
+	key := messageID + ":" + rule
+	body, err := json.Marshal(&WebhookPayload{IdempotencyKey: key, Rules: decision.Rules, Tags: decision.Tags, Mail: mail})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, action.URL, bytes.NewReader(body))
+	if err != nil {
+		return &WebhookError{URL: action.URL, Err: err}
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(IdempotencyHeader, key)
+
+	resp, err := e.httpClient.Do(req)
+	if err != nil {
+		return &WebhookError{URL: action.URL, Err: err}
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return &WebhookError{URL: action.URL, StatusCode: resp.StatusCode}
+	}
+	return nil
+}
+
+func appendUnique(values []string, value string) []string {
+	for _, v := range values {
+		if v == value {
+			return values
+		}
+	}
+	return append(values, value)
+}
@@ -0,0 +1,272 @@
+package rules
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	awshandler "github.com/igorrendulic/couchdb-email-aws-parse"
+	"github.com/igorrendulic/couchdb-experiment/email/mime/handler"
+)
+
+const testMime = "From: Billing <billing@vendor.example>\r\n" +
+	"To: billing@example.com\r\n" +
+	"Subject: Invoice 42\r\n" +
+	"X-Priority: 1\r\n" +
+	"Content-Type: multipart/mixed; boundary=b1\r\n" +
+	"\r\n" +
+	"--b1\r\n" +
+	"Content-Type: text/plain\r\n" +
+	"\r\n" +
+	"see attached\r\n" +
+	"--b1\r\n" +
+	"Content-Type: application/pdf; name=invoice.pdf\r\n" +
+	"Content-Disposition: attachment; filename=invoice.pdf\r\n" +
+	"\r\n" +
+	"%PDF\r\n" +
+	"--b1--\r\n"
+
+const testRules = `
+rules:
+  - name: drop-viruses
+    match:
+      virus: [FAIL]
+    actions:
+      - type: drop
+  - name: invoices
+    match:
+      recipients: ["BILLING@example.com", "*@invoices.example.com"]
+      sender: ["*@vendor.example"]
+      subject: "(?i)invoice"
+      headers:
+        x-priority: "^1$"
+      attachmentTypes: ["application/*"]
+    actions:
+      - type: tag
+        value: invoice
+      - type: webhook
+        url: WEBHOOK
+      - type: store
+        value: invoices
+  - name: unauthenticated
+    match:
+      dmarc: [FAIL]
+    actions:
+      - type: quarantine
+        value: dmarc
+    stop: true
+  - name: never-reached
+    match: {}
+    actions:
+      - type: tag
+        value: other
+`
+
+func newExchange(virus string, dmarc string) *awshandler.Exchange {
+	return &awshandler.Exchange{
+		Output: &handler.MailReceived{
+			NotificationType: "Received",
+			Mail: &handler.Mail{
+				MessageID:     "abc",
+				Source:        "bounces@vendor.example",
+				Destination:   []string{"billing@example.com"},
+				RawMime:       []byte(testMime),
+				CommonHeaders: &handler.CommonHeaders{Subject: "Invoice 42", From: []string{"Billing <billing@vendor.example>"}},
+			},
+			Receipt: &handler.Receipt{
+				SpamVerdict:  &handler.VerdictStatus{Status: "PASS"},
+				VirusVerdict: &handler.VerdictStatus{Status: virus},
+			},
+		},
+		Message: &awshandler.MessageJSON{
+			Receipt: &awshandler.Receipt{DmarcVerdict: &awshandler.VerdictStatus{Status: dmarc}},
+		},
+		Annotations: map[string]interface{}{},
+	}
+}
+
+func TestEngine(t *testing.T) {
+	var payload WebhookPayload
+	var idempotencyKey string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		idempotencyKey = r.Header.Get(IdempotencyHeader)
+		json.NewDecoder(r.Body).Decode(&payload)
+	}))
+	defer server.Close()
+
+	set, err := ParseRules([]byte(strings.Replace(testRules, "WEBHOOK", server.URL, 1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	engine, err := NewEngine(set)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored := []string{}
+	engine.Handle(ActionStore, func(ctx context.Context, action *Action, ex *awshandler.Exchange) error {
+		if ex.Quarantine != nil {
+			return nil
+		}
+		stored = append(stored, action.Value+":"+ex.Output.Mail.MessageID)
+		return nil
+	})
+
+	ex := newExchange("PASS", "FAIL")
+	if err := engine.Middleware()(awshandler.StageOutput, ex); err != nil {
+		t.Fatal(err)
+	}
+	decision := ex.Annotations[AnnotationDecision].(*Decision)
+	if !reflect.DeepEqual(decision.Rules, []string{"invoices", "unauthenticated"}) || !reflect.DeepEqual(decision.Tags, []string{"invoice"}) {
+		t.Fatalf("unexpected decision %+v", decision)
+	}
+	if !decision.Quarantine || decision.Drop || !reflect.DeepEqual(decision.QuarantineReasons, []string{"unauthenticated: dmarc"}) {
+		t.Fatalf("unexpected decision %+v", decision)
+	}
+	if q := ex.Quarantine; q == nil || q.Action != awshandler.VerdictQuarantine || string(q.RawMime) != testMime || ex.Output.Mail.RawMime != nil {
+		t.Fatalf("expected quarantined message, got %+v", q)
+	}
+	if len(stored) != 0 {
+		t.Fatalf("quarantined message shouldn't be stored, got %v", stored)
+	}
+	if payload.Mail == nil || payload.Mail.Mail.MessageID != "abc" || payload.Mail.Mail.RawMime != nil || !reflect.DeepEqual(payload.Rules, decision.Rules) {
+		t.Fatalf("unexpected webhook payload %+v", payload)
+	}
+	if payload.IdempotencyKey != "abc:invoices" || idempotencyKey != payload.IdempotencyKey {
+		t.Fatalf("unexpected idempotency key %q (header %q)", payload.IdempotencyKey, idempotencyKey)
+	}
+
+	ex = newExchange("PASS", "PASS")
+	if err := engine.Middleware()(awshandler.StageOutput, ex); err != nil {
+		t.Fatal(err)
+	}
+	if ex.Quarantine != nil || !reflect.DeepEqual(stored, []string{"invoices:abc"}) {
+		t.Fatalf("unexpected stored %v", stored)
+	}
+
+	// rejected by the handler's QuarantinePolicy
+	ex = newExchange("PASS", "FAIL")
+	ex.Output.Mail.RawMime = nil
+	ex.Quarantine = &awshandler.Quarantine{Action: awshandler.VerdictReject, Reasons: []string{"dmarc FAIL"}}
+	if err := engine.Middleware()(awshandler.StageOutput, ex); err != nil {
+		t.Fatal(err)
+	}
+	if q := ex.Quarantine; q.Action != awshandler.VerdictReject || !reflect.DeepEqual(q.Reasons, []string{"dmarc FAIL", "unauthenticated: dmarc"}) || q.RawMime != nil {
+		t.Fatalf("rejected message shouldn't be downgraded, got %+v", q)
+	}
+
+	ex = newExchange("FAIL", "PASS")
+	if err := engine.Middleware()(awshandler.StageOutput, ex); !errors.Is(err, awshandler.ErrSkip) {
+		t.Fatalf("expected drop, got %v", err)
+	}
+
+	// exchange not created by the handler
+	ex = newExchange("FAIL", "PASS")
+	ex.Annotations = nil
+	if err := engine.Middleware()(awshandler.StageOutput, ex); !errors.Is(err, awshandler.ErrSkip) || ex.Annotations[AnnotationDecision] == nil {
+		t.Fatalf("expected annotated drop, got %v", err)
+	}
+}
+
+func TestNewMessage(t *testing.T) {
+	m := NewMessage(newExchange("PASS", "PASS"))
+	if !reflect.DeepEqual(m.AttachmentTypes, []string{"application/pdf"}) {
+		t.Fatalf("unexpected attachment types %v", m.AttachmentTypes)
+	}
+	if !reflect.DeepEqual(m.Senders, []string{"bounces@vendor.example", "billing@vendor.example"}) {
+		t.Fatalf("unexpected senders %v", m.Senders)
+	}
+	if m.Headers.Get("X-Priority") != "1" || m.Dmarc != "PASS" || m.Virus != "PASS" {
+		t.Fatalf("unexpected message %+v", m)
+	}
+}
+
+func TestWebhookError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	engine, err := NewEngine(&RuleSet{Rules: []*Rule{{Name: "all", Actions: []*Action{{Type: ActionWebhook, URL: server.URL}}}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = engine.Middleware()(awshandler.StageOutput, newExchange("PASS", "PASS"))
+	var webhookErr *WebhookError
+	if !errors.As(err, &webhookErr) || webhookErr.StatusCode != 503 || !awshandler.IsRetryable(err) {
+		t.Fatalf("expected retryable webhook error, got %v", err)
+	}
+}
+
+func TestInvalidRules(t *testing.T) {
+	for _, rules := range []string{
+		`{"rules": [{"name": "a", "actions": []}]}`,
+		`{"rules": [{"actions": [{"type": "drop"}]}]}`,
+		`{"rules": [{"name": "a", "match": {"subject": "("}, "actions": [{"type": "drop"}]}]}`,
+		`{"rules": [{"name": "a", "actions": [{"type": "tag"}]}]}`,
+		`{"rules": [{"name": "a", "actions": [{"type": "webhook", "url": "ftp://x"}]}]}`,
+		`{"rules": [{"name": "a", "actions": [{"type": "explode"}]}]}`,
+		`{"rules": [{"name": "a", "actions": [{"type": "drop"}]}, {"name": "a", "actions": [{"type": "drop"}]}]}`,
+	} {
+		set, err := ParseRules([]byte(rules))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := NewEngine(set); err == nil {
+			t.Errorf("expected error for %s", rules)
+		}
+	}
+}
+
+func TestWildcardMatch(t *testing.T) {
+	for pattern, expected := range map[string]bool{
+		"*@example.com":   true,
+		"john@*":          true,
+		"j*n@*.com":       true,
+		"*@example.org":   false,
+		"john@example.co": false,
+	} {
+		if wildcardMatch(pattern, "john@example.com") != expected {
+			t.Errorf("%s: expected %v", pattern, expected)
+		}
+	}
+}
+
+func TestAutoReplyMatch(t *testing.T) {
+	set, err := ParseRules([]byte("rules:\n  - name: no-autoreplies\n    match:\n      autoReply: [vacation, auto-reply]\n    actions:\n      - type: drop\n  - name: people\n    match:\n      autoReply: [none]\n    actions:\n      - type: tag\n        value: person\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	engine, err := NewEngine(set)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if decision := engine.Evaluate(NewMessage(newExchange("PASS", "PASS"))); !reflect.DeepEqual(decision.Tags, []string{"person"}) {
+		t.Fatalf("unexpected decision %+v", decision)
+	}
+
+	// classified by the handler
+	ex := newExchange("PASS", "PASS")
+	ex.AutoReply = &awshandler.AutoReply{Kind: awshandler.AutoReplyVacation}
+	if decision := engine.Evaluate(NewMessage(ex)); !decision.Drop {
+		t.Fatalf("expected drop, got %+v", decision)
+	}
+
+	// classified from MIME headers
+	ex = newExchange("PASS", "PASS")
+	ex.Output.Mail.RawMime = []byte("Auto-Submitted: auto-replied\r\nSubject: Invoice 42\r\n\r\nthanks\r\n")
+	if decision := engine.Evaluate(NewMessage(ex)); !decision.Drop {
+		t.Fatalf("expected drop, got %+v", decision)
+	}
+	// mailing list isn't sent by a person
+	ex = newExchange("PASS", "PASS")
+	ex.Output.Mail.RawMime = []byte("Precedence: list\r\nSubject: Weekly digest\r\n\r\nnews\r\n")
+	if decision := engine.Evaluate(NewMessage(ex)); decision.Drop || len(decision.Tags) != 0 {
+		t.Fatalf("unexpected decision for list mail %+v", decision)
+	}
+}
@@ -0,0 +1,106 @@
+package rules
+
+import (
+	"net/textproto"
+	"path"
+	"strings"
+)
+
+func (r *compiledRule) matches(m *Message) bool {
+	match := r.Match
+	if len(match.Recipients) > 0 && !anyAddress(match.Recipients, m.Recipients) {
+		return false
+	}
+	if len(match.Sender) > 0 && !anyAddress(match.Sender, m.Senders) {
+		return false
+	}
+	if r.subject != nil && !r.subject.MatchString(m.Subject) {
+		return false
+	}
+	for name, pattern := range r.headers {
+		matched := false
+		for _, value := range m.Headers[textproto.CanonicalMIMEHeaderKey(name)] {
+			if pattern.MatchString(value) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	verdicts := []struct {
+		allowed []string
+		status  string
+	}{
+		{match.Spam, m.Spam},
+		{match.Virus, m.Virus},
+		{match.Spf, m.Spf},
+		{match.Dkim, m.Dkim},
+		{match.Dmarc, m.Dmarc},
+		{match.AutoReply, m.AutoReply},
+	}
+	for _, v := range verdicts {
+		if len(v.allowed) > 0 && !anyVerdict(v.allowed, v.status) {
+			return false
+		}
+	}
+	if len(match.AttachmentTypes) > 0 && !anyMediaType(match.AttachmentTypes, m.AttachmentTypes) {
+		return false
+	}
+	return true
+}
+
+// any address matching any of case-insensitive patterns with * wildcard
+func anyAddress(patterns []string, addresses []string) bool {
+	for _, address := range addresses {
+		address = strings.ToLower(strings.TrimSpace(address))
+		for _, pattern := range patterns {
+			if wildcardMatch(strings.ToLower(pattern), address) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func anyVerdict(allowed []string, status string) bool {
+	for _, a := range allowed {
+		if strings.EqualFold(a, status) {
+			return true
+		}
+	}
+	return false
+}
+
+// any media type matching patterns such as image/* (path.Match treats / as separator so * doesn't cross it)
+func anyMediaType(patterns []string, mediaTypes []string) bool {
+	for _, mediaType := range mediaTypes {
+		for _, pattern := range patterns {
+			if ok, _ := path.Match(strings.ToLower(pattern), strings.ToLower(mediaType)); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// matching s against pattern where * matches any sequence of characters
+func wildcardMatch(pattern string, s string) bool {
+	parts := strings.Split(pattern, "*")
+	if len(parts) == 1 {
+		return pattern == s
+	}
+	if !strings.HasPrefix(s, parts[0]) {
+		return false
+	}
+	s = s[len(parts[0]):]
+	for _, part := range parts[1 : len(parts)-1] {
+		i := strings.Index(s, part)
+		if i < 0 {
+			return false
+		}
+		s = s[i+len(part):]
+	}
+	return strings.HasSuffix(s, parts[len(parts)-1])
+}
@@ -0,0 +1,138 @@
+package rules
+
+import (
+	"bytes"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"net/textproto"
+	"strings"
+
+	awshandler "github.com/igorrendulic/couchdb-email-aws-parse"
+	"github.com/igorrendulic/couchdb-experiment/email/mime/handler"
+)
+
+// maximum depth of nested multipart parts inspected for attachment types
+const maxMimeDepth = 10
+
+// Message are the values rules are matched against
+type Message struct {
+	Recipients      []string
+	Senders         []string // envelope sender and From addresses
+	Subject         string
+	Headers         mail.Header
+	Spam            string
+	Virus           string
+	Spf             string
+	Dkim            string
+	Dmarc           string
+	AttachmentTypes []string // media types of attachments in downloaded MIME (empty without RawMime)
+	AutoReply       string   // awshandler.AutoReplyKind or AutoReplyNone
+}
+
+// AutoReplyNone is Message.AutoReply of messages sent by a person
+const AutoReplyNone = "none"
+
+// NewMessage collects values of a handled Received notification. Headers are parsed from downloaded MIME when
+// available (SES notification headers can be truncated), otherwise taken from the notification.
+func NewMessage(ex *awshandler.Exchange) *Message {
+	m := &Message{Headers: mail.Header{}}
+	out := ex.Output
+	if out == nil {
+		return m
+	}
+
+	if out.Mail != nil {
+		m.Recipients = out.Mail.Destination
+		if out.Mail.Source != "" {
+			m.Senders = append(m.Senders, out.Mail.Source)
+		}
+		if h := out.Mail.CommonHeaders; h != nil {
+			m.Subject = h.Subject
+			for _, from := range h.From {
+				if addr, err := mail.ParseAddress(from); err == nil {
+					m.Senders = append(m.Senders, addr.Address)
+				} else {
+					m.Senders = append(m.Senders, from)
+				}
+			}
+		}
+		if len(out.Mail.RawMime) > 0 {
+			if msg, err := mail.ReadMessage(bytes.NewReader(out.Mail.RawMime)); err == nil {
+				m.Headers = msg.Header
+				m.AttachmentTypes = attachmentTypes(msg.Header.Get("Content-Type"), msg.Header.Get("Content-Disposition"), msg.Body, 0)
+			}
+		}
+	}
+
+	if r := out.Receipt; r != nil {
+		if len(r.Recipients) > 0 {
+			m.Recipients = r.Recipients
+		}
+		m.Spam = verdict(r.SpamVerdict)
+		m.Virus = verdict(r.VirusVerdict)
+		m.Spf = verdict(r.SpfVerdict)
+		m.Dkim = verdict(r.DkimVerdict)
+	}
+
+	if msg := ex.Message; msg != nil {
+		if msg.Receipt != nil && msg.Receipt.DmarcVerdict != nil {
+			m.Dmarc = msg.Receipt.DmarcVerdict.Status
+		}
+		if len(m.Headers) == 0 && msg.Mail != nil {
+			for _, h := range msg.Mail.Headers {
+				name := textproto.CanonicalMIMEHeaderKey(h.Name)
+				m.Headers[name] = append(m.Headers[name], h.Value)
+			}
+		}
+	}
+
+	// classified by the handler from notification headers, exchanges built elsewhere are classified here
+	autoReply := ex.AutoReply
+	if autoReply == nil {
+		autoReply = awshandler.ClassifyAutoReply(m.Headers)
+	}
+	m.AutoReply = AutoReplyNone
+	if autoReply != nil {
+		m.AutoReply = string(autoReply.Kind)
+	}
+	return m
+}
+
+// attachmentTypes walks MIME parts and returns media types of parts with attachment disposition or file name
+func attachmentTypes(contentType string, disposition string, body io.Reader, depth int) []string {
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType = "text/plain"
+	}
+
+	if strings.HasPrefix(mediaType, "multipart/") && params["boundary"] != "" && depth < maxMimeDepth {
+		types := []string{}
+		reader := multipart.NewReader(body, params["boundary"])
+		for {
+			part, err := reader.NextPart()
+			if err != nil {
+				break
+			}
+			types = append(types, attachmentTypes(part.Header.Get("Content-Type"), part.Header.Get("Content-Disposition"), part, depth+1)...)
+		}
+		return types
+	}
+
+	if depth == 0 {
+		return nil
+	}
+	dispositionType, dispositionParams, _ := mime.ParseMediaType(disposition)
+	if dispositionType == "attachment" || dispositionParams["filename"] != "" || params["name"] != "" {
+		return []string{mediaType}
+	}
+	return nil
+}
+
+func verdict(v *handler.VerdictStatus) string {
+	if v == nil {
+		return ""
+	}
+	return v.Status
+}
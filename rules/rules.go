@@ -0,0 +1,158 @@
+// Package rules is a declarative rule engine routing mapped Received mail (see Engine.Middleware)
+//
+//	rules:
+//	  - name: drop-viruses
+//	    match:
+//	      virus: [FAIL]
+//	    actions:
+//	      - type: drop
+//	  - name: invoices
+//	    match:
+//	      recipients: ["billing@example.com", "*@invoices.example.com"]
+//	      subject: "(?i)invoice"
+//	      attachmentTypes: ["application/pdf"]
+//	    actions:
+//	      - type: tag
+//	        value: invoice
+//	      - type: webhook
+//	        url: https://billing.example.com/hooks/mail
+//	    stop: true
+package rules
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ActionType is what happens to a message matched by a rule
+type ActionType string
+
+const (
+	ActionDrop       ActionType = "drop"       // stop handling the message (awshandler.ErrSkip)
+	ActionTag        ActionType = "tag"        // add Value to Decision.Tags
+	ActionWebhook    ActionType = "webhook"    // POST message to URL
+	ActionStore      ActionType = "store"      // store message to Value target (handled by function registered with Engine.Handle)
+	ActionQuarantine ActionType = "quarantine" // quarantine message with Value reason
+)
+
+// Action of a rule
+type Action struct {
+	Type  ActionType `json:"type" yaml:"type"`
+	Value string     `json:"value,omitempty" yaml:"value,omitempty"` // tag, store target or quarantine reason
+	URL   string     `json:"url,omitempty" yaml:"url,omitempty"`     // webhook URL
+}
+
+// Match are conditions of a rule, all set conditions must match. Within a condition listing several values any of them
+// has to match. Address patterns are case-insensitive with * wildcard (*@example.com), Subject and Headers are regular
+// expressions, verdicts are SES statuses (PASS, FAIL, GRAY, PROCESSING_FAILED) and attachment types are media types
+// with optional wildcard subtype (image/*). AutoReply lists awshandler.AutoReplyKind values or "none" for messages
+// sent by a person.
+type Match struct {
+	Recipients      []string          `json:"recipients,omitempty" yaml:"recipients,omitempty"`
+	Sender          []string          `json:"sender,omitempty" yaml:"sender,omitempty"` // envelope sender or From header address
+	Subject         string            `json:"subject,omitempty" yaml:"subject,omitempty"`
+	Headers         map[string]string `json:"headers,omitempty" yaml:"headers,omitempty"` // header name -> regular expression of any value
+	Spam            []string          `json:"spam,omitempty" yaml:"spam,omitempty"`
+	Virus           []string          `json:"virus,omitempty" yaml:"virus,omitempty"`
+	Spf             []string          `json:"spf,omitempty" yaml:"spf,omitempty"`
+	Dkim            []string          `json:"dkim,omitempty" yaml:"dkim,omitempty"`
+	Dmarc           []string          `json:"dmarc,omitempty" yaml:"dmarc,omitempty"`
+	AttachmentTypes []string          `json:"attachmentTypes,omitempty" yaml:"attachmentTypes,omitempty"`
+	AutoReply       []string          `json:"autoReply,omitempty" yaml:"autoReply,omitempty"`
+}
+
+// Rule is a named set of conditions and actions, Stop ends evaluation of following rules if the rule matched
+type Rule struct {
+	Name    string    `json:"name" yaml:"name"`
+	Match   Match     `json:"match" yaml:"match"`
+	Actions []*Action `json:"actions" yaml:"actions"`
+	Stop    bool      `json:"stop,omitempty" yaml:"stop,omitempty"`
+}
+
+// RuleSet is the root of a rules file
+type RuleSet struct {
+	Rules []*Rule `json:"rules" yaml:"rules"`
+}
+
+// ParseRules parses JSON or YAML (YAML is a superset of JSON) rules
+func ParseRules(data []byte) (*RuleSet, error) {
+	set := &RuleSet{}
+	if err := yaml.Unmarshal(data, set); err != nil {
+		return nil, fmt.Errorf("failed to parse rules: %w", err)
+	}
+	return set, nil
+}
+
+// LoadRulesFile reads rules from YAML (.yaml, .yml) or JSON file
+func LoadRulesFile(path string) (*RuleSet, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	set := &RuleSet{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, set)
+	default:
+		err = json.Unmarshal(data, set)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load rules %s: %w", path, err)
+	}
+	return set, nil
+}
+
+// compiled rule with parsed regular expressions
+type compiledRule struct {
+	*Rule
+	subject *regexp.Regexp
+	headers map[string]*regexp.Regexp
+}
+
+func compile(rule *Rule) (*compiledRule, error) {
+	if rule.Name == "" {
+		return nil, fmt.Errorf("rule name is required")
+	}
+	if len(rule.Actions) == 0 {
+		return nil, fmt.Errorf("rule %s: no actions", rule.Name)
+	}
+
+	c := &compiledRule{Rule: rule, headers: map[string]*regexp.Regexp{}}
+	var err error
+	if rule.Match.Subject != "" {
+		if c.subject, err = regexp.Compile(rule.Match.Subject); err != nil {
+			return nil, fmt.Errorf("rule %s: invalid subject: %w", rule.Name, err)
+		}
+	}
+	for name, pattern := range rule.Match.Headers {
+		if c.headers[name], err = regexp.Compile(pattern); err != nil {
+			return nil, fmt.Errorf("rule %s: invalid header %s: %w", rule.Name, name, err)
+		}
+	}
+
+	for _, action := range rule.Actions {
+		switch action.Type {
+		case ActionDrop, ActionQuarantine:
+		case ActionTag, ActionStore:
+			if action.Value == "" {
+				return nil, fmt.Errorf("rule %s: %s action requires value", rule.Name, action.Type)
+			}
+		case ActionWebhook:
+			u, err := url.Parse(action.URL)
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				return nil, fmt.Errorf("rule %s: invalid webhook url %q", rule.Name, action.URL)
+			}
+		default:
+			return nil, fmt.Errorf("rule %s: unknown action %q", rule.Name, action.Type)
+		}
+	}
+	return c, nil
+}
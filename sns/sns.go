@@ -2,6 +2,7 @@ package sns
 
 import (
 	"bytes"
+	"context"
 	"crypto/x509"
 	"encoding/base64"
 	"encoding/pem"
@@ -13,6 +14,12 @@ import (
 	"net/url"
 	"reflect"
 	"regexp"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // this is a copy from repository https://github.com/robbiet480/go.sns (last change was Oct 2022, seems to be abandoned)
@@ -75,6 +82,47 @@ func (payload *Payload) SignatureAlgorithm() x509.SignatureAlgorithm {
 
 // VerifyPayload will verify that a payload came from SNS
 func (payload *Payload) VerifyPayload() error {
+	return (&Verifier{}).Verify(payload)
+}
+
+// Metrics receives measurements from signature verification
+type Metrics interface {
+	CertificateFetched(duration time.Duration, err error)
+	SignatureVerified(err error)
+}
+
+// Verifier verifies that payloads came from SNS. Signing certificates are cached by URL until they expire,
+// the Verifier must not be copied after first use.
+type Verifier struct {
+	Metrics Metrics      // optional
+	Tracer  trace.Tracer // optional, spans for verification and certificate fetch
+	Client  *http.Client // optional, http.DefaultClient if nil
+
+	mu    sync.Mutex
+	certs map[string]*x509.Certificate
+}
+
+// Verify will verify that a payload came from SNS
+func (v *Verifier) Verify(payload *Payload) error {
+	return v.VerifyContext(context.Background(), payload)
+}
+
+// VerifyContext will verify that a payload came from SNS, tracing spans are children of span in ctx
+func (v *Verifier) VerifyContext(ctx context.Context, payload *Payload) error {
+	ctx, span := v.tracer().Start(ctx, "sns.VerifyPayload", trace.WithAttributes(
+		attribute.String("sns.topic_arn", payload.TopicArn),
+		attribute.String("sns.message_id", payload.MessageId),
+	))
+	err := v.verify(ctx, payload)
+	endSpan(span, err)
+
+	if v.Metrics != nil {
+		v.Metrics.SignatureVerified(err)
+	}
+	return err
+}
+
+func (v *Verifier) verify(ctx context.Context, payload *Payload) error {
 	payloadSignature, err := base64.StdEncoding.DecodeString(payload.Signature)
 	if err != nil {
 		return err
@@ -93,39 +141,102 @@ func (payload *Payload) VerifyPayload() error {
 		return fmt.Errorf("certificate is located on an invalid domain")
 	}
 
-	resp, err := http.Get(payload.SigningCertURL)
+	parsedCertificate, err := v.certificate(ctx, certURL.String())
 	if err != nil {
 		return err
 	}
 
-	defer resp.Body.Close()
+	return parsedCertificate.CheckSignature(payload.SignatureAlgorithm(), payload.BuildSignature(), payloadSignature)
+}
 
-	body, err := ioutil.ReadAll(resp.Body)
+// certificate of validated certURL, fetched only if it isn't cached or the cached one expired
+func (v *Verifier) certificate(ctx context.Context, certURL string) (*x509.Certificate, error) {
+	v.mu.Lock()
+	cached := v.certs[certURL]
+	v.mu.Unlock()
+	if cached != nil && time.Now().Before(cached.NotAfter) {
+		return cached, nil
+	}
+
+	body, err := v.fetchCertificate(ctx, certURL)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	decodedPem, _ := pem.Decode(body)
 	if decodedPem == nil {
-		return errors.New("The decoded PEM file was empty!")
+		return nil, errors.New("The decoded PEM file was empty!")
 	}
 
 	parsedCertificate, err := x509.ParseCertificate(decodedPem.Bytes)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	return parsedCertificate.CheckSignature(payload.SignatureAlgorithm(), payload.BuildSignature(), payloadSignature)
+	v.mu.Lock()
+	if v.certs == nil {
+		v.certs = map[string]*x509.Certificate{}
+	}
+	v.certs[certURL] = parsedCertificate
+	v.mu.Unlock()
+	return parsedCertificate, nil
+}
+
+func (v *Verifier) fetchCertificate(ctx context.Context, certURL string) (body []byte, err error) {
+	_, span := v.tracer().Start(ctx, "sns.FetchCertificate", trace.WithAttributes(
+		attribute.String("sns.signing_cert_url", certURL),
+	))
+	start := time.Now()
+	defer func() {
+		endSpan(span, err)
+		if v.Metrics != nil {
+			v.Metrics.CertificateFetched(time.Since(start), err)
+		}
+	}()
+
+	resp, err := httpGet(ctx, v.Client, certURL)
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	return ioutil.ReadAll(resp.Body)
+}
+
+func (v *Verifier) tracer() trace.Tracer {
+	if v.Tracer != nil {
+		return v.Tracer
+	}
+	return trace.NewNoopTracerProvider().Tracer("")
+}
+
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
 }
 
 // Subscribe will use the SubscribeURL in a payload to confirm a subscription and return a ConfirmSubscriptionResponse
 func (payload *Payload) Subscribe() (ConfirmSubscriptionResponse, error) {
+	return payload.SubscribeWithContext(context.Background())
+}
+
+// SubscribeWithContext is Subscribe with the request bound to ctx
+func (payload *Payload) SubscribeWithContext(ctx context.Context) (ConfirmSubscriptionResponse, error) {
+	return payload.SubscribeWithClient(ctx, nil)
+}
+
+// SubscribeWithClient is SubscribeWithContext using client (http.DefaultClient if nil)
+func (payload *Payload) SubscribeWithClient(ctx context.Context, client *http.Client) (ConfirmSubscriptionResponse, error) {
 	var response ConfirmSubscriptionResponse
 	if payload.SubscribeURL == "" {
 		return response, errors.New("Payload does not have a SubscribeURL!")
 	}
 
-	resp, err := http.Get(payload.SubscribeURL)
+	resp, err := httpGet(ctx, client, payload.SubscribeURL)
 	if err != nil {
 		return response, err
 	}
@@ -146,8 +257,13 @@ func (payload *Payload) Subscribe() (ConfirmSubscriptionResponse, error) {
 
 // Unsubscribe will use the UnsubscribeURL in a payload to confirm a subscription and return a UnsubscribeResponse
 func (payload *Payload) Unsubscribe() (UnsubscribeResponse, error) {
+	return payload.UnsubscribeWithContext(context.Background())
+}
+
+// UnsubscribeWithContext is Unsubscribe with the request bound to ctx
+func (payload *Payload) UnsubscribeWithContext(ctx context.Context) (UnsubscribeResponse, error) {
 	var response UnsubscribeResponse
-	resp, err := http.Get(payload.UnsubscribeURL)
+	resp, err := httpGet(ctx, nil, payload.UnsubscribeURL)
 	if err != nil {
 		return response, err
 	}
@@ -165,3 +281,14 @@ func (payload *Payload) Unsubscribe() (UnsubscribeResponse, error) {
 	}
 	return response, nil
 }
+
+func httpGet(ctx context.Context, client *http.Client, url string) (*http.Response, error) {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	return client.Do(req)
+}
@@ -0,0 +1,243 @@
+package awshandler
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
+	"github.com/igorrendulic/couchdb-experiment/email/mime/handler"
+)
+
+// SqsConsumer defaults
+const (
+	DefaultSqsWaitTimeSeconds   = 20 // maximum long polling wait time supported by SQS
+	DefaultSqsVisibilityTimeout = 60 // seconds
+	DefaultSqsMaxReceives       = 5
+	sqsMaxNumberOfMessages      = 10 // maximum batch size supported by SQS
+)
+
+// SqsConsumerConfig configures the SqsConsumer
+type SqsConsumerConfig struct {
+	QueueURL           string
+	DeadLetterQueueURL string          // optional, poison and permanently failing messages are moved here instead of being deleted
+	MaxReceives        int             // receive count after which a message is considered poison
+	WaitTimeSeconds    int64           // long polling wait time
+	VisibilityTimeout  int64           // seconds, extended while the message is being handled
+	Concurrency        int             // messages handled in parallel
+	OnError            func(err error) // optional, called for receive and handling errors
+}
+
+// SqsConsumer long-polls SQS queue subscribed to the SES SNS topic and handles messages with handler.SmtpHandler
+type SqsConsumer struct {
+	svc     sqsiface.SQSAPI
+	handler handler.SmtpHandler
+	config  SqsConsumerConfig
+}
+
+func NewSqsConsumer(svc sqsiface.SQSAPI, h handler.SmtpHandler, config SqsConsumerConfig) (*SqsConsumer, error) {
+	if config.QueueURL == "" {
+		return nil, errors.New("sqs queue url is required")
+	}
+	if config.WaitTimeSeconds <= 0 {
+		config.WaitTimeSeconds = DefaultSqsWaitTimeSeconds
+	}
+	if config.VisibilityTimeout <= 0 {
+		config.VisibilityTimeout = DefaultSqsVisibilityTimeout
+	}
+	if config.MaxReceives <= 0 {
+		config.MaxReceives = DefaultSqsMaxReceives
+	}
+	if config.Concurrency <= 0 {
+		config.Concurrency = DefaultBatchConcurrency
+	}
+	return &SqsConsumer{
+		svc:     svc,
+		handler: h,
+		config:  config,
+	}, nil
+}
+
+// Run polls the queue until ctx is cancelled
+func (c *SqsConsumer) Run(ctx context.Context) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		default:
+		}
+
+		if err := c.Poll(ctx); err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			c.reportError(err)
+			// back off before polling again
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-time.After(time.Second):
+			}
+		}
+	}
+}
+
+// Poll receives a single batch of messages and handles them.
+// Returns error only if receiving from the queue failed, handling errors are reported to OnError.
+// Messages failing with a non-retryable error (see IsRetryable) are discarded right away, other failed messages are
+// redelivered until they are received more than MaxReceives times. Discarded messages are moved to the dead-letter
+// queue if configured, deleted otherwise. Quarantined messages (QuarantineError) are deleted and reported to OnError.
+func (c *SqsConsumer) Poll(ctx context.Context) error {
+	out, err := c.svc.ReceiveMessageWithContext(ctx, &sqs.ReceiveMessageInput{
+		QueueUrl:            aws.String(c.config.QueueURL),
+		MaxNumberOfMessages: aws.Int64(sqsMaxNumberOfMessages),
+		WaitTimeSeconds:     aws.Int64(c.config.WaitTimeSeconds),
+		VisibilityTimeout:   aws.Int64(c.config.VisibilityTimeout),
+		AttributeNames:      []*string{aws.String(sqs.MessageSystemAttributeNameApproximateReceiveCount)},
+		// copied to the dead-letter queue
+		MessageAttributeNames: []*string{aws.String("All")},
+	})
+	if err != nil {
+		return err
+	}
+
+	sem := make(chan struct{}, c.config.Concurrency)
+	var wg sync.WaitGroup
+	for _, msg := range out.Messages {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(msg *sqs.Message) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			if err := c.processMessage(ctx, msg); err != nil {
+				c.reportError(err)
+			}
+		}(msg)
+	}
+	wg.Wait()
+
+	return nil
+}
+
+func (c *SqsConsumer) processMessage(ctx context.Context, msg *sqs.Message) error {
+	if count := receiveCount(msg); count > c.config.MaxReceives {
+		if err := c.discard(ctx, msg); err != nil {
+			return err
+		}
+		return &PoisonMessageError{MessageID: aws.StringValue(msg.MessageId), ReceiveCount: count}
+	}
+
+	// extending visibility while handling (e.g. slow S3 download) so message isn't redelivered in the meantime
+	stop := c.extendVisibility(ctx, msg)
+
+	// SNS envelope is passed as it is, the handler checks its topic and signature
+	_, err := handleSmtp(ctx, c.handler, []byte(aws.StringValue(msg.Body)))
+	// stopping before the receipt handle is deleted
+	stop()
+	if err != nil {
+		if quarantined(err) {
+			// handled, the verdict is only reported
+			if dErr := c.deleteMessage(ctx, msg); dErr != nil {
+				return dErr
+			}
+			return err
+		}
+		if ctx.Err() == nil && permanent(err) {
+			if dErr := c.discard(ctx, msg); dErr != nil {
+				return dErr
+			}
+		}
+		// otherwise leaving the message in the queue to be redelivered after visibility timeout
+		return err
+	}
+
+	return c.deleteMessage(ctx, msg)
+}
+
+// permanent errors reoccur on redelivery, errors not classified as retryable or not are redelivered up to MaxReceives
+func permanent(err error) bool {
+	var r Retryable
+	return errors.As(err, &r) && !r.Retryable()
+}
+
+// 0 if SQS didn't return the attribute
+func receiveCount(msg *sqs.Message) int {
+	count, _ := strconv.Atoi(aws.StringValue(msg.Attributes[sqs.MessageSystemAttributeNameApproximateReceiveCount]))
+	return count
+}
+
+// moving message to dead-letter queue if configured, deleting it otherwise
+func (c *SqsConsumer) discard(ctx context.Context, msg *sqs.Message) error {
+	if c.config.DeadLetterQueueURL == "" {
+		return c.deleteMessage(ctx, msg)
+	}
+	return c.moveToDeadLetterQueue(ctx, msg)
+}
+
+func (c *SqsConsumer) moveToDeadLetterQueue(ctx context.Context, msg *sqs.Message) error {
+	_, err := c.svc.SendMessageWithContext(ctx, &sqs.SendMessageInput{
+		QueueUrl:          aws.String(c.config.DeadLetterQueueURL),
+		MessageBody:       msg.Body,
+		MessageAttributes: msg.MessageAttributes,
+	})
+	if err != nil {
+		return err
+	}
+	return c.deleteMessage(ctx, msg)
+}
+
+func (c *SqsConsumer) deleteMessage(ctx context.Context, msg *sqs.Message) error {
+	_, err := c.svc.DeleteMessageWithContext(ctx, &sqs.DeleteMessageInput{
+		QueueUrl:      aws.String(c.config.QueueURL),
+		ReceiptHandle: msg.ReceiptHandle,
+	})
+	return err
+}
+
+// returned func stops extending and waits for an in-flight ChangeMessageVisibility to return
+func (c *SqsConsumer) extendVisibility(ctx context.Context, msg *sqs.Message) func() {
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		c.keepInvisible(ctx, msg, done)
+	}()
+	return func() {
+		close(done)
+		<-stopped
+	}
+}
+
+func (c *SqsConsumer) keepInvisible(ctx context.Context, msg *sqs.Message, done chan struct{}) {
+	ticker := time.NewTicker(time.Duration(c.config.VisibilityTimeout) * time.Second / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			_, err := c.svc.ChangeMessageVisibilityWithContext(ctx, &sqs.ChangeMessageVisibilityInput{
+				QueueUrl:          aws.String(c.config.QueueURL),
+				ReceiptHandle:     msg.ReceiptHandle,
+				VisibilityTimeout: aws.Int64(c.config.VisibilityTimeout),
+			})
+			if err != nil {
+				c.reportError(err)
+			}
+		}
+	}
+}
+
+func (c *SqsConsumer) reportError(err error) {
+	if c.config.OnError != nil {
+		c.config.OnError(err)
+	}
+}
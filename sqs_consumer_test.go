@@ -0,0 +1,257 @@
+package awshandler
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/awstesting/unit"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/igorrendulic/couchdb-experiment/email/mime/handler"
+)
+
+// mocking aws sqs client, returning messages on first receive and logging all called operations
+func sqsLoggingSvc(messages []*sqs.Message) (*sqs.SQS, *[]string, *[]interface{}) {
+	var m sync.Mutex
+	names := []string{}
+	params := []interface{}{}
+
+	svc := sqs.New(unit.Session)
+	svc.Handlers.Send.Clear()
+	svc.Handlers.Unmarshal.Clear()
+	svc.Handlers.UnmarshalMeta.Clear()
+	svc.Handlers.ValidateResponse.Clear()
+	svc.Handlers.Send.PushBack(func(r *request.Request) {
+		m.Lock()
+		defer m.Unlock()
+
+		names = append(names, r.Operation.Name)
+		params = append(params, r.Params)
+
+		if out, ok := r.Data.(*sqs.ReceiveMessageOutput); ok {
+			// sdk validates received message bodies against their checksums
+			for _, msg := range messages {
+				msg.MD5OfBody = aws.String(fmt.Sprintf("%x", md5.Sum([]byte(aws.StringValue(msg.Body)))))
+			}
+			out.Messages = messages
+			messages = nil
+		}
+		if out, ok := r.Data.(*sqs.SendMessageOutput); ok {
+			body := aws.StringValue(r.Params.(*sqs.SendMessageInput).MessageBody)
+			out.MD5OfMessageBody = aws.String(fmt.Sprintf("%x", md5.Sum([]byte(body))))
+		}
+		r.HTTPResponse = &http.Response{
+			StatusCode: 200,
+			Body:       ioutil.NopCloser(bytes.NewReader([]byte{})),
+			Header:     http.Header{},
+		}
+	})
+
+	return svc, &names, &params
+}
+
+type slowSmtpHandler struct {
+	handler.SmtpHandler
+	delay time.Duration
+}
+
+func (s *slowSmtpHandler) HandleSmtp(message []byte) (*handler.MailReceived, error) {
+	time.Sleep(s.delay)
+	return s.SmtpHandler.HandleSmtp(message)
+}
+
+func countOperations(names []string, operation string) int {
+	count := 0
+	for _, n := range names {
+		if n == operation {
+			count++
+		}
+	}
+	return count
+}
+
+func TestSqsConsumerPoll(t *testing.T) {
+	bounce, err := LoadPayload("test_data/bounce.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	messages := []*sqs.Message{
+		{
+			MessageId:     aws.String("1"),
+			ReceiptHandle: aws.String("handle-1"),
+			Body:          aws.String(string(wrapInSnsEnvelope(t, bounce))),
+			Attributes:    map[string]*string{"ApproximateReceiveCount": aws.String("1")},
+		},
+		{
+			MessageId:     aws.String("2"),
+			ReceiptHandle: aws.String("handle-2"),
+			Body:          aws.String("not json"),
+			Attributes:    map[string]*string{"ApproximateReceiveCount": aws.String("1")},
+			MessageAttributes: map[string]*sqs.MessageAttributeValue{
+				"source": {DataType: aws.String("String"), StringValue: aws.String("ses")},
+			},
+		},
+		{
+			MessageId:     aws.String("3"),
+			ReceiptHandle: aws.String("handle-3"),
+			Body:          aws.String("not json"),
+			Attributes:    map[string]*string{"ApproximateReceiveCount": aws.String("4")},
+		},
+	}
+	sqsSvc, names, params := sqsLoggingSvc(messages)
+	s3Svc, _, _ := dlLoggingSvc([]byte{})
+
+	smtpHandler, err := NewAwsSmtpHandlerWithOptions(WithS3(s3Svc), WithSignatureVerification(true, false))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var handleErrors []error
+	consumer, err := NewSqsConsumer(sqsSvc, smtpHandler, SqsConsumerConfig{
+		QueueURL:           "https://sqs.us-west-2.amazonaws.com/123456/ses",
+		DeadLetterQueueURL: "https://sqs.us-west-2.amazonaws.com/123456/ses-dlq",
+		MaxReceives:        3,
+		Concurrency:        1,
+		OnError:            func(err error) { handleErrors = append(handleErrors, err) },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := consumer.Poll(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	var parseErr *ParseError
+	var poisonErr *PoisonMessageError
+	if len(handleErrors) != 2 || !errors.As(handleErrors[0], &parseErr) || !errors.As(handleErrors[1], &poisonErr) || poisonErr.ReceiveCount != 4 {
+		t.Fatalf("expected messages 2 and 3 to fail, got %v", handleErrors)
+	}
+	// unparsable message won't succeed on redelivery
+	if countOperations(*names, "SendMessage") != 2 {
+		t.Fatalf("expected unparsable and poison messages to be sent to DLQ: %v", *names)
+	}
+	if deleted := deletedHandles(*params); !reflect.DeepEqual(deleted, []string{"handle-1", "handle-2", "handle-3"}) {
+		t.Fatalf("expected all messages to be deleted, got %v", deleted)
+	}
+	for _, p := range *params {
+		if in, ok := p.(*sqs.ReceiveMessageInput); ok {
+			if len(in.MessageAttributeNames) != 1 || aws.StringValue(in.MessageAttributeNames[0]) != "All" {
+				t.Fatalf("expected message attributes to be requested, got %v", in.MessageAttributeNames)
+			}
+		}
+	}
+	if sent := sentAttributes(*params); len(sent) != 1 || aws.StringValue(sent[0]["source"].StringValue) != "ses" {
+		t.Fatalf("expected message attributes to be copied to DLQ, got %v", sent)
+	}
+}
+
+func sentAttributes(params []interface{}) []map[string]*sqs.MessageAttributeValue {
+	sent := []map[string]*sqs.MessageAttributeValue{}
+	for _, p := range params {
+		if in, ok := p.(*sqs.SendMessageInput); ok && in.MessageAttributes != nil {
+			sent = append(sent, in.MessageAttributes)
+		}
+	}
+	return sent
+}
+
+type failingSmtpHandler struct{}
+
+func (failingSmtpHandler) HandleSmtp(message []byte) (*handler.MailReceived, error) {
+	if string(message) == "not json" {
+		return nil, &ParseError{Err: errors.New("invalid")}
+	}
+	return nil, errors.New("couldn't store")
+}
+
+func TestSqsConsumerWithoutDeadLetterQueue(t *testing.T) {
+	messages := []*sqs.Message{
+		{MessageId: aws.String("1"), ReceiptHandle: aws.String("handle-1"), Body: aws.String("not json")},
+		{MessageId: aws.String("2"), ReceiptHandle: aws.String("handle-2"), Body: aws.String("{}"),
+			Attributes: map[string]*string{"ApproximateReceiveCount": aws.String("2")}},
+		{MessageId: aws.String("3"), ReceiptHandle: aws.String("handle-3"), Body: aws.String("{}"),
+			Attributes: map[string]*string{"ApproximateReceiveCount": aws.String("6")}},
+	}
+	sqsSvc, names, params := sqsLoggingSvc(messages)
+
+	var handleErrors []error
+	consumer, err := NewSqsConsumer(sqsSvc, failingSmtpHandler{}, SqsConsumerConfig{
+		QueueURL:    "https://sqs.us-west-2.amazonaws.com/123456/ses",
+		Concurrency: 1,
+		OnError:     func(err error) { handleErrors = append(handleErrors, err) },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := consumer.Poll(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// unparsable message is deleted right away, unclassified error is redelivered until MaxReceives
+	if len(handleErrors) != 3 || ErrorKind(handleErrors[0]) != "parse" || ErrorKind(handleErrors[2]) != "poison_message" {
+		t.Fatalf("unexpected errors %v", handleErrors)
+	}
+	if countOperations(*names, "SendMessage") != 0 {
+		t.Fatalf("unexpected DLQ operations: %v", *names)
+	}
+	if deleted := deletedHandles(*params); !reflect.DeepEqual(deleted, []string{"handle-1", "handle-3"}) {
+		t.Fatalf("expected permanently failing and poison messages to be deleted, got %v", deleted)
+	}
+}
+
+func deletedHandles(params []interface{}) []string {
+	deleted := []string{}
+	for _, p := range params {
+		if in, ok := p.(*sqs.DeleteMessageInput); ok {
+			deleted = append(deleted, aws.StringValue(in.ReceiptHandle))
+		}
+	}
+	return deleted
+}
+
+func TestSqsConsumerExtendsVisibility(t *testing.T) {
+	delivery, err := LoadPayload("test_data/delivery.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sqsSvc, names, _ := sqsLoggingSvc([]*sqs.Message{
+		{
+			MessageId:     aws.String("1"),
+			ReceiptHandle: aws.String("handle-1"),
+			Body:          aws.String(string(delivery)),
+		},
+	})
+	s3Svc, _, _ := dlLoggingSvc([]byte{})
+	slow := &slowSmtpHandler{SmtpHandler: NewAwsSmtpHandler(s3Svc), delay: 1200 * time.Millisecond}
+
+	consumer, err := NewSqsConsumer(sqsSvc, slow, SqsConsumerConfig{
+		QueueURL:          "https://sqs.us-west-2.amazonaws.com/123456/ses",
+		VisibilityTimeout: 2,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := consumer.Poll(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if countOperations(*names, "ChangeMessageVisibility") != 1 {
+		t.Fatalf("expected visibility to be extended once: %v", *names)
+	}
+	if countOperations(*names, "DeleteMessage") != 1 || (*names)[len(*names)-1] != "DeleteMessage" {
+		t.Fatalf("expected message to be deleted after visibility stopped being extended: %v", *names)
+	}
+}
@@ -0,0 +1,50 @@
+package thread
+
+import (
+	"context"
+	"sync"
+)
+
+// Entry is a message known to the threader
+type Entry struct {
+	MessageID    string `json:"messageId"`              // Message-ID header without angle brackets
+	SESMessageID string `json:"sesMessageId,omitempty"` // SES messageId of outbound messages
+	ThreadID     string `json:"threadId"`
+	Outbound     bool   `json:"outbound"` // sent by us (recorded from Delivery notification or RecordSent)
+	Timestamp    int64  `json:"timestamp,omitempty"`
+}
+
+// Store persists entries keyed by Message-ID and SES messageId
+type Store interface {
+	// Get returns entry with Message-ID or SES messageId id, nil if unknown
+	Get(ctx context.Context, id string) (*Entry, error)
+	Put(ctx context.Context, entry *Entry) error
+}
+
+// MemoryStore is a Store kept in memory, safe for concurrent use
+type MemoryStore struct {
+	mu      sync.RWMutex
+	entries map[string]*Entry
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{entries: map[string]*Entry{}}
+}
+
+func (s *MemoryStore) Get(ctx context.Context, id string) (*Entry, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.entries[id], nil
+}
+
+func (s *MemoryStore) Put(ctx context.Context, entry *Entry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if entry.MessageID != "" {
+		s.entries[entry.MessageID] = entry
+	}
+	if entry.SESMessageID != "" {
+		s.entries[entry.SESMessageID] = entry
+	}
+	return nil
+}
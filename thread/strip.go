@@ -0,0 +1,145 @@
+package thread
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"regexp"
+	"strings"
+)
+
+// maximum depth of nested multipart content searched for the text body
+const maxPartDepth = 10
+
+// lines starting quoted text of the replied message
+var quoteHeaderPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`(?i)^on\s.+\swrote:$`),                                // Gmail, Apple Mail, Thunderbird
+	regexp.MustCompile(`(?i)^-{2,}\s*original message\s*-{2,}$`),              // Outlook
+	regexp.MustCompile(`(?i)^-{2,}\s*forwarded message\s*-{2,}$`),             // Gmail forward
+	regexp.MustCompile(`^_{10,}$`),                                            // Outlook separator above From:/Sent: block
+	regexp.MustCompile(`(?i)^le\s.+\sa\sécrit\s?:$`),                          // French
+	regexp.MustCompile(`(?i)^am\s.+\sschrieb\s.*:$`),                          // German
+	regexp.MustCompile(`(?i)^el\s.+\sescribió:$`),                             // Spanish
+	regexp.MustCompile(`(?i)^\d{4}[-/.]\d{1,2}[-/.]\d{1,2}.*<[^>]+@[^>]+>:$`), // 2023-03-14 10:00 GMT+01:00 John <john@example.com>:
+}
+
+// lines starting the signature
+var signaturePatterns = []*regexp.Regexp{
+	regexp.MustCompile(`^--\s?$`), // RFC 3676 signature separator
+	regexp.MustCompile(`(?i)^sent from my \w+`),
+	regexp.MustCompile(`(?i)^sent from (yahoo mail|mail for windows|outlook)`),
+	regexp.MustCompile(`(?i)^get outlook for (ios|android)`),
+}
+
+// Outlook header block of the replied message: From: followed by Sent:/Date: within a few lines
+var outlookFromPattern = regexp.MustCompile(`(?i)^\*?(from|von|de):\*?\s`)
+var outlookSentPattern = regexp.MustCompile(`(?i)^\*?(sent|date|gesendet|envoyé):\*?\s`)
+
+// StripReply returns the new content of a reply: text up to quoted text of the replied message or the signature,
+// without lines quoted with '>'
+func StripReply(text string) string {
+	lines := strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n")
+	kept := []string{}
+	for i := 0; i < len(lines); i++ {
+		line := strings.TrimSpace(lines[i])
+		if isQuoteHeader(line) || isSignature(line) || isOutlookHeader(lines[i:]) {
+			break
+		}
+		// attribution line wrapped by the client: "On Tue, 14 Mar 2023 at 10:00, John Doe <john@example.com>\nwrote:"
+		if i+1 < len(lines) && isQuoteHeader(line+" "+strings.TrimSpace(lines[i+1])) {
+			break
+		}
+		if strings.HasPrefix(line, ">") {
+			continue
+		}
+		kept = append(kept, strings.TrimRight(lines[i], " \t"))
+	}
+	return strings.TrimSpace(strings.Join(kept, "\n"))
+}
+
+// TextBody returns the first text/plain part of MIME content which isn't an attachment, decoded from its transfer encoding.
+// Returns empty string if there is none.
+func TextBody(raw []byte) (string, error) {
+	msg, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		return "", err
+	}
+	return textPart(msg.Header.Get("Content-Type"), msg.Header.Get("Content-Transfer-Encoding"), msg.Body, 0)
+}
+
+func textPart(contentType string, encoding string, body io.Reader, depth int) (string, error) {
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType = "text/plain"
+	}
+
+	if strings.HasPrefix(mediaType, "multipart/") && depth < maxPartDepth {
+		reader := multipart.NewReader(body, params["boundary"])
+		for {
+			part, err := reader.NextPart()
+			if err == io.EOF {
+				return "", nil
+			}
+			if err != nil {
+				return "", err
+			}
+			if disposition, _, _ := mime.ParseMediaType(part.Header.Get("Content-Disposition")); disposition == "attachment" {
+				continue
+			}
+			// multipart.Reader decodes quoted-printable parts and removes the header
+			text, err := textPart(part.Header.Get("Content-Type"), part.Header.Get("Content-Transfer-Encoding"), part, depth+1)
+			if err != nil || text != "" {
+				return text, err
+			}
+		}
+	}
+	if mediaType != "text/plain" {
+		return "", nil
+	}
+
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "base64":
+		body = base64.NewDecoder(base64.StdEncoding, body)
+	case "quoted-printable":
+		body = quotedprintable.NewReader(body)
+	}
+	content, err := io.ReadAll(body)
+	if err != nil {
+		return "", err
+	}
+	return string(content), nil
+}
+
+func isQuoteHeader(line string) bool {
+	for _, p := range quoteHeaderPatterns {
+		if p.MatchString(line) {
+			return true
+		}
+	}
+	return false
+}
+
+func isSignature(line string) bool {
+	for _, p := range signaturePatterns {
+		if p.MatchString(line) {
+			return true
+		}
+	}
+	return false
+}
+
+func isOutlookHeader(lines []string) bool {
+	if !outlookFromPattern.MatchString(strings.TrimSpace(lines[0])) {
+		return false
+	}
+	for i := 1; i < len(lines) && i < 4; i++ {
+		if outlookSentPattern.MatchString(strings.TrimSpace(lines[i])) {
+			return true
+		}
+	}
+	return false
+}
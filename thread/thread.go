@@ -0,0 +1,227 @@
+package thread
+
+import (
+	"bytes"
+	"context"
+	"net/mail"
+	"net/textproto"
+	"strings"
+
+	awshandler "github.com/igorrendulic/couchdb-email-aws-parse"
+)
+
+// AnnotationThread is the annotation key of *Thread set by Threader.Middleware for Received notifications
+const AnnotationThread = "thread.thread"
+
+// SES sets Message-ID of outbound mail to <messageId@email.amazonses.com> (or a regional amazonses.com domain)
+const sesMessageIDDomain = "amazonses.com"
+
+// Thread is the result of threading a Received message
+type Thread struct {
+	ID         string   `json:"id"`        // Message-ID of the thread root
+	MessageID  string   `json:"messageId"` // Message-ID of the message
+	InReplyTo  []string `json:"inReplyTo,omitempty"`
+	References []string `json:"references,omitempty"`
+	Parent     *Entry   `json:"parent,omitempty"` // most recent referenced outbound message
+	Reply      bool     `json:"reply"`            // reply to a message we sent
+	Body       string   `json:"body,omitempty"`   // text body without quoted text and signature
+}
+
+// Threader links Received messages to outbound messages through In-Reply-To and References headers
+type Threader struct {
+	store Store
+}
+
+func NewThreader(store Store) *Threader {
+	return &Threader{store: store}
+}
+
+// RecordSent records an outbound message so replies to it are detected.
+// messageID is the Message-ID header if known, SES sets it from sesMessageID otherwise.
+func (t *Threader) RecordSent(ctx context.Context, sesMessageID string, messageID string, references []string, timestamp int64) (*Entry, error) {
+	entry := &Entry{
+		MessageID:    trimID(messageID),
+		SESMessageID: sesMessageID,
+		Outbound:     true,
+		Timestamp:    timestamp,
+	}
+	if entry.MessageID == "" && sesMessageID != "" {
+		entry.MessageID = sesMessageID + "@email." + sesMessageIDDomain
+	}
+	threadID, err := t.threadID(ctx, references, entry.MessageID)
+	if err != nil {
+		return nil, err
+	}
+	entry.ThreadID = threadID
+	return entry, t.store.Put(ctx, entry)
+}
+
+// Thread resolves the thread of a message with header and records it so later messages of the conversation are linked.
+// Already recorded messages (redelivered or our own outbound message received back) are kept as they are.
+func (t *Threader) Thread(ctx context.Context, header mail.Header, timestamp int64) (*Thread, error) {
+	th := &Thread{
+		MessageID:  trimID(header.Get("Message-ID")),
+		InReplyTo:  MessageIDs(header.Get("In-Reply-To")),
+		References: MessageIDs(header.Get("References")),
+	}
+
+	// most recent first: In-Reply-To, then References from the end
+	ids := append([]string{}, th.InReplyTo...)
+	for i := len(th.References) - 1; i >= 0; i-- {
+		ids = append(ids, th.References[i])
+	}
+	for _, id := range ids {
+		entry, err := t.lookup(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		if entry != nil && entry.Outbound {
+			th.Parent, th.Reply = entry, true
+			break
+		}
+	}
+
+	references := append(append([]string{}, th.References...), th.InReplyTo...)
+	threadID, err := t.threadID(ctx, references, th.MessageID)
+	if err != nil {
+		return nil, err
+	}
+	th.ID = threadID
+	if th.MessageID == "" {
+		return th, nil
+	}
+	existing, err := t.store.Get(ctx, th.MessageID)
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil {
+		th.ID = existing.ThreadID
+		return th, nil
+	}
+	if err := t.store.Put(ctx, &Entry{MessageID: th.MessageID, ThreadID: th.ID, Timestamp: timestamp}); err != nil {
+		return nil, err
+	}
+	return th, nil
+}
+
+// Middleware records Delivery notifications as outbound messages and threads Received notifications.
+// Thread of a Received message is set as AnnotationThread, Body only if MIME content is in the output.
+func (t *Threader) Middleware() awshandler.Middleware {
+	return func(stage awshandler.Stage, ex *awshandler.Exchange) error {
+		if stage != awshandler.StageOutput || ex.Output == nil || ex.Output.Mail == nil {
+			return nil
+		}
+		ctx := ex.Context()
+		out := ex.Output
+		header := messageHeader(ex)
+
+		switch awshandler.NotificationType(out.NotificationType) {
+		case awshandler.NotificationTypeDelivery:
+			messageID := header.Get("Message-ID")
+			if messageID == "" && out.Mail.CommonHeaders != nil {
+				messageID = out.Mail.CommonHeaders.MessageID
+			}
+			_, err := t.RecordSent(ctx, out.Mail.MessageID, messageID, MessageIDs(header.Get("References")), out.Timestamp)
+			return err
+		case awshandler.NotificationTypeReceived:
+			th, err := t.Thread(ctx, header, out.Timestamp)
+			if err != nil {
+				return err
+			}
+			if len(out.Mail.RawMime) > 0 {
+				if text, err := TextBody(out.Mail.RawMime); err == nil {
+					th.Body = StripReply(text)
+				}
+			}
+			ex.Annotate(AnnotationThread, th)
+		}
+		return nil
+	}
+}
+
+// MessageIDs parses msg-id list of In-Reply-To and References headers, ids are returned without angle brackets
+func MessageIDs(value string) []string {
+	ids := []string{}
+	for {
+		start := strings.IndexByte(value, '<')
+		if start < 0 {
+			break
+		}
+		end := strings.IndexByte(value[start:], '>')
+		if end < 0 {
+			break
+		}
+		if id := strings.TrimSpace(value[start+1 : start+end]); id != "" {
+			ids = append(ids, id)
+		}
+		value = value[start+end+1:]
+	}
+	// non-conforming single id without brackets
+	if len(ids) == 0 && strings.TrimSpace(value) != "" && !strings.ContainsAny(value, " \t<>") && strings.Contains(value, "@") {
+		ids = append(ids, strings.TrimSpace(value))
+	}
+	return ids
+}
+
+// SESMessageID returns SES messageId of Message-ID id set by SES for outbound mail
+func SESMessageID(id string) (string, bool) {
+	at := strings.LastIndexByte(id, '@')
+	if at <= 0 {
+		return "", false
+	}
+	domain := strings.ToLower(id[at+1:])
+	if domain != sesMessageIDDomain && !strings.HasSuffix(domain, "."+sesMessageIDDomain) {
+		return "", false
+	}
+	return id[:at], true
+}
+
+// entry of id, falling back to SES messageId for ids set by SES
+func (t *Threader) lookup(ctx context.Context, id string) (*Entry, error) {
+	entry, err := t.store.Get(ctx, id)
+	if err != nil || entry != nil {
+		return entry, err
+	}
+	if sesID, ok := SESMessageID(id); ok {
+		return t.store.Get(ctx, sesID)
+	}
+	return nil, nil
+}
+
+// thread of the oldest known reference, the oldest reference or the message itself
+func (t *Threader) threadID(ctx context.Context, references []string, messageID string) (string, error) {
+	for _, id := range references {
+		entry, err := t.lookup(ctx, id)
+		if err != nil {
+			return "", err
+		}
+		if entry != nil {
+			return entry.ThreadID, nil
+		}
+	}
+	if len(references) > 0 {
+		return references[0], nil
+	}
+	return messageID, nil
+}
+
+// header of MIME content if available, SES headers otherwise
+func messageHeader(ex *awshandler.Exchange) mail.Header {
+	if out := ex.Output; out != nil && out.Mail != nil && len(out.Mail.RawMime) > 0 {
+		if msg, err := mail.ReadMessage(bytes.NewReader(out.Mail.RawMime)); err == nil {
+			return msg.Header
+		}
+	}
+	header := mail.Header{}
+	if ex.Message != nil && ex.Message.Mail != nil {
+		for _, h := range ex.Message.Mail.Headers {
+			name := textproto.CanonicalMIMEHeaderKey(h.Name)
+			header[name] = append(header[name], h.Value)
+		}
+	}
+	return header
+}
+
+func trimID(id string) string {
+	return strings.Trim(strings.TrimSpace(id), "<>")
+}
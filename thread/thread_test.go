@@ -0,0 +1,195 @@
+package thread
+
+import (
+	"context"
+	"os"
+	"reflect"
+	"testing"
+
+	awshandler "github.com/igorrendulic/couchdb-email-aws-parse"
+	"github.com/igorrendulic/couchdb-email-aws-parse/awstest"
+)
+
+// SES messageId of test_data/delivery.json
+const deliveredID = "0000014644fe5ef6-9a483358-9170-4cb4-a269-f5dcdf415321-000000"
+
+const replyMime = "From: Jane Doe <jane@example.com>\r\n" +
+	"To: support@example.io\r\n" +
+	"Subject: Re: Hello\r\n" +
+	"Message-ID: <reply-1@mail.example.com>\r\n" +
+	"In-Reply-To: <" + deliveredID + "@us-west-2.amazonses.com>\r\n" +
+	"References: <" + deliveredID + "@us-west-2.amazonses.com>\r\n" +
+	"Content-Type: multipart/alternative; boundary=b1\r\n" +
+	"\r\n" +
+	"--b1\r\n" +
+	"Content-Type: text/plain; charset=utf-8\r\n" +
+	"Content-Transfer-Encoding: quoted-printable\r\n" +
+	"\r\n" +
+	"Thanks, that fixed it. The invoice number is 4=\r\n" +
+	"2.\r\n" +
+	"\r\n" +
+	"Jane\r\n" +
+	"-- \r\n" +
+	"Jane Doe | Example Corp\r\n" +
+	"\r\n" +
+	"On Mon, 13 Mar 2023 at 21:08, Support <support@example.io> wrote:\r\n" +
+	"> Hello\r\n" +
+	"--b1\r\n" +
+	"Content-Type: text/html; charset=utf-8\r\n" +
+	"\r\n" +
+	"<p>Thanks, that fixed it.</p>\r\n" +
+	"--b1--\r\n"
+
+func TestThreaderMiddleware(t *testing.T) {
+	s3Server := awstest.NewS3()
+	s3Server.Put("dev-mailiomailplainreceived", "4lemd3cvrcl5fefchgm609pk8iihuj0hhca8fe81", []byte(replyMime))
+	h, err := awshandler.NewAwsSmtpHandlerWithOptions(awshandler.WithS3(s3Server))
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := NewMemoryStore()
+	h.Use(NewThreader(store).Middleware())
+
+	process := func(path string) *awshandler.Exchange {
+		payload, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ex, err := h.Process(payload)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ex
+	}
+
+	process("../test_data/delivery.json")
+	sent, _ := store.Get(context.Background(), deliveredID)
+	if sent == nil || !sent.Outbound || sent.MessageID != "custom-message-ID" || sent.ThreadID != "custom-message-ID" {
+		t.Fatalf("delivery not recorded: %+v", sent)
+	}
+
+	th, ok := process("../test_data/received.json").Annotations[AnnotationThread].(*Thread)
+	if !ok {
+		t.Fatal("expected thread annotation")
+	}
+	if !th.Reply || th.Parent != sent || th.ID != "custom-message-ID" || th.MessageID != "reply-1@mail.example.com" {
+		t.Fatalf("unexpected thread %+v", th)
+	}
+	if th.Body != "Thanks, that fixed it. The invoice number is 42.\n\nJane" {
+		t.Fatalf("unexpected body %q", th.Body)
+	}
+	if entry, _ := store.Get(context.Background(), "reply-1@mail.example.com"); entry == nil || entry.Outbound || entry.ThreadID != "custom-message-ID" {
+		t.Fatalf("reply not recorded: %+v", entry)
+	}
+
+	// new conversation
+	s3Server.Put("dev-mailiomailplainreceived", "4lemd3cvrcl5fefchgm609pk8iihuj0hhca8fe81", []byte("Message-ID: <new@mail.example.com>\r\nSubject: Help\r\n\r\nhello\r\n"))
+	th = process("../test_data/received.json").Annotations[AnnotationThread].(*Thread)
+	if th.Reply || th.Parent != nil || th.ID != "new@mail.example.com" || th.Body != "hello" {
+		t.Fatalf("unexpected thread %+v", th)
+	}
+}
+
+func TestThreadReferences(t *testing.T) {
+	threader := NewThreader(NewMemoryStore())
+	if _, err := threader.RecordSent(context.Background(), "ses-1", "<root@example.io>", nil, 1); err != nil {
+		t.Fatal(err)
+	}
+	// our follow-up in the same thread, Message-ID set by SES
+	followUp, err := threader.RecordSent(context.Background(), "ses-2", "", []string{"root@example.io"}, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if followUp.MessageID != "ses-2@email.amazonses.com" || followUp.ThreadID != "root@example.io" {
+		t.Fatalf("unexpected follow-up %+v", followUp)
+	}
+
+	header := map[string][]string{
+		"Message-Id":  {"<r2@mail.example.com>"},
+		"In-Reply-To": {"<unknown@mail.example.com>"},
+		"References":  {"<root@example.io> <ses-2@email.amazonses.com>\r\n <unknown@mail.example.com>"},
+	}
+	th, err := threader.Thread(context.Background(), header, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// most recent referenced outbound message is the parent
+	if !th.Reply || th.Parent.SESMessageID != "ses-2" || th.ID != "root@example.io" {
+		t.Fatalf("unexpected thread %+v", th)
+	}
+
+	// our own message received back (e.g. Bcc to a monitored address) stays outbound
+	th, err = threader.Thread(context.Background(), map[string][]string{"Message-Id": {"<ses-2@email.amazonses.com>"}}, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry, _ := threader.store.Get(context.Background(), "ses-2@email.amazonses.com")
+	if th.ID != "root@example.io" || entry == nil || !entry.Outbound || entry.SESMessageID != "ses-2" || entry.Timestamp != 2 {
+		t.Fatalf("outbound entry overwritten: %+v", entry)
+	}
+}
+
+func TestMessageIDs(t *testing.T) {
+	tests := map[string][]string{
+		"<a@example.com>":                      {"a@example.com"},
+		"<a@example.com>\r\n\t<b@example.com>": {"a@example.com", "b@example.com"},
+		"<a@example.com> (comment) <>":         {"a@example.com"},
+		"a@example.com":                        {"a@example.com"},
+		"":                                     {},
+		"<unterminated@example.com":            {},
+	}
+	for value, want := range tests {
+		if got := MessageIDs(value); !reflect.DeepEqual(got, want) {
+			t.Errorf("%q: expected %v, got %v", value, want, got)
+		}
+	}
+
+	if id, ok := SESMessageID(deliveredID + "@eu-west-1.amazonses.com"); !ok || id != deliveredID {
+		t.Fatalf("unexpected SES messageId %q", id)
+	}
+	for _, id := range []string{"a@example.com", "a@notamazonses.com", "amazonses.com"} {
+		if _, ok := SESMessageID(id); ok {
+			t.Errorf("%q: not an SES Message-ID", id)
+		}
+	}
+}
+
+func TestStripReply(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want string
+	}{
+		{"gmail", "Sounds good.\n\nOn Tue, 14 Mar 2023 at 10:00, Support <support@example.io> wrote:\n> Can we call?\n", "Sounds good."},
+		{"wrapped attribution", "Yes\r\n\r\nOn Tue, 14 Mar 2023 at 10:00, Support Team <support@example.io>\r\nwrote:\r\n> Can we call?\r\n", "Yes"},
+		{"outlook", "Done.\n\n________________________________\nFrom: Support <support@example.io>\nSent: Tuesday, March 14, 2023 10:00 AM\n", "Done."},
+		{"outlook without separator", "Done.\n\nFrom: Support <support@example.io>\nSent: Tuesday, March 14, 2023 10:00 AM\nTo: Jane\n", "Done."},
+		{"original message", "Ok\n-----Original Message-----\nFrom: x\n", "Ok"},
+		{"interleaved", "> first question\nfirst answer\n> second question\nsecond answer", "first answer\nsecond answer"},
+		{"signature", "Ok\n-- \nJane Doe\n+1 555 0100", "Ok"},
+		{"mobile", "Ok\n\nSent from my iPhone", "Ok"},
+		{"german", "Danke\n\nAm 14.03.2023 um 10:00 schrieb Support <support@example.io>:\n> Hallo", "Danke"},
+		{"from in content", "From: the start it worked.\nThanks", "From: the start it worked.\nThanks"},
+	}
+	for _, test := range tests {
+		if got := StripReply(test.text); got != test.want {
+			t.Errorf("%s: expected %q, got %q", test.name, test.want, got)
+		}
+	}
+}
+
+func TestTextBody(t *testing.T) {
+	text, err := TextBody([]byte("Content-Type: multipart/mixed; boundary=b1\r\n\r\n" +
+		"--b1\r\nContent-Type: text/plain\r\nContent-Disposition: attachment; filename=notes.txt\r\n\r\nattached\r\n" +
+		"--b1\r\nContent-Type: multipart/alternative; boundary=b2\r\n\r\n" +
+		"--b2\r\nContent-Type: text/plain\r\nContent-Transfer-Encoding: base64\r\n\r\naGVsbG8g\r\nd29ybGQ=\r\n" +
+		"--b2--\r\n--b1--\r\n"))
+	if err != nil || text != "hello world" {
+		t.Fatalf("unexpected text %q: %v", text, err)
+	}
+
+	text, err = TextBody([]byte("Content-Type: text/html\r\n\r\n<p>hello</p>\r\n"))
+	if err != nil || text != "" {
+		t.Fatalf("expected no text body, got %q: %v", text, err)
+	}
+}
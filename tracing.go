@@ -0,0 +1,52 @@
+package awshandler
+
+import (
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// instrumentation name of the tracer
+const tracerName = "github.com/igorrendulic/couchdb-email-aws-parse"
+
+// SetTracerProvider enables OpenTelemetry tracing of message handling (including SNS signature verification).
+// Tracing is disabled (noop) by default.
+func (p *AwsSmtpHandler) SetTracerProvider(tp trace.TracerProvider) {
+	if tp == nil {
+		tp = trace.NewNoopTracerProvider()
+	}
+	p.tracer = tp.Tracer(tracerName)
+	p.verifier.Tracer = p.tracer
+}
+
+// span attributes identifying handled notification
+func exchangeAttributes(ex *Exchange) []attribute.KeyValue {
+	attrs := []attribute.KeyValue{}
+	if ex.Envelope != nil {
+		attrs = append(attrs,
+			attribute.String("sns.topic_arn", ex.Envelope.TopicArn),
+			attribute.String("sns.message_id", ex.Envelope.MessageId),
+		)
+	}
+	if ex.Message != nil {
+		attrs = append(attrs, attribute.String("ses.notification_type", ex.Message.NotificationType))
+		if ex.Message.Mail != nil {
+			attrs = append(attrs, attribute.String("ses.message_id", ex.Message.Mail.MessageID))
+		}
+	}
+	if ex.Quarantine != nil {
+		attrs = append(attrs, attribute.String("ses.quarantine", ex.Quarantine.Action.String()))
+	}
+	if ex.AutoReply != nil {
+		attrs = append(attrs, attribute.String("ses.auto_reply", string(ex.AutoReply.Kind)))
+	}
+	return attrs
+}
+
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
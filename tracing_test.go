@@ -0,0 +1,109 @@
+package awshandler
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/igorrendulic/couchdb-email-aws-parse/sns"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func recordedSpans(sr *tracetest.SpanRecorder) map[string]sdktrace.ReadOnlySpan {
+	spans := map[string]sdktrace.ReadOnlySpan{}
+	for _, s := range sr.Ended() {
+		spans[s.Name()] = s
+	}
+	return spans
+}
+
+func spanAttribute(span sdktrace.ReadOnlySpan, key string) string {
+	for _, kv := range span.Attributes() {
+		if string(kv.Key) == key {
+			return kv.Value.Emit()
+		}
+	}
+	return ""
+}
+
+func TestTracingReceived(t *testing.T) {
+	received, err := LoadPayload("test_data/received.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sr := tracetest.NewSpanRecorder()
+	svc, _, _ := dlLoggingSvc([]byte("mime content"))
+	smtpHandler := newAwsSmtpHandler(svc)
+	smtpHandler.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(sr)))
+
+	if _, err := smtpHandler.HandleSmtp(received); err != nil {
+		t.Fatal(err)
+	}
+
+	spans := recordedSpans(sr)
+	root, ok := spans["ses.HandleSmtp"]
+	if !ok {
+		t.Fatalf("expected root span, got %v", spans)
+	}
+	if spanAttribute(root, "ses.message_id") != "4lemd3cvrcl5fefchgm609pk8iihuj0hhca8fe81" {
+		t.Fatalf("expected SES message id on root span, got %v", root.Attributes())
+	}
+
+	mapping := spans["ses.Map"]
+	download := spans["s3.Download"]
+	if mapping == nil || download == nil {
+		t.Fatalf("expected mapping and download spans, got %v", spans)
+	}
+	if mapping.Parent().SpanID() != root.SpanContext().SpanID() || download.Parent().SpanID() != mapping.SpanContext().SpanID() {
+		t.Fatalf("unexpected span hierarchy")
+	}
+	if spanAttribute(download, "s3.bucket") == "" || spanAttribute(download, "s3.key") == "" {
+		t.Fatalf("expected s3 location on download span, got %v", download.Attributes())
+	}
+	if spanAttribute(download, "s3.object_size") != "12" {
+		t.Fatalf("expected object size on download span, got %v", download.Attributes())
+	}
+}
+
+func TestTracingSignatureFailure(t *testing.T) {
+	confirmation, err := json.Marshal(&sns.Payload{
+		Type:           "SubscriptionConfirmation",
+		MessageId:      "165545c9-2a5c-472c-8df2-7ff2be2b3b1b",
+		TopicArn:       "arn:aws:sns:us-west-2:123456:received",
+		SigningCertURL: "http://sns.us-west-2.amazonaws.com/cert.pem",
+		Timestamp:      "2016-01-27T14:59:38.237Z",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sr := tracetest.NewSpanRecorder()
+	svc, _, _ := dlLoggingSvc([]byte{})
+	smtpHandler := newAwsSmtpHandler(svc)
+	smtpHandler.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(sr)))
+
+	if _, err := smtpHandler.HandleSmtp(confirmation); !errors.Is(err, SignatureInvalid) {
+		t.Fatalf("expected signature error, got %v", err)
+	}
+
+	spans := recordedSpans(sr)
+	verify, ok := spans["sns.VerifyPayload"]
+	if !ok {
+		t.Fatalf("expected verification span, got %v", spans)
+	}
+	if verify.Status().Code != codes.Error {
+		t.Fatalf("expected verification span to record error")
+	}
+	if spanAttribute(verify, "sns.topic_arn") != "arn:aws:sns:us-west-2:123456:received" {
+		t.Fatalf("expected topic arn on verification span, got %v", verify.Attributes())
+	}
+	if _, ok := spans["sns.Subscribe"]; ok {
+		t.Fatalf("subscription should not be attempted")
+	}
+	if spans["ses.HandleSmtp"].Status().Code != codes.Error {
+		t.Fatalf("expected root span to record error")
+	}
+}
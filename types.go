@@ -11,6 +11,17 @@ package awshandler
 // 	AwsAccessKey string
 // }
 
+// NotificationType is the type of SES notification (MailReceived.NotificationType)
+type NotificationType string
+
+const (
+	NotificationTypeReceived                 NotificationType = "Received"
+	NotificationTypeBounce                   NotificationType = "Bounce"
+	NotificationTypeComplaint                NotificationType = "Complaint"
+	NotificationTypeDelivery                 NotificationType = "Delivery"
+	NotificationTypeSubscriptionConfirmation NotificationType = "SubscriptionConfirmation" // SNS subscription confirmation
+)
+
 // Amazon SNS Received message parsing for email
 type Mail struct {
 	Timestamp        string             `json:"timestamp"`
@@ -40,6 +51,7 @@ type Bounce struct {
 	ReportingMTA      string              `json:"reportingMTA,omitempty"`  // e.g. "dns; email.example.com", The value of the Reporting-MTA field from the DSN. This is the value of the MTA that attempted to perform the delivery, relay, or gateway operation described in the DSN.
 	BouncedRecipients []*BouncedRecipient `json:"bouncedRecipients"`       //  e.g. {"emailAddress":"jane@example.com","status":"5.1.1","action":"failed","diagnosticCode":"smtp; 550 5.1.1 <jane@example.com>... User"}
 	RemoteMtaIp       string              `json:"remoteMtaIp,omitempty"`   // e.g. 127.0.0.1" The IP address of the MTA to which Amazon SES attempted to deliver the email.
+	Timestamp         string              `json:"timestamp,omitempty"`     // e.g. 2016-01-27T14:59:38.237Z, when the bounce was sent by the ISP
 }
 
 // optional field, only present if the message was a complaint
@@ -47,6 +59,8 @@ type Complaint struct {
 	UserAgent             string                 `json:"userAgent,omitempty"`             // e.g. AnyCompany Feedback Loop (V0.01)
 	ComplainedRecipients  []*ComplainedRecipient `json:"complainedRecipients"`            // e.g. [{"emailAddress":"
 	ComplaintFeedbackType string                 `json:"complaintFeedbackType,omitempty"` // e.g. abuse
+	ArrivalDate           string                 `json:"arrivalDate,omitempty"`           // e.g. 2016-01-27T14:59:38.237Z, when the feedback report was received by the ISP
+	Timestamp             string                 `json:"timestamp,omitempty"`             // e.g. 2016-01-27T14:59:38.237Z, when the complaint was received by SES
 }
 
 // optional field, only present if the message was delivered (not necessary to use really)
@@ -60,6 +74,7 @@ type Delivery struct {
 
 type MessageJSON struct {
 	NotificationType string     `json:"notificationType"`
+	EventType        string     `json:"eventType,omitempty"` // SES event publishing (e.g. EventBridge) equivalent of NotificationType
 	Mail             *Mail      `json:"mail,omitempty"`
 	Receipt          *Receipt   `json:"receipt,omitempty"`
 	Bounce           *Bounce    `json:"bounce,omitempty"`
@@ -89,6 +104,7 @@ type Receipt struct {
 	SpfVerdict           *VerdictStatus `json:"spfVerdict"`
 	DkimVerdict          *VerdictStatus `json:"dkimVerdict"`
 	DmarcVerdict         *VerdictStatus `json:"dmarcVerdict"`
+	DmarcPolicy          string         `json:"dmarcPolicy,omitempty"` // published DMARC policy of the sender domain (none, quarantine, reject), only present if DMARC failed
 	Action               *Action        `json:"action"`
 }
 